@@ -1,87 +1,59 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"pndpd/modules"
 	"pndpd/pndp"
-	"strings"
 )
 
 func readConfig(dest string) {
-	file, err := os.Open(dest)
+	config, err := modules.ParseConfig(dest)
 	if err != nil {
 		configFatalError(err, "")
 	}
-	var (
-		currentOption string
-		blockMap      map[string][]string
-	)
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		line, _, _ = strings.Cut(line, "//")
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
+	if debug := config.Options["debug"]; len(debug) != 0 && debug[0] == "on" {
+		pndp.EnableDebugLog()
+	}
 
-		if after, found := strings.CutPrefix(line, "debug"); found {
-			if strings.TrimSpace(after) == "on" {
-				pndp.EnableDebugLog()
-			}
-			continue
+	var capture *pndp.Capture
+	if captureOptions := config.Options["capture"]; captureOptions != nil {
+		if len(captureOptions) > 1 {
+			configFatalError(nil, "Only one global capture file may be specified")
 		}
-
-		if option, after, found := strings.Cut(line, "{"); found {
-			if after != "" {
-				configFatalError(nil, "Nothing may follow after '{'. A new line must be used")
-			}
-			if blockMap != nil {
-				configFatalError(nil, "A new '{' block was started before the previous one was closed")
-			}
-			currentOption = strings.TrimSpace(option)
-			blockMap = make(map[string][]string)
-			continue
+		path, maxSize, err := pndp.ParseCaptureOption(captureOptions[0])
+		if err != nil {
+			configFatalError(err, "Invalid capture option")
 		}
-
-		if before, after, found := strings.Cut(line, "}"); found {
-			if after != "" || before != "" {
-				configFatalError(nil, "Nothing may precede or follow '}'. A new line must be used")
-			}
-			if blockMap == nil {
-				configFatalError(nil, "Found a '}' tag without a matching '{' tag.")
-			}
-			module, command := modules.GetCommand(currentOption, modules.Config)
-			if module == nil {
-				configFatalError(nil, "Unknown configuration block: "+currentOption)
-			}
-			modules.ExecuteInit(module, modules.CallbackInfo{
-				CallbackType: modules.Config,
-				Command:      command,
-				Config:       blockMap,
-			})
-			blockMap = nil
-			continue
+		capture, err = pndp.OpenCapture(path, maxSize)
+		if err != nil {
+			configFatalError(err, "")
 		}
+		pndp.SetDefaultCapture(capture)
+	}
 
-		if blockMap != nil {
-			kv := strings.SplitN(line, " ", 2)
-			if len(kv) != 2 {
-				configFatalError(nil, "Key without value")
-			}
-			if blockMap[kv[0]] == nil {
-				blockMap[kv[0]] = make([]string, 0)
-			}
-			blockMap[kv[0]] = append(blockMap[kv[0]], kv[1])
+	var controlSocket *pndp.ControlSocket
+	if controlOptions := config.Options["control-socket"]; controlOptions != nil {
+		if len(controlOptions) > 1 {
+			configFatalError(nil, "Only one control socket may be specified")
+		}
+		controlSocket, err = pndp.ListenControlSocket(controlOptions[0])
+		if err != nil {
+			configFatalError(err, "Unable to create the control socket")
 		}
 	}
-	_ = file.Close()
 
-	if err := scanner.Err(); err != nil {
-		configFatalError(err, "")
+	for _, block := range config.Blocks {
+		module, command := modules.GetCommand(block.Name, modules.Config)
+		if module == nil {
+			configFatalError(nil, "Unknown configuration block: "+block.Name)
+		}
+		modules.ExecuteInit(module, modules.CallbackInfo{
+			CallbackType: modules.Config,
+			Command:      command,
+			Config:       block.Options,
+		})
 	}
 
 	if modules.ExistsBlockingModule() {
@@ -89,6 +61,12 @@ func readConfig(dest string) {
 		waitForSignal()
 		modules.ShutdownAll()
 	}
+	if capture != nil {
+		_ = capture.Close()
+	}
+	if controlSocket != nil {
+		_ = controlSocket.Close()
+	}
 }
 
 func configFatalError(err error, explanation string) {
@@ -16,13 +16,18 @@ var Version = "Custom_Build"
 func main() {
 	fmt.Println("PNDPD Version", Version, "- Kioubit")
 
-	if len(os.Args) <= 2 {
+	// Commands check their own arguments, as some of them (such as counters) have only optional ones
+	if len(os.Args) < 2 {
 		printUsage()
 		return
 	}
 
 	switch os.Args[1] {
 	case "config":
+		if len(os.Args) != 3 {
+			printUsage()
+			return
+		}
 		readConfig(os.Args[2])
 	default:
 		module, command := modules.GetCommand(os.Args[1], modules.CommandLine)
@@ -0,0 +1,54 @@
+//go:build !noUserInterface
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mainEnv makes the test binary run main() with its arguments instead of the tests
+const mainEnv = "PNDPD_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runPndpd runs main() with args in a new process and returns its output
+func runPndpd(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), mainEnv+"=1")
+	output, _ := cmd.CombinedOutput()
+	return string(output)
+}
+
+func TestCommandArguments(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "pndpd.sock")
+	for _, tc := range []struct {
+		name  string
+		args  []string
+		usage bool
+	}{
+		{"no command", nil, true},
+		{"config without path", []string{"config"}, true},
+		{"unknown command", []string{"unknown"}, true},
+		// All arguments of counters are optional
+		{"counters without arguments", []string{"counters"}, false},
+		{"counters with socket", []string{"counters", "--socket", socketPath}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			output := runPndpd(t, tc.args...)
+			if strings.Contains(output, "Usage:") != tc.usage {
+				t.Errorf("Expected usage %t, but got %q", tc.usage, output)
+			}
+		})
+	}
+}
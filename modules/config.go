@@ -0,0 +1,92 @@
+package modules
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ConfigFile is the content of a config file
+type ConfigFile struct {
+	// Options are the "key value" lines outside of blocks
+	Options map[string][]string
+	// Blocks are the "name { ... }" blocks in the order they appear
+	Blocks []ConfigBlock
+}
+
+// ConfigBlock is a "name { ... }" block of a config file. Each line inside of it is a "key value" pair.
+type ConfigBlock struct {
+	Name    string
+	Options map[string][]string
+}
+
+// ParseConfig reads a config file without executing any of its blocks
+func ParseConfig(path string) (*ConfigFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	config := &ConfigFile{Options: make(map[string][]string)}
+	var block *ConfigBlock
+	lineNumber := 0
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		lineError := func(explanation string) error {
+			return fmt.Errorf("line %d: %s", lineNumber, explanation)
+		}
+
+		line := scanner.Text()
+		line, _, _ = strings.Cut(line, "//")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if option, after, found := strings.Cut(line, "{"); found {
+			if after != "" {
+				return nil, lineError("Nothing may follow after '{'. A new line must be used")
+			}
+			if block != nil {
+				return nil, lineError("A new '{' block was started before the previous one was closed")
+			}
+			block = &ConfigBlock{Name: strings.TrimSpace(option), Options: make(map[string][]string)}
+			continue
+		}
+
+		if before, after, found := strings.Cut(line, "}"); found {
+			if after != "" || before != "" {
+				return nil, lineError("Nothing may precede or follow '}'. A new line must be used")
+			}
+			if block == nil {
+				return nil, lineError("Found a '}' tag without a matching '{' tag.")
+			}
+			config.Blocks = append(config.Blocks, *block)
+			block = nil
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		if block != nil {
+			if value == "" {
+				return nil, lineError("Key without value")
+			}
+			block.Options[key] = append(block.Options[key], value)
+		} else {
+			config.Options[key] = append(config.Options[key], value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if block != nil {
+		return nil, errors.New("the block \"" + block.Name + "\" was not closed")
+	}
+	return config, nil
+}
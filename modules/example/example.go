@@ -4,8 +4,12 @@
 package example
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"net"
 	"pndpd/modules"
+	"pndpd/pndp"
 )
 
 // This is an example module
@@ -25,6 +29,22 @@ func init() {
 	},
 	}
 	modules.RegisterModule("Example", commands, initCallback, completeCallback, shutdownCallback)
+
+	// Modules can provide policies that are attached to proxy and responder instances
+	// in the config file with "policy example"
+	pndp.RegisterPolicy("example", examplePolicy{})
+}
+
+// examplePolicy refuses to answer for the subnet-router anycast address of every /64 and leaves all other
+// decisions to the next policy in the chain
+type examplePolicy struct{}
+
+func (examplePolicy) Allow(_ context.Context, iface string, asker net.IP, target net.IP) pndp.Decision {
+	if target.Mask(net.CIDRMask(64, 128)).Equal(target) {
+		slog.Debug("Example policy denied", "target", target, "askedBy", asker, "interface", iface)
+		return pndp.PolicyDeny
+	}
+	return pndp.PolicyAbstain
 }
 
 func initCallback(callback modules.CallbackInfo) {
@@ -0,0 +1,50 @@
+//go:build !noUserInterface
+
+package userInterface
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"pndpd/pndp"
+	"slices"
+)
+
+// counters prints the number of packets the instances of a running daemon have dropped per reason,
+// the targets that did not answer Neighbor Unreachability Detection probes and, for instances in observe mode,
+// the packets that would have been sent
+func counters(arguments []string) {
+	flags := flag.NewFlagSet("counters", flag.ContinueOnError)
+	socketPath := flags.String("socket", pndp.DefaultControlSocket, "control socket of the running daemon")
+	if err := flags.Parse(arguments); err != nil {
+		showError("counters: " + err.Error())
+	}
+	if flags.NArg() != 0 {
+		showError("counters: unexpected arguments")
+	}
+
+	counters, err := pndp.ReadCounters(*socketPath)
+	if err != nil {
+		showError("counters: " + err.Error())
+	}
+	if len(counters.Drops) == 0 {
+		fmt.Println("No packets have been dropped")
+	}
+	printCounts("dropped packets per reason", counters.Drops, counters.DryRun)
+	printCounts("unanswered NUD probes per target", counters.NUDFailures, counters.DryRun)
+	printCounts("packets that would have been sent", counters.WouldSend, counters.DryRun)
+}
+
+// printCounts prints the counts of every instance. Instances in observe mode are labeled dry-run.
+func printCounts(title string, counts map[string]map[string]uint64, dryRun map[string]bool) {
+	for _, instance := range slices.Sorted(maps.Keys(counts)) {
+		if dryRun[instance] {
+			fmt.Printf("%s (%s, dry-run):\n", instance, title)
+		} else {
+			fmt.Printf("%s (%s):\n", instance, title)
+		}
+		for _, key := range slices.Sorted(maps.Keys(counts[instance])) {
+			fmt.Printf("    %-40s %d\n", key, counts[instance][key])
+		}
+	}
+}
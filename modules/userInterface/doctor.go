@@ -0,0 +1,75 @@
+//go:build !noUserInterface
+
+package userInterface
+
+import (
+	"fmt"
+	"os"
+	"pndpd/modules"
+	"pndpd/pndp"
+	"strings"
+)
+
+// doctor checks the host configuration that the instances of a config file depend on
+func doctor(arguments []string) {
+	if len(arguments) != 1 {
+		showError("doctor: expected <path to config file>")
+	}
+	// Config errors are findings as well, so that the host is checked in any case
+	var findings []pndp.Finding
+	config, err := modules.ParseConfig(arguments[0])
+	if err != nil {
+		findings = append(findings, pndp.Finding{Severity: pndp.SeverityError, Message: "config: " + err.Error()})
+		config = &modules.ConfigFile{}
+	}
+
+	for _, block := range config.Blocks {
+		switch block.Name {
+		case "proxy":
+			n, err := parseProxyConfig(block.Options)
+			if err != nil {
+				findings = append(findings, pndp.Finding{Severity: pndp.SeverityError, Message: err.Error()})
+				continue
+			}
+			o := n.build(false)
+			if pndp.IsInterfacePattern(n.Iface2) {
+				findings = append(findings, pndp.NewProxyGroup(o).Diagnose()...)
+			} else {
+				findings = append(findings, o.Diagnose()...)
+			}
+		case "responder":
+			n, err := parseResponderConfig(block.Options)
+			if err != nil {
+				findings = append(findings, pndp.Finding{Severity: pndp.SeverityError, Message: err.Error()})
+				continue
+			}
+			findings = append(findings, n.build(false).Diagnose()...)
+		default:
+			if module, _ := modules.GetCommand(block.Name, modules.Config); module == nil {
+				findings = append(findings, pndp.Finding{Severity: pndp.SeverityError, Message: "Unknown configuration block: " + block.Name})
+			}
+		}
+	}
+	socketPath := pndp.DefaultControlSocket
+	if options := config.Options["control-socket"]; len(options) != 0 {
+		socketPath = options[0]
+	}
+	findings = append(findings, pndp.DiagnoseHost(socketPath)...)
+
+	counts := make(map[pndp.Severity]int)
+	for _, finding := range findings {
+		counts[finding.Severity]++
+		line := "[" + strings.ToUpper(finding.Severity.String()) + "] "
+		if finding.Instance != "" {
+			line += finding.Instance + ": "
+		}
+		fmt.Println(line + finding.Message)
+		if finding.Fix != "" {
+			fmt.Println("        fix: " + finding.Fix)
+		}
+	}
+	fmt.Printf("%d errors, %d warnings\n", counts[pndp.SeverityError], counts[pndp.SeverityWarning])
+	if counts[pndp.SeverityError] != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,116 @@
+//go:build !noUserInterface
+
+package userInterface
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"pndpd/pndp"
+	"time"
+)
+
+// probe sends Neighbor Solicitations and reports the advertisements that answer them
+func probe(arguments []string) {
+	flags := flag.NewFlagSet("probe", flag.ContinueOnError)
+	unicast := flags.Bool("unicast", false, "send the solicitation to the target instead of its solicited-node multicast address")
+	source := flags.String("source", "", "source address of the solicitation (default: selected from the interface)")
+	count := flags.Int("count", 3, "number of solicitations to send")
+	timeout := flags.Duration("timeout", time.Second, "time to wait for advertisements after each solicitation")
+	if err := flags.Parse(arguments); err != nil {
+		showError("probe: " + err.Error())
+	}
+	if flags.NArg() != 2 {
+		showError("probe: expected <interface> <target>")
+	}
+	iface := flags.Arg(0)
+	target := parseProbeAddress("probe", flags.Arg(1))
+	sourceIP := parseOptionalProbeAddress("probe", *source)
+
+	answered := false
+	for i := 0; i < *count; i++ {
+		results, err := pndp.Probe(pndp.SystemNetwork, iface, sourceIP, target, *unicast, *timeout)
+		if err != nil {
+			showError("probe: " + err.Error())
+		}
+		if len(results) == 0 {
+			fmt.Printf("Solicitation %d for %s: no response within %s\n", i+1, target, *timeout)
+		}
+		for _, result := range results {
+			answered = true
+			fmt.Printf("Solicitation %d for %s: advertisement from %s (%s) in %s, flags %s, target link-layer address %s\n",
+				i+1, target, result.Source, result.SourceMAC, result.RTT.Round(time.Microsecond), probeFlags(result), probeMAC(result.TargetMAC))
+		}
+	}
+	if !answered {
+		os.Exit(1)
+	}
+}
+
+// announce sends an unsolicited Neighbor Advertisement with the override flag
+func announce(arguments []string) {
+	flags := flag.NewFlagSet("announce", flag.ContinueOnError)
+	source := flags.String("source", "", "source address of the advertisement (default: selected from the interface)")
+	mac := flags.String("mac", "", "link-layer address to announce (default: the address of the interface)")
+	router := flags.Bool("router", false, "set the router flag")
+	if err := flags.Parse(arguments); err != nil {
+		showError("announce: " + err.Error())
+	}
+	if flags.NArg() != 2 {
+		showError("announce: expected <interface> <address>")
+	}
+	iface := flags.Arg(0)
+	addr := parseProbeAddress("announce", flags.Arg(1))
+	var hardwareAddr net.HardwareAddr
+	if *mac != "" {
+		var err error
+		if hardwareAddr, err = net.ParseMAC(*mac); err != nil {
+			showError("announce: " + err.Error())
+		}
+	}
+
+	if err := pndp.Announce(pndp.SystemNetwork, iface, parseOptionalProbeAddress("announce", *source), addr, hardwareAddr, *router); err != nil {
+		showError("announce: " + err.Error())
+	}
+	fmt.Printf("Announced %s on %s\n", addr, iface)
+}
+
+func parseProbeAddress(command string, value string) net.IP {
+	ip := net.ParseIP(value)
+	if ip == nil || ip.To4() != nil {
+		showError(command + ": " + value + " is not an IPv6 address")
+	}
+	return ip
+}
+
+func parseOptionalProbeAddress(command string, value string) net.IP {
+	if value == "" {
+		return nil
+	}
+	return parseProbeAddress(command, value)
+}
+
+func probeFlags(result pndp.ProbeResult) string {
+	flags := ""
+	if result.Router {
+		flags += "R"
+	}
+	if result.Solicited {
+		flags += "S"
+	}
+	if result.Override {
+		flags += "O"
+	}
+	if flags == "" {
+		return "none"
+	}
+	return flags
+}
+
+func probeMAC(mac net.HardwareAddr) string {
+	if mac == nil {
+		return "none"
+	}
+	return mac.String()
+}
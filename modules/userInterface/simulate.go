@@ -0,0 +1,176 @@
+//go:build !noUserInterface
+
+package userInterface
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"pndpd/modules"
+	"pndpd/pndp"
+	"pndpd/pndp/ndp"
+	"strings"
+	"time"
+)
+
+// ifaceFlags collects the repeated --iface arguments of the simulate command
+type ifaceFlags []string
+
+func (f *ifaceFlags) String() string { return strings.Join(*f, " ") }
+func (f *ifaceFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// simulate runs the proxy and responder instances of a config file on the frames of a capture file
+func simulate(arguments []string) {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	configPath := flags.String("config", "", "config file with the proxy and responder instances")
+	pcapPath := flags.String("pcap", "", "pcap or pcapng file with the received frames")
+	outPath := flags.String("out", "", "pcapng file to write the frames and what would have been sent to")
+	ingress := flags.String("ingress", "", "interface the frames of pcap files (without interface names) were received on")
+	var ifaces ifaceFlags
+	flags.Var(&ifaces, "iface", "state of an interface as <name>=[<mac>,]<cidr>,... (repeatable)")
+	if err := flags.Parse(arguments); err != nil {
+		showError("simulate: " + err.Error())
+	}
+	if *configPath == "" || *pcapPath == "" {
+		showError("simulate: --config and --pcap are required")
+	}
+
+	config, err := modules.ParseConfig(*configPath)
+	if err != nil {
+		showError("simulate: config: " + err.Error())
+	}
+	frames, err := pndp.ReadCaptureFile(*pcapPath)
+	if err != nil {
+		showError("simulate: " + err.Error())
+	}
+
+	simulation := pndp.NewSimulation()
+	for _, value := range ifaces {
+		name, mac, addrs, err := parseSimulatedInterface(value)
+		if err != nil {
+			showError("simulate: --iface " + value + ": " + err.Error())
+		}
+		simulation.SetInterface(name, mac, addrs...)
+	}
+
+	defaultIface := *ingress
+	for _, block := range config.Blocks {
+		switch block.Name {
+		case "proxy":
+			n, err := parseProxyConfig(block.Options)
+			if err != nil {
+				showError(err.Error())
+			}
+			if pndp.IsInterfacePattern(n.Iface2) {
+				simulation.AddProxyGroup(pndp.NewProxyGroup(n.build(false)))
+			} else {
+				simulation.AddProxy(n.build(false))
+			}
+			if defaultIface == "" {
+				defaultIface = n.Iface1
+			}
+		case "responder":
+			n, err := parseResponderConfig(block.Options)
+			if err != nil {
+				showError(err.Error())
+			}
+			simulation.AddResponder(n.build(false))
+			if defaultIface == "" {
+				defaultIface = n.Iface
+			}
+		}
+	}
+
+	if *outPath != "" {
+		capture, err := pndp.OpenCapture(*outPath, 0)
+		if err != nil {
+			showError("simulate: " + err.Error())
+		}
+		defer func() { _ = capture.Close() }()
+		simulation.SetCapture(capture)
+	}
+
+	var start time.Time
+	if len(frames) != 0 {
+		start = frames[0].Time
+	}
+	last := -1
+	simulation.Run(context.Background(), frames, defaultIface, func(result pndp.SimulationResult) {
+		if result.Index != last {
+			fmt.Printf("%10.6f %s %s\n", result.Frame.Time.Sub(start).Seconds(), result.Frame.Iface, describeFrame(result.Frame.Frame))
+			last = result.Index
+		}
+		if result.Instance == "" {
+			fmt.Println("    no instance uses this interface")
+			return
+		}
+		if len(result.Actions) == 0 {
+			fmt.Printf("    %s: no action\n", result.Instance)
+		}
+		for _, action := range result.Actions {
+			fmt.Printf("    %s: %s\n", result.Instance, describeAction(action))
+		}
+	})
+}
+
+// parseSimulatedInterface parses <name>=[<mac>,]<cidr>,...
+func parseSimulatedInterface(value string) (string, net.HardwareAddr, []net.Addr, error) {
+	name, state, found := strings.Cut(value, "=")
+	if !found || name == "" {
+		return "", nil, nil, fmt.Errorf("expected <name>=[<mac>,]<cidr>,...")
+	}
+	var mac net.HardwareAddr
+	var addrs []net.Addr
+	for i, field := range strings.Split(state, ",") {
+		if i == 0 {
+			if parsed, err := net.ParseMAC(field); err == nil {
+				mac = parsed
+				continue
+			}
+		}
+		ip, ipNet, err := net.ParseCIDR(field)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		ipNet.IP = ip
+		addrs = append(addrs, ipNet)
+	}
+	return name, mac, addrs, nil
+}
+
+func describeFrame(frame []byte) string {
+	if len(frame) < 14 {
+		return fmt.Sprintf("frame of %d bytes", len(frame))
+	}
+	return fmt.Sprintf("from %s: %s", net.HardwareAddr(frame[6:12]), describePacket(frame[14:]))
+}
+
+func describePacket(b []byte) string {
+	p, err := ndp.ParsePacket(b)
+	if err != nil {
+		return fmt.Sprintf("undecodable packet (%s)", err)
+	}
+	switch m := p.Message.(type) {
+	case *ndp.NeighborSolicitation:
+		return fmt.Sprintf("NS %s -> %s for %s", p.Source, p.Destination, m.TargetAddress)
+	case *ndp.NeighborAdvertisement:
+		return fmt.Sprintf("NA %s -> %s for %s", p.Source, p.Destination, m.TargetAddress)
+	}
+	return fmt.Sprintf("ICMPv6 type %d %s -> %s", p.Message.Type(), p.Source, p.Destination)
+}
+
+func describeAction(action pndp.Action) string {
+	switch a := action.(type) {
+	case pndp.SendAction:
+		return fmt.Sprintf("would send on %s: %s", a.Iface, describePacket(a.Packet))
+	case pndp.InstallAction:
+		return fmt.Sprintf("waiting for an advertisement for %s on behalf of %s", a.Target, a.AskedBy)
+	case pndp.EmitAction:
+		return fmt.Sprintf("%s (%s)", a.Message, a.Reason)
+	}
+	return "unknown action"
+}
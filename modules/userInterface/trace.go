@@ -0,0 +1,298 @@
+//go:build !noUserInterface
+
+package userInterface
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"pndpd/pndp"
+	"pndpd/pndp/ndp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// traceDecisionWait is how long a solicitation or advertisement is held back to wait for the decision of the daemon
+const traceDecisionWait = 200 * time.Millisecond
+
+// traceDecisionLifetime is how long decisions of the daemon are kept for frames that have not been read yet
+const traceDecisionLifetime = 2 * time.Second
+
+type tracedFrame struct {
+	time  time.Time
+	frame []byte
+}
+
+// trace prints the Neighbor Discovery messages received on an interface together with the decisions of a running daemon
+func trace(arguments []string) {
+	flags := flag.NewFlagSet("trace", flag.ContinueOnError)
+	socketPath := flags.String("socket", pndp.DefaultControlSocket, "control socket of the running daemon")
+	if err := flags.Parse(arguments); err != nil {
+		showError("trace: " + err.Error())
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		showError("trace: expected <interface> [<target prefix>]")
+	}
+	iface := flags.Arg(0)
+	var prefix *net.IPNet
+	if flags.NArg() == 2 {
+		var err error
+		if _, prefix, err = net.ParseCIDR(flags.Arg(1)); err != nil {
+			showError("trace: " + err.Error())
+		}
+	}
+
+	conn, err := pndp.OpenTraceConn(iface)
+	if err != nil {
+		showError("trace: " + err.Error())
+	}
+	defer func() { _ = conn.Close() }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// connected stays nil (and never ready) if there is no daemon
+	var connected chan pndp.TraceDecision
+	if client, err := pndp.DialTrace(*socketPath); err != nil {
+		fmt.Printf("Not showing decisions (no daemon with a control socket at %s)\n", *socketPath)
+	} else {
+		fmt.Printf("Showing decisions of the daemon at %s\n", *socketPath)
+		defer func() { _ = client.Close() }()
+		connected = make(chan pndp.TraceDecision, 100)
+		go func() {
+			defer close(connected)
+			for {
+				decision, err := client.Next()
+				if err != nil {
+					return
+				}
+				if decision.Iface == iface {
+					connected <- decision
+				}
+			}
+		}()
+	}
+
+	frames := make(chan tracedFrame, 100)
+	go func() {
+		defer close(frames)
+		for {
+			buf := make([]byte, 65536)
+			n, err := conn.ReadFrame(buf)
+			if err != nil {
+				return
+			}
+			frames <- tracedFrame{time: time.Now(), frame: buf[:n]}
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	var pending []tracedFrame
+	var received []pndp.TraceDecision
+	ticker := time.NewTicker(traceDecisionWait / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case f, ok := <-frames:
+			if !ok {
+				return
+			}
+			if !traceMatches(f.frame, prefix) {
+				continue
+			}
+			if connected == nil || !isNeighborMessage(f.frame) {
+				fmt.Println(describeTracedFrame(f, nil, false))
+				continue
+			}
+			pending = append(pending, f)
+		case decision, ok := <-connected:
+			if !ok {
+				fmt.Println("The daemon closed the control socket")
+				connected = nil
+				continue
+			}
+			received = append(received, decision)
+		case now := <-ticker.C:
+			for len(pending) != 0 && now.Sub(pending[0].time) >= traceDecisionWait {
+				var matched []pndp.TraceDecision
+				matched, received = takeDecisions(received, pending[0].frame)
+				fmt.Println(describeTracedFrame(pending[0], matched, true))
+				pending = pending[1:]
+			}
+			for len(received) != 0 && now.Sub(received[0].Time) > traceDecisionLifetime {
+				received = received[1:]
+			}
+		}
+	}
+}
+
+// takeDecisions removes the decisions made for frame from received. The daemon receives frames truncated, so only the beginning is compared.
+func takeDecisions(received []pndp.TraceDecision, frame []byte) (matched []pndp.TraceDecision, remaining []pndp.TraceDecision) {
+	for _, decision := range received {
+		if matched == nil && bytes.HasPrefix(frame, decision.Frame) || len(matched) != 0 && bytes.Equal(matched[0].Frame, decision.Frame) {
+			matched = append(matched, decision)
+			continue
+		}
+		remaining = append(remaining, decision)
+	}
+	return matched, remaining
+}
+
+func isNeighborMessage(frame []byte) bool {
+	return len(frame) > 54 && (frame[54] == ndp.TypeNeighborSolicitation || frame[54] == ndp.TypeNeighborAdvertisement)
+}
+
+// traceMatches checks the target of a message against the prefix. Messages without a target (router solicitations and
+// advertisements) match if they announce a prefix within it.
+func traceMatches(frame []byte, prefix *net.IPNet) bool {
+	if prefix == nil {
+		return true
+	}
+	if len(frame) < 14 {
+		return false
+	}
+	p, err := ndp.ParsePacket(frame[14:])
+	if err != nil {
+		return false
+	}
+	switch m := p.Message.(type) {
+	case *ndp.NeighborSolicitation:
+		return prefix.Contains(m.TargetAddress)
+	case *ndp.NeighborAdvertisement:
+		return prefix.Contains(m.TargetAddress)
+	case *ndp.Redirect:
+		return prefix.Contains(m.TargetAddress)
+	case *ndp.RouterAdvertisement:
+		for _, option := range m.Options {
+			if info, ok := option.(*ndp.PrefixInformation); ok && prefix.Contains(info.Prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describeTracedFrame formats a frame as a single line. If showDecisions is set, the decisions of the daemon are appended after "=>".
+func describeTracedFrame(f tracedFrame, decisions []pndp.TraceDecision, showDecisions bool) string {
+	line := f.time.Format("15:04:05.000000") + " "
+	if len(f.frame) < 14 {
+		return line + fmt.Sprintf("frame of %d bytes", len(f.frame))
+	}
+	line += fmt.Sprintf("%s > %s ", net.HardwareAddr(f.frame[6:12]), net.HardwareAddr(f.frame[0:6]))
+	p, err := ndp.ParsePacket(f.frame[14:])
+	if err != nil {
+		line += fmt.Sprintf("undecodable packet (%s)", err)
+	} else {
+		line += describeTracedPacket(p)
+	}
+
+	if showDecisions {
+		if len(decisions) == 0 {
+			line += " => no decision by the daemon"
+		}
+		for i, decision := range decisions {
+			if i == 0 {
+				line += " => "
+			} else {
+				line += " | "
+			}
+			line += decision.Instance + ": " + decision.Decision
+		}
+	}
+	return line
+}
+
+func describeTracedPacket(p *ndp.Packet) string {
+	var fields []string
+	var options []ndp.Option
+	switch m := p.Message.(type) {
+	case *ndp.RouterSolicitation:
+		fields = append(fields, "RS")
+		options = m.Options
+	case *ndp.RouterAdvertisement:
+		fields = append(fields, "RA", fmt.Sprintf("hop-limit %d", m.CurrentHopLimit), "lifetime "+describeLifetime(m.RouterLifetime))
+		if m.ManagedConfiguration {
+			fields = append(fields, "managed")
+		}
+		if m.OtherConfiguration {
+			fields = append(fields, "other")
+		}
+		options = m.Options
+	case *ndp.NeighborSolicitation:
+		fields = append(fields, "NS", "target "+m.TargetAddress.String())
+		options = m.Options
+	case *ndp.NeighborAdvertisement:
+		fields = append(fields, "NA", "target "+m.TargetAddress.String(), "flags "+describeAdvertisementFlags(m))
+		options = m.Options
+	case *ndp.Redirect:
+		fields = append(fields, "Redirect", "target "+m.TargetAddress.String(), "destination "+m.DestinationAddress.String())
+		options = m.Options
+	}
+	line := fmt.Sprintf("%s > %s %s", p.Source, p.Destination, strings.Join(fields, " "))
+	for _, option := range options {
+		line += " [" + describeOption(option) + "]"
+	}
+	return line
+}
+
+func describeAdvertisementFlags(m *ndp.NeighborAdvertisement) string {
+	flags := ""
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{m.Router, "R"}, {m.Solicited, "S"}, {m.Override, "O"}} {
+		if flag.set {
+			flags += flag.name
+		}
+	}
+	if flags == "" {
+		return "none"
+	}
+	return flags
+}
+
+func describeOption(option ndp.Option) string {
+	switch o := option.(type) {
+	case *ndp.LinkLayerAddress:
+		if o.Target {
+			return "tll " + o.Addr.String()
+		}
+		return "sll " + o.Addr.String()
+	case *ndp.PrefixInformation:
+		flags := ""
+		if o.OnLink {
+			flags += " on-link"
+		}
+		if o.Autonomous {
+			flags += " autonomous"
+		}
+		return fmt.Sprintf("prefix %s/%d%s valid %s preferred %s", o.Prefix, o.PrefixLength, flags, describeLifetime(o.ValidLifetime), describeLifetime(o.PreferredLifetime))
+	case *ndp.MTU:
+		return fmt.Sprintf("mtu %d", uint32(*o))
+	case *ndp.RDNSS:
+		servers := make([]string, len(o.Servers))
+		for i, server := range o.Servers {
+			servers[i] = server.String()
+		}
+		return fmt.Sprintf("rdnss %s lifetime %s", strings.Join(servers, ","), describeLifetime(o.Lifetime))
+	case *ndp.RawOption:
+		return fmt.Sprintf("option %d %x", o.OptionType, o.Value)
+	}
+	return fmt.Sprintf("option %d", option.Type())
+}
+
+func describeLifetime(d time.Duration) string {
+	if d == ndp.InfiniteLifetime {
+		return "infinite"
+	}
+	return d.String()
+}
@@ -3,8 +3,11 @@
 package userInterface
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path"
 	"pndpd/modules"
 	"pndpd/pndp"
 	"strings"
@@ -23,6 +26,42 @@ func init() {
 		BlockTerminate:     true,
 		ConfigEnabled:      true,
 		CommandLineEnabled: true,
+	}, {
+		CommandText:        "trace",
+		Description:        "pndpd trace [--socket <control socket of the daemon>] <interface> [<target prefix>]",
+		BlockTerminate:     false,
+		ConfigEnabled:      false,
+		CommandLineEnabled: true,
+	}, {
+		CommandText:        "counters",
+		Description:        "pndpd counters [--socket <control socket of the daemon>]",
+		BlockTerminate:     false,
+		ConfigEnabled:      false,
+		CommandLineEnabled: true,
+	}, {
+		CommandText:        "probe",
+		Description:        "pndpd probe [--unicast] [--source <address>] [--count <number>] [--timeout <duration>] <interface> <target>",
+		BlockTerminate:     false,
+		ConfigEnabled:      false,
+		CommandLineEnabled: true,
+	}, {
+		CommandText:        "announce",
+		Description:        "pndpd announce [--source <address>] [--mac <link-layer address>] [--router] <interface> <address>",
+		BlockTerminate:     false,
+		ConfigEnabled:      false,
+		CommandLineEnabled: true,
+	}, {
+		CommandText:        "doctor",
+		Description:        "pndpd doctor <path to config file>",
+		BlockTerminate:     false,
+		ConfigEnabled:      false,
+		CommandLineEnabled: true,
+	}, {
+		CommandText:        "simulate",
+		Description:        "pndpd simulate --config <path to file> --pcap <pcap or pcapng file> [--out <pcapng file>] [--iface <name>=[<mac>,]<cidr>,...] [--ingress <interface of the frames>]",
+		BlockTerminate:     false,
+		ConfigEnabled:      false,
+		CommandLineEnabled: true,
 	}}
 	modules.RegisterModule("Core", commands, initCallback, completeCallback, shutdownCallback)
 }
@@ -32,6 +71,18 @@ type configResponder struct {
 	Filter                string
 	autosense             string
 	DontMonitorInterfaces bool
+	policies              []string
+	capture               string
+	observe               bool
+	router                pndp.RouterFlag
+	noOverride            bool
+	strict                bool
+	sendFrames            bool
+	advertiseMAC          net.HardwareAddr
+	advertiseIface        string
+	sourceAddress         pndp.SourceAddress
+	sourceIP              net.IP
+	netns                 string
 	instance              *pndp.ResponderObj
 }
 
@@ -41,7 +92,23 @@ type configProxy struct {
 	Filter                string
 	autosense             string
 	DontMonitorInterfaces bool
+	policies              []string
+	capture               string
+	observe               bool
+	announce              bool
+	defend                bool
+	router                pndp.RouterFlag
+	noOverride            bool
+	strict                bool
+	sendFrames            bool
+	advertiseMAC          net.HardwareAddr
+	advertiseIface        string
+	sourceAddress         pndp.SourceAddress
+	sourceIP              net.IP
+	extNetns              string
+	intNetns              string
 	instance              *pndp.ProxyObj
+	group                 *pndp.ProxyGroup
 }
 
 var allResponders []*configResponder
@@ -77,8 +144,21 @@ func initCallback(callback modules.CallbackInfo) {
 			default:
 				showError("Invalid syntax")
 			}
+		case "simulate":
+			simulate(callback.Arguments)
+		case "trace":
+			trace(callback.Arguments)
+		case "counters":
+			counters(callback.Arguments)
+		case "probe":
+			probe(callback.Arguments)
+		case "announce":
+			announce(callback.Arguments)
+		case "doctor":
+			doctor(callback.Arguments)
 		case "responder":
-			if len(callback.Arguments) == 2 {
+			switch len(callback.Arguments) {
+			case 2:
 				var filter = callback.Arguments[1]
 				var autosense = ""
 				if callback.Arguments[1] == "auto" {
@@ -91,66 +171,232 @@ func initCallback(callback modules.CallbackInfo) {
 					autosense: autosense,
 					instance:  nil,
 				})
-			} else {
+			case 1:
 				allResponders = append(allResponders, &configResponder{
 					Iface:     callback.Arguments[0],
 					Filter:    "",
 					autosense: "",
 					instance:  nil,
 				})
+			default:
+				showError("Invalid syntax")
 			}
 		}
 
 	} else {
 		switch callback.Command.CommandText {
 		case "proxy":
-			obj := configProxy{}
-			obj.Iface1 = getDefaultConfValue(callback.Config["ext-iface"])
-			obj.Iface2 = getDefaultConfValue(callback.Config["int-iface"])
-			obj.autosense = getDefaultConfValue(callback.Config["autosense"])
-			obj.DontMonitorInterfaces = getDefaultConfValue(callback.Config["monitor-changes"]) == "off"
-
-			filter := ""
-			for i := range callback.Config["filter"] {
-				value := callback.Config["filter"][i]
-				if strings.Contains(value, ";") {
-					showError("config: the use of semicolons is not allowed in the filter arguments")
-				}
-				filter += value + ";"
-			}
-			obj.Filter = strings.TrimSuffix(filter, ";")
-
-			if obj.autosense != "" && obj.Filter != "" {
-				showError("config: cannot have both a filter and autosense enabled on a proxy object")
+			n, err := parseProxyConfig(callback.Config)
+			if err != nil {
+				showError(err.Error())
 			}
-			if obj.Iface2 == "" || obj.Iface1 == "" {
-				showError("config: two interfaces need to be specified in the config file for a proxy object. (ext-iface and int-iface parameters)")
-			}
-			allProxies = append(allProxies, &obj)
+			allProxies = append(allProxies, n)
 		case "responder":
-			obj := configResponder{}
-			obj.Iface = getDefaultConfValue(callback.Config["iface"])
-			obj.autosense = getDefaultConfValue(callback.Config["autosense"])
-			obj.DontMonitorInterfaces = getDefaultConfValue(callback.Config["monitor-changes"]) == "off"
-			filter := ""
-			for i := range callback.Config["filter"] {
-				value := callback.Config["filter"][i]
-				if strings.Contains(value, ";") {
-					showError("config: the use of semicolons is not allowed in the filter arguments")
-				}
-				filter += value + ";"
+			n, err := parseResponderConfig(callback.Config)
+			if err != nil {
+				showError(err.Error())
 			}
-			obj.Filter = strings.TrimSuffix(filter, ";")
+			allResponders = append(allResponders, n)
+		}
+	}
+}
 
-			if obj.autosense != "" && obj.Filter != "" {
-				showError("config: cannot have both a filter and autosense enabled on a responder object")
-			}
-			if obj.Iface == "" {
-				showError("config: interface not specified in the responder object. (iface parameter)")
-			}
-			allResponders = append(allResponders, &obj)
+func parseProxyConfig(config map[string][]string) (*configProxy, error) {
+	obj := configProxy{}
+	var err error
+	obj.Iface1 = getDefaultConfValue(config["ext-iface"])
+	obj.Iface2 = getDefaultConfValue(config["int-iface"])
+	obj.autosense = getDefaultConfValue(config["autosense"])
+	obj.DontMonitorInterfaces = getDefaultConfValue(config["monitor-changes"]) == "off"
+	obj.capture = getDefaultConfValue(config["capture"])
+	obj.announce = getDefaultConfValue(config["announce"]) == "on"
+	obj.defend = getDefaultConfValue(config["defend"]) == "on"
+	obj.noOverride = getDefaultConfValue(config["override"]) == "off"
+	obj.strict = getDefaultConfValue(config["strict"]) == "on"
+	obj.sendFrames = getDefaultConfValue(config["send-frames"]) == "on"
+	obj.extNetns = getDefaultConfValue(config["ext-netns"])
+	obj.intNetns = getDefaultConfValue(config["int-netns"])
+	if obj.policies, err = parsePolicyConfig(config["policy"]); err != nil {
+		return nil, err
+	}
+	if obj.observe, err = parseModeConfig(config["mode"]); err != nil {
+		return nil, err
+	}
+	if obj.router, err = parseRouterConfig(config["router"]); err != nil {
+		return nil, err
+	}
+	if obj.advertiseMAC, obj.advertiseIface, err = parseAdvertiseMACConfig(config["advertise-mac"]); err != nil {
+		return nil, err
+	}
+	if obj.sourceAddress, obj.sourceIP, err = parseSourceAddressConfig(config["source-address"]); err != nil {
+		return nil, err
+	}
+	if obj.Filter, err = parseFilterConfig(config["filter"]); err != nil {
+		return nil, err
+	}
+
+	if err = checkInterfaceNames(obj.Iface1, obj.Iface2, obj.autosense, obj.advertiseIface, obj.extNetns, obj.intNetns); err != nil {
+		return nil, err
+	}
+	if obj.autosense != "" && obj.Filter != "" {
+		return nil, errors.New("config: cannot have both a filter and autosense enabled on a proxy object")
+	}
+	if obj.Iface2 == "" || obj.Iface1 == "" {
+		return nil, errors.New("config: two interfaces need to be specified in the config file for a proxy object. (ext-iface and int-iface parameters)")
+	}
+	if obj.Iface1 == obj.Iface2 && pndp.NetnsPath(obj.extNetns) == pndp.NetnsPath(obj.intNetns) {
+		return nil, errors.New("config: ext-iface and int-iface must be different interfaces (they may have the same name in different network namespaces)")
+	}
+	if _, err := path.Match(obj.Iface2, ""); err != nil {
+		return nil, errors.New("config: invalid int-iface pattern \"" + obj.Iface2 + "\" (only shell patterns such as ppp* are supported)")
+	}
+	if pndp.IsInterfacePattern(obj.autosense) && obj.autosense != obj.Iface2 {
+		return nil, errors.New("config: an autosense pattern must be the same as the int-iface pattern")
+	}
+	if pndp.IsInterfacePattern(obj.Iface2) && obj.autosense != obj.Iface2 {
+		return nil, errors.New("config: an int-iface pattern requires autosense with the same pattern, as every instance would forward the solicitations for all allowed addresses")
+	}
+	return &obj, nil
+}
+
+func parseResponderConfig(config map[string][]string) (*configResponder, error) {
+	obj := configResponder{}
+	var err error
+	obj.Iface = getDefaultConfValue(config["iface"])
+	obj.autosense = getDefaultConfValue(config["autosense"])
+	obj.DontMonitorInterfaces = getDefaultConfValue(config["monitor-changes"]) == "off"
+	obj.capture = getDefaultConfValue(config["capture"])
+	obj.noOverride = getDefaultConfValue(config["override"]) == "off"
+	obj.strict = getDefaultConfValue(config["strict"]) == "on"
+	obj.sendFrames = getDefaultConfValue(config["send-frames"]) == "on"
+	obj.netns = getDefaultConfValue(config["netns"])
+	if obj.policies, err = parsePolicyConfig(config["policy"]); err != nil {
+		return nil, err
+	}
+	if obj.observe, err = parseModeConfig(config["mode"]); err != nil {
+		return nil, err
+	}
+	if obj.router, err = parseRouterConfig(config["router"]); err != nil {
+		return nil, err
+	}
+	if obj.advertiseMAC, obj.advertiseIface, err = parseAdvertiseMACConfig(config["advertise-mac"]); err != nil {
+		return nil, err
+	}
+	if obj.sourceAddress, obj.sourceIP, err = parseSourceAddressConfig(config["source-address"]); err != nil {
+		return nil, err
+	}
+	if obj.Filter, err = parseFilterConfig(config["filter"]); err != nil {
+		return nil, err
+	}
+
+	if err = checkInterfaceNames(obj.Iface, obj.autosense, obj.advertiseIface, obj.netns); err != nil {
+		return nil, err
+	}
+	if obj.autosense != "" && obj.Filter != "" {
+		return nil, errors.New("config: cannot have both a filter and autosense enabled on a responder object")
+	}
+	if obj.Iface == "" {
+		return nil, errors.New("config: interface not specified in the responder object. (iface parameter)")
+	}
+	return &obj, nil
+}
+
+// parseFilterConfig joins the filter lines of a config block in the format used by pndp.ParseFilter
+func parseFilterConfig(values []string) (string, error) {
+	filter := ""
+	for _, value := range values {
+		if strings.Contains(value, ";") {
+			return "", errors.New("config: the use of semicolons is not allowed in the filter arguments")
+		}
+		if _, _, err := net.ParseCIDR(value); err != nil {
+			return "", errors.New("config: filter: " + err.Error())
+		}
+		filter += value + ";"
+	}
+	return strings.TrimSuffix(filter, ";"), nil
+}
+
+// parseModeConfig returns whether the mode of a config block is "observe" (the default is "active")
+func parseModeConfig(values []string) (bool, error) {
+	switch mode := getDefaultConfValue(values); mode {
+	case "", "active":
+		return false, nil
+	case "observe":
+		return true, nil
+	default:
+		return false, errors.New("config: unknown mode \"" + mode + "\" (must be active or observe)")
+	}
+}
+
+// parseRouterConfig returns the router flag setting of a config block (the default is "auto")
+func parseRouterConfig(values []string) (pndp.RouterFlag, error) {
+	switch value := getDefaultConfValue(values); value {
+	case "", "auto":
+		return pndp.RouterFlagAuto, nil
+	case "on":
+		return pndp.RouterFlagOn, nil
+	case "off":
+		return pndp.RouterFlagOff, nil
+	default:
+		return pndp.RouterFlagAuto, errors.New("config: unknown router setting \"" + value + "\" (must be auto, on or off)")
+	}
+}
+
+// parseAdvertiseMACConfig returns the static MAC or the name of the interface whose MAC is advertised instead of the own one
+func parseAdvertiseMACConfig(values []string) (net.HardwareAddr, string, error) {
+	value := getDefaultConfValue(values)
+	if value == "" {
+		return nil, "", nil
+	}
+	if mac, err := net.ParseMAC(value); err == nil {
+		if len(mac) != 6 {
+			return nil, "", errors.New("config: advertise-mac must be an Ethernet address")
+		}
+		return mac, "", nil
+	}
+	return nil, value, nil
+}
+
+// parseSourceAddressConfig returns the source address selection of a config block (the default is "auto")
+func parseSourceAddressConfig(values []string) (pndp.SourceAddress, net.IP, error) {
+	switch value := getDefaultConfValue(values); value {
+	case "", "auto":
+		return pndp.SourceAuto, nil, nil
+	case "link-local":
+		return pndp.SourceLinkLocal, nil, nil
+	case "matching-prefix":
+		return pndp.SourceMatchingPrefix, nil, nil
+	case "rfc6724":
+		return pndp.SourceRFC6724, nil, nil
+	default:
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() != nil {
+			return pndp.SourceAuto, nil, errors.New("config: unknown source-address \"" + value + "\" (must be auto, link-local, matching-prefix, rfc6724 or an IPv6 address)")
+		}
+		return pndp.SourceFixed, ip, nil
+	}
+}
+
+// parsePolicyConfig checks that the policies of a config block exist. Policies are registered by modules
+// in their init() function, before the config file is parsed.
+func parsePolicyConfig(names []string) ([]string, error) {
+	for _, name := range names {
+		if pndp.GetPolicy(name) == nil {
+			return nil, errors.New("config: unknown policy \"" + name + "\"")
+		}
+	}
+	return names, nil
+}
+
+// checkInterfaceNames rejects names of interfaces and network namespaces that contain "@", which separates them
+// in the names of interfaces in other namespaces (such as eth0@tenant)
+func checkInterfaceNames(names ...string) error {
+	for _, name := range names {
+		if strings.Contains(name, "@") {
+			return errors.New("config: interface and network namespace names containing @ are not supported (\"" + name + "\")")
 		}
 	}
+	return nil
 }
 
 func getDefaultConfValue(in []string) string {
@@ -163,27 +409,106 @@ func getDefaultConfValue(in []string) string {
 	return in[0]
 }
 
+// getPolicies resolves the policy names of a config block (see parsePolicyConfig)
+func getPolicies(names []string) []pndp.TargetPolicy {
+	result := make([]pndp.TargetPolicy, 0, len(names))
+	for _, name := range names {
+		result = append(result, pndp.GetPolicy(name))
+	}
+	return result
+}
+
+// openedCaptures are the capture files opened for individual instances
+var openedCaptures []*pndp.Capture
+
+// getCapture opens the capture file of an instance. It returns nil if the instance has no capture option.
+func getCapture(value string) *pndp.Capture {
+	if value == "" {
+		return nil
+	}
+	path, maxSize, err := pndp.ParseCaptureOption(value)
+	if err != nil {
+		showError("config: capture: " + err.Error())
+	}
+	capture, err := pndp.OpenCapture(path, maxSize)
+	if err != nil {
+		showError(err.Error())
+	}
+	openedCaptures = append(openedCaptures, capture)
+	return capture
+}
+
 func completeCallback() {
 	for _, n := range allProxies {
-		o := pndp.NewProxy(n.Iface1, n.Iface2, pndp.ParseFilter(n.Filter), n.autosense, !n.DontMonitorInterfaces)
+		o := n.build(!n.DontMonitorInterfaces)
+		if capture := getCapture(n.capture); capture != nil {
+			o.SetCapture(capture)
+		}
+		if pndp.IsInterfacePattern(n.Iface2) {
+			n.group = pndp.NewProxyGroup(o)
+			n.group.Start()
+			continue
+		}
 		n.instance = o
 		o.Start()
 	}
 	for _, n := range allResponders {
-		o := pndp.NewResponder(n.Iface, pndp.ParseFilter(n.Filter), n.autosense, !n.DontMonitorInterfaces)
+		o := n.build(!n.DontMonitorInterfaces)
+		if capture := getCapture(n.capture); capture != nil {
+			o.SetCapture(capture)
+		}
 		n.instance = o
 		o.Start()
 	}
 }
 
+// build creates the instance of a proxy block. The capture file is left to the caller, as only running instances write it.
+// If the internal interface is a pattern, the instance is the template of a pndp.ProxyGroup.
+func (n *configProxy) build(monitorInterfaces bool) *pndp.ProxyObj {
+	o := pndp.NewProxy(n.Iface1, n.Iface2, pndp.ParseFilter(n.Filter), n.autosense, monitorInterfaces)
+	o.SetPolicies(getPolicies(n.policies)...)
+	o.SetObserve(n.observe)
+	o.SetAnnounce(n.announce)
+	o.SetDefend(n.defend)
+	o.SetFlags(n.router, !n.noOverride)
+	o.SetStrict(n.strict)
+	o.SetSendFrames(n.sendFrames)
+	o.SetAdvertiseMAC(n.advertiseMAC, n.advertiseIface)
+	o.SetSourceAddress(n.sourceAddress, n.sourceIP)
+	o.SetNetns(n.extNetns, n.intNetns)
+	return o
+}
+
+// build creates the instance of a responder block. The capture file is left to the caller, as only running instances write it.
+func (n *configResponder) build(monitorInterfaces bool) *pndp.ResponderObj {
+	o := pndp.NewResponder(n.Iface, pndp.ParseFilter(n.Filter), n.autosense, monitorInterfaces)
+	o.SetPolicies(getPolicies(n.policies)...)
+	o.SetObserve(n.observe)
+	o.SetFlags(n.router, !n.noOverride)
+	o.SetStrict(n.strict)
+	o.SetSendFrames(n.sendFrames)
+	o.SetAdvertiseMAC(n.advertiseMAC, n.advertiseIface)
+	o.SetSourceAddress(n.sourceAddress, n.sourceIP)
+	o.SetNetns(n.netns)
+	return o
+}
+
 func shutdownCallback() {
 	for _, n := range allProxies {
+		if n.group != nil {
+			n.group.Stop()
+			continue
+		}
 		n.instance.Stop()
 	}
 
 	for _, n := range allResponders {
 		n.instance.Stop()
 	}
+
+	for _, capture := range openedCaptures {
+		_ = capture.Close()
+	}
 }
 
 func showError(error string) {
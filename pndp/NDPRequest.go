@@ -12,11 +12,9 @@ type ndpRequest struct {
 	srcIP          []byte
 	answeringForIP []byte
 	dstIP          []byte
-	sourceIface    string
-	payload        []byte
-}
-
-type ndpQuestion struct {
-	targetIP []byte
-	askedBy  []byte
+	// srcMAC is the link-layer address of the sender: the source link-layer address option if present,
+	// the Ethernet source otherwise
+	srcMAC      []byte
+	sourceIface string
+	payload     []byte
 }
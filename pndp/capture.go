@@ -0,0 +1,312 @@
+package pndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCaptureSize is the size at which capture files are rotated unless specified otherwise
+const DefaultCaptureSize = 100 * 1024 * 1024
+
+// captureKeep is the number of rotated capture files (path.1 ... path.N) that are kept
+const captureKeep = 4
+
+// pcapng block types and options
+const (
+	pcapngSectionHeader    = 0x0A0D0D0A
+	pcapngInterfaceDesc    = 0x00000001
+	pcapngEnhancedPacket   = 0x00000006
+	pcapngByteOrderMagic   = 0x1A2B3C4D
+	pcapngLinkTypeEthernet = 1
+	pcapngOptEnd           = 0
+	pcapngOptComment       = 1
+	pcapngOptShbUserAppl   = 4
+	pcapngOptIfName        = 2
+	pcapngOptIfTsresol     = 9
+	pcapngOptEpbFlags      = 2
+	pcapngFlagInbound      = 1
+	pcapngFlagOutbound     = 2
+)
+
+// Capture writes the frames that instances receive and send to a pcapng file.
+// It is safe for concurrent use and may be shared by several instances.
+type Capture struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	file       *os.File
+	size       int64
+	interfaces map[captureInterface]uint32
+	refs       int
+}
+
+// captureInterface identifies an interface description block. Received frames were truncated by the BPF filter
+// of the listening sockets, so they are written to a block of their own that has the snap length of the filter.
+type captureInterface struct {
+	name    string
+	snapLen uint32
+}
+
+var (
+	capturesMutex sync.Mutex
+	captures      = make(map[string]*Capture)
+)
+
+var defaultCapture *Capture
+
+// OpenCapture opens a pcapng capture file, which is rotated once it reaches maxSize bytes.
+// Opening a path that is already open returns the same Capture. Each OpenCapture needs a matching Close.
+func OpenCapture(path string, maxSize int64) (*Capture, error) {
+	capturesMutex.Lock()
+	defer capturesMutex.Unlock()
+	if c, ok := captures[path]; ok {
+		c.mu.Lock()
+		c.refs++
+		c.mu.Unlock()
+		return c, nil
+	}
+
+	if maxSize <= 0 {
+		maxSize = DefaultCaptureSize
+	}
+	c := &Capture{path: path, maxSize: maxSize, refs: 1}
+	if err := c.create(); err != nil {
+		return nil, err
+	}
+	captures[path] = c
+	return c, nil
+}
+
+// ParseCaptureOption parses the value of the capture option: a path and an optional maximum size in MB
+func ParseCaptureOption(value string) (path string, maxSize int64, err error) {
+	fields := strings.Fields(value)
+	switch len(fields) {
+	case 1:
+		return fields[0], DefaultCaptureSize, nil
+	case 2:
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size <= 0 {
+			return "", 0, fmt.Errorf("invalid maximum size %q", fields[1])
+		}
+		return fields[0], size * 1024 * 1024, nil
+	}
+	return "", 0, errors.New("expected a path and an optional maximum size in MB")
+}
+
+// SetDefaultCapture sets the Capture used by instances for which SetCapture is not called
+func SetDefaultCapture(c *Capture) {
+	defaultCapture = c
+}
+
+// Close releases a reference obtained by OpenCapture and closes the file once it is no longer used
+func (c *Capture) Close() error {
+	capturesMutex.Lock()
+	defer capturesMutex.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	delete(captures, c.path)
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// create starts a new file with a section header block. Interface blocks are written when first used.
+func (c *Capture) create() error {
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("unable to open capture file: %w", err)
+	}
+	c.file = file
+	c.size = 0
+	c.interfaces = make(map[captureInterface]uint32)
+
+	body := binary.LittleEndian.AppendUint32(nil, pcapngByteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // Major version
+	body = binary.LittleEndian.AppendUint16(body, 0) // Minor version
+	body = binary.LittleEndian.AppendUint64(body, 0xFFFFFFFFFFFFFFFF)
+	body = appendPcapngOption(body, pcapngOptShbUserAppl, []byte("pndpd"))
+	body = appendPcapngOption(body, pcapngOptEnd, nil)
+	return c.writeBlock(pcapngSectionHeader, body)
+}
+
+// rotate moves the current file to path.1 (shifting older files) and starts a new one
+func (c *Capture) rotate() error {
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+	c.file = nil
+	for i := captureKeep - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", c.path, i), fmt.Sprintf("%s.%d", c.path, i+1))
+	}
+	if err := os.Rename(c.path, c.path+".1"); err != nil {
+		return err
+	}
+	return c.create()
+}
+
+func (c *Capture) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+	block := binary.LittleEndian.AppendUint32(nil, blockType)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	n, err := c.file.Write(block)
+	c.size += int64(n)
+	return err
+}
+
+// interfaceID returns the ID of the interface description block of iface and writes the block if needed
+func (c *Capture) interfaceID(iface captureInterface) (uint32, error) {
+	if id, ok := c.interfaces[iface]; ok {
+		return id, nil
+	}
+	body := binary.LittleEndian.AppendUint16(nil, pcapngLinkTypeEthernet)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, iface.snapLen) // 0 means no limit
+	body = appendPcapngOption(body, pcapngOptIfName, []byte(iface.name))
+	body = appendPcapngOption(body, pcapngOptEnd, nil)
+	if err := c.writeBlock(pcapngInterfaceDesc, body); err != nil {
+		return 0, err
+	}
+	id := uint32(len(c.interfaces))
+	c.interfaces[iface] = id
+	return id, nil
+}
+
+// WriteFrame adds an Ethernet frame that was received (inbound) or sent on iface, with an optional comment
+func (c *Capture) WriteFrame(iface string, frame []byte, inbound bool, comment string) error {
+	return c.writeFrameAt(time.Now(), captureInterface{name: iface}, frame, len(frame), inbound, comment)
+}
+
+// writeFrameAt adds a frame that was length bytes long before it was truncated to frame
+func (c *Capture) writeFrameAt(ts time.Time, iface captureInterface, frame []byte, length int, inbound bool, comment string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return os.ErrClosed
+	}
+	if c.size >= c.maxSize {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+	id, err := c.interfaceID(iface)
+	if err != nil {
+		return err
+	}
+
+	timestamp := uint64(ts.UnixMicro())
+	body := binary.LittleEndian.AppendUint32(nil, id)
+	body = binary.LittleEndian.AppendUint32(body, uint32(timestamp>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(timestamp))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(frame)))              // Captured length
+	body = binary.LittleEndian.AppendUint32(body, uint32(max(length, len(frame)))) // Original length
+	body = append(body, frame...)
+	body = padPcapng(body)
+	if comment != "" {
+		body = appendPcapngOption(body, pcapngOptComment, []byte(comment))
+	}
+	direction := uint32(pcapngFlagOutbound)
+	if inbound {
+		direction = pcapngFlagInbound
+	}
+	body = appendPcapngOption(body, pcapngOptEpbFlags, binary.LittleEndian.AppendUint32(nil, direction))
+	body = appendPcapngOption(body, pcapngOptEnd, nil)
+	return c.writeBlock(pcapngEnhancedPacket, body)
+}
+
+func appendPcapngOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return padPcapng(b)
+}
+
+func padPcapng(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// captureReceived records a received frame together with the decisions the Engine made about it.
+// The frame was truncated to maxFrameLen from its original length, which is 0 if it is not known.
+func captureReceived(c *Capture, ts time.Time, iface string, frame []byte, length int, actions []Action, dryRun bool) {
+	if c == nil {
+		return
+	}
+	comment := describeDecisions(actions)
+	if dryRun {
+		comment = "dry-run: " + comment
+	}
+	if err := c.writeFrameAt(ts, captureInterface{name: iface, snapLen: maxFrameLen}, frame, length, true, comment); err != nil {
+		showCaptureError(err)
+	}
+}
+
+// captureSent records a sent IPv6 packet. Unless the frame was sent as a whole, the kernel adds the Ethernet header,
+// so an equivalent one is constructed. The destination MAC of unicast packets is left empty if it is not known.
+func captureSent(c *Capture, ts time.Time, srcMAC net.HardwareAddr, action SendAction, dryRun bool) {
+	if c == nil {
+		return
+	}
+	dstMAC := action.LinkLayerDst()
+	if dstMAC == nil {
+		dstMAC = make([]byte, 6)
+	}
+	if len(srcMAC) != 6 {
+		srcMAC = make([]byte, 6)
+	}
+	frame := ethernetFrame(dstMAC, srcMAC, action.Packet)
+	dst := action.Dst
+	comment := "sent to " + dst.String()
+	if dryRun {
+		comment = "dry-run: would have been sent to " + dst.String()
+	}
+	if err := c.writeFrameAt(ts, captureInterface{name: action.Iface}, frame, len(frame), false, comment); err != nil {
+		showCaptureError(err)
+	}
+}
+
+func describeDecisions(actions []Action) string {
+	decisions := make([]string, 0, len(actions))
+	for _, a := range actions {
+		decisions = append(decisions, describeDecision(a))
+	}
+	return strings.Join(decisions, "; ")
+}
+
+func describeDecision(action Action) string {
+	switch a := action.(type) {
+	case SendAction:
+		return fmt.Sprintf("sending on %s to %s", a.Iface, a.Dst)
+	case InstallAction:
+		return fmt.Sprintf("waiting for advertisement for %s asked by %s", a.Target, a.AskedBy)
+	case EmitAction:
+		return fmt.Sprintf("dropped (%s): %s", a.Reason, a.Message)
+	}
+	return ""
+}
+
+var captureErrorOnce sync.Once
+
+// showCaptureError reports the first error writing to a capture file. Capture errors do not stop instances.
+func showCaptureError(err error) {
+	captureErrorOnce.Do(func() {
+		fmt.Println("Error writing capture file:", err)
+	})
+}
@@ -0,0 +1,112 @@
+package pndp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readCapture reads a capture file written by Capture
+func readCapture(t *testing.T, path string) []CapturedFrame {
+	t.Helper()
+	frames, err := ReadCaptureFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+func TestCaptureProxy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.pcapng")
+	capture, err := OpenCapture(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network := NewMemoryNetwork()
+	extLink := network.AddLink("mem-ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+	intLink := network.AddLink("mem-int", testIntMAC, mustParseIfaceIP("fd01::1/64"))
+	proxy := NewProxy("mem-ext", "mem-int", nil, "mem-int", true)
+	proxy.SetNetwork(network)
+	proxy.SetCapture(capture)
+	proxy.Start()
+	waitForConns(t, extLink, 1)
+	waitForConns(t, intLink, 1)
+
+	// Received frames are truncated to maxFrameLen, but their original length is recorded
+	long := append(buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol), make([]byte, 8)...)
+	extLink.Inject(long)
+	expectPacket(t, intLink)
+	extLink.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, net.ParseIP("fd02::1"), ndpSol))
+	// Packets are written before the next event is handled
+	time.Sleep(100 * time.Millisecond)
+	proxy.Stop()
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := readCapture(t, path)
+	want := []CapturedFrame{
+		{Iface: "mem-ext", Comment: "waiting for advertisement for fd01::99 asked by fd00::5; sending on mem-int to ff02::1:ff00:99"},
+		{Iface: "mem-int", Outbound: true, Comment: "sent to ff02::1:ff00:99"},
+		{Iface: "mem-ext", Comment: "dropped (filter): Dropping packet for an IP that is not whitelisted"},
+	}
+	if len(packets) != len(want) {
+		t.Fatalf("Expected %d packets, but got %d", len(want), len(packets))
+	}
+	for i, w := range want {
+		got := packets[i]
+		if got.Iface != w.Iface || got.Outbound != w.Outbound || got.Comment != w.Comment {
+			t.Errorf("Packet %d: expected %s outbound=%t %q, but got %s outbound=%t %q", i, w.Iface, w.Outbound, w.Comment, got.Iface, got.Outbound, got.Comment)
+		}
+	}
+	if len(packets[0].Frame) != maxFrameLen || packets[0].Length != len(long) {
+		t.Errorf("Expected %d of %d bytes of the received frame, but got %d of %d", maxFrameLen, len(long), len(packets[0].Frame), packets[0].Length)
+	}
+	sent := packets[1].Frame
+	if net.HardwareAddr(sent[:6]).String() != "33:33:ff:00:00:99" || net.HardwareAddr(sent[6:12]).String() != testIntMAC.String() {
+		t.Errorf("Unexpected Ethernet header of the sent packet %x", sent[:14])
+	}
+}
+
+func TestCaptureRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.pcapng")
+	capture, err := OpenCapture(path, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := OpenCapture(path, 500)
+	if err != nil || shared != capture {
+		t.Fatalf("Expected the capture to be shared")
+	}
+	_ = shared.Close()
+
+	frame := buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol)
+	for i := 0; i < 20; i++ {
+		if err := capture.WriteFrame("eth0", frame, true, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".4"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 500+200 {
+			t.Errorf("%s was not rotated in time (%d bytes)", name, info.Size())
+		}
+		// Every file is a complete capture
+		if packets := readCapture(t, name); len(packets) == 0 || packets[0].Iface != "eth0" {
+			t.Errorf("%s does not contain packets of eth0", name)
+		}
+	}
+	if _, err := os.Stat(path + ".5"); !os.IsNotExist(err) {
+		t.Errorf("More than %d rotated files are kept", captureKeep)
+	}
+}
@@ -0,0 +1,184 @@
+package pndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CapturedFrame is an Ethernet frame read from a capture file
+type CapturedFrame struct {
+	// Iface is the name of the interface the frame was captured on (empty if the file does not record it)
+	Iface string
+	Time  time.Time
+	Frame []byte
+	// Length is the length of the frame before it was truncated to Frame by the capture
+	Length int
+	// Outbound is set for frames recorded as sent (for example by the capture option)
+	Outbound bool
+	Comment  string
+}
+
+var errCaptureTruncated = errors.New("truncated capture file")
+
+// ReadCaptureFile reads the Ethernet frames of a pcap or pcapng file
+func ReadCaptureFile(path string) ([]CapturedFrame, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, errCaptureTruncated
+	}
+	if binary.LittleEndian.Uint32(data) == pcapngSectionHeader {
+		return readPcapngFile(data)
+	}
+	return readPcapFile(data)
+}
+
+func readPcapFile(data []byte) ([]CapturedFrame, error) {
+	if len(data) < 24 {
+		return nil, errCaptureTruncated
+	}
+	var order binary.ByteOrder
+	var resolution time.Duration
+	switch {
+	case binary.LittleEndian.Uint32(data) == 0xa1b2c3d4:
+		order, resolution = binary.LittleEndian, time.Microsecond
+	case binary.BigEndian.Uint32(data) == 0xa1b2c3d4:
+		order, resolution = binary.BigEndian, time.Microsecond
+	case binary.LittleEndian.Uint32(data) == 0xa1b23c4d:
+		order, resolution = binary.LittleEndian, time.Nanosecond
+	case binary.BigEndian.Uint32(data) == 0xa1b23c4d:
+		order, resolution = binary.BigEndian, time.Nanosecond
+	default:
+		return nil, errors.New("not a pcap or pcapng file")
+	}
+	if linkType := order.Uint32(data[20:]) & 0xffff; linkType != pcapngLinkTypeEthernet {
+		return nil, fmt.Errorf("unsupported link type %d (only Ethernet is supported)", linkType)
+	}
+
+	var frames []CapturedFrame
+	data = data[24:]
+	for len(data) > 0 {
+		if len(data) < 16 {
+			return nil, errCaptureTruncated
+		}
+		capLen := int(order.Uint32(data[8:]))
+		if len(data) < 16+capLen {
+			return nil, errCaptureTruncated
+		}
+		frames = append(frames, CapturedFrame{
+			Time:   time.Unix(int64(order.Uint32(data)), int64(order.Uint32(data[4:]))*int64(resolution)),
+			Frame:  data[16 : 16+capLen],
+			Length: int(order.Uint32(data[12:])),
+		})
+		data = data[16+capLen:]
+	}
+	return frames, nil
+}
+
+type pcapngInterface struct {
+	name       string
+	linkType   uint16
+	resolution time.Duration
+}
+
+func readPcapngFile(data []byte) ([]CapturedFrame, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	var interfaces []pcapngInterface
+	var frames []CapturedFrame
+
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, errCaptureTruncated
+		}
+		blockType := order.Uint32(data)
+		if blockType == pcapngSectionHeader {
+			// Every section may use a different byte order and has its own interfaces
+			if binary.BigEndian.Uint32(data[8:]) == pcapngByteOrderMagic {
+				order = binary.BigEndian
+			} else {
+				order = binary.LittleEndian
+			}
+			interfaces = nil
+		}
+		length := int(order.Uint32(data[4:]))
+		if length < 12 || length%4 != 0 || length > len(data) {
+			return nil, errCaptureTruncated
+		}
+		body := data[8 : length-4]
+		data = data[length:]
+
+		switch blockType {
+		case pcapngInterfaceDesc:
+			if len(body) < 8 {
+				return nil, errCaptureTruncated
+			}
+			iface := pcapngInterface{linkType: order.Uint16(body), resolution: time.Microsecond}
+			options := readPcapngOptions(order, body[8:])
+			iface.name = string(options[pcapngOptIfName])
+			if tsresol, ok := options[pcapngOptIfTsresol]; ok && len(tsresol) == 1 {
+				iface.resolution = pcapngResolution(tsresol[0])
+			}
+			interfaces = append(interfaces, iface)
+		case pcapngEnhancedPacket:
+			if len(body) < 20 {
+				return nil, errCaptureTruncated
+			}
+			id := int(order.Uint32(body))
+			if id >= len(interfaces) {
+				return nil, fmt.Errorf("packet refers to unknown interface %d", id)
+			}
+			iface := interfaces[id]
+			if iface.linkType != pcapngLinkTypeEthernet {
+				return nil, fmt.Errorf("unsupported link type %d on interface %q (only Ethernet is supported)", iface.linkType, iface.name)
+			}
+			capLen := int(order.Uint32(body[12:]))
+			if len(body) < 20+capLen {
+				return nil, errCaptureTruncated
+			}
+			padded := min(20+(capLen+3)/4*4, len(body))
+			options := readPcapngOptions(order, body[padded:])
+			timestamp := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			frames = append(frames, CapturedFrame{
+				Iface:    iface.name,
+				Time:     time.Unix(0, 0).Add(time.Duration(timestamp) * iface.resolution),
+				Frame:    body[20 : 20+capLen],
+				Length:   int(order.Uint32(body[16:])),
+				Outbound: len(options[pcapngOptEpbFlags]) == 4 && order.Uint32(options[pcapngOptEpbFlags])&3 == pcapngFlagOutbound,
+				Comment:  string(options[pcapngOptComment]),
+			})
+		}
+	}
+	return frames, nil
+}
+
+func readPcapngOptions(order binary.ByteOrder, b []byte) map[uint16][]byte {
+	result := make(map[uint16][]byte)
+	for len(b) >= 4 {
+		code := order.Uint16(b)
+		length := int(order.Uint16(b[2:]))
+		if code == pcapngOptEnd || len(b) < 4+length {
+			break
+		}
+		result[code] = b[4 : 4+length]
+		b = b[min(4+(length+3)/4*4, len(b)):]
+	}
+	return result
+}
+
+// pcapngResolution decodes the if_tsresol option
+func pcapngResolution(tsresol byte) time.Duration {
+	unit := time.Duration(1)
+	if tsresol&0x80 != 0 {
+		// Negative power of two. Resolutions finer than a nanosecond are not supported.
+		return unit
+	}
+	for i := byte(0); i < 9-min(tsresol, 9); i++ {
+		unit *= 10
+	}
+	return unit
+}
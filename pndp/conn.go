@@ -0,0 +1,375 @@
+package pndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// maxFrameLen is the number of bytes of each frame that is passed to userspace
+const maxFrameLen = 86
+
+// tpacketAuxdataLen is the size of struct tpacket_auxdata, the auxiliary data of packet sockets
+const tpacketAuxdataLen = 20
+
+// PacketConn is the packet I/O an instance performs on a single network interface
+type PacketConn interface {
+	// ReadFrame reads the next Ethernet frame that carries a Neighbor Solicitation or Advertisement.
+	// Frames longer than b are truncated. os.ErrClosed is returned once the PacketConn has been closed.
+	ReadFrame(b []byte) (int, error)
+	// WritePacket sends an IPv6 packet (including the IPv6 header) to dst
+	WritePacket(b []byte, dst []byte) error
+	Close() error
+}
+
+// Network opens PacketConns and provides information about network interfaces.
+// Instances use SystemNetwork unless a different Network is set with SetNetwork.
+type Network interface {
+	Open(iface string) (PacketConn, error)
+	InterfaceByName(name string) (*net.Interface, error)
+	Addrs(iface *net.Interface) ([]net.Addr, error)
+}
+
+// NeighborLister is implemented by Networks that know the neighbors of an interface.
+// Instances that announce or defend addresses use it when they start.
+type NeighborLister interface {
+	// Neighbors returns the IPv6 addresses of the neighbors that are reachable through iface
+	Neighbors(iface *net.Interface) ([]net.IP, error)
+}
+
+// ForwardingReader is implemented by Networks that know whether IPv6 forwarding is enabled on an interface.
+// Instances derive the router flag of their advertisements from it.
+type ForwardingReader interface {
+	Forwarding(iface *net.Interface) (bool, error)
+}
+
+// InterfaceLister is implemented by Networks that can enumerate their interfaces.
+// Proxy groups (see ProxyGroup) use it to find the matching interfaces that exist when they start.
+type InterfaceLister interface {
+	Interfaces() ([]net.Interface, error)
+}
+
+// CompleteFrameOpener is implemented by Networks that can open PacketConns whose frames are not truncated to maxFrameLen.
+// Probe uses it to read answers with all of their options.
+type CompleteFrameOpener interface {
+	OpenComplete(iface string) (PacketConn, error)
+}
+
+// FrameLengthReader is implemented by PacketConns that know the length received frames had before they were truncated.
+// Captures record it as the original length of the frame.
+type FrameLengthReader interface {
+	// ReadFrameLength is ReadFrame that also returns the original length of the frame
+	ReadFrameLength(b []byte) (n int, length int, err error)
+}
+
+// FrameWriter is implemented by PacketConns that can send complete Ethernet frames.
+// Instances that send frames (see ProxyObj.SetSendFrames) use it for packets whose link-layer destination is known.
+type FrameWriter interface {
+	// WriteFrame sends an Ethernet frame as is
+	WriteFrame(frame []byte) error
+}
+
+// SystemNetwork is the Network of the host that uses raw sockets (requires root or CAP_NET_RAW)
+var SystemNetwork Network = systemNetwork{}
+
+type systemNetwork struct{}
+
+func (systemNetwork) InterfaceByName(name string) (*net.Interface, error) {
+	return net.InterfaceByName(name)
+}
+
+func (systemNetwork) Interfaces() ([]net.Interface, error) {
+	return net.Interfaces()
+}
+
+func (systemNetwork) Addrs(iface *net.Interface) ([]net.Addr, error) {
+	return iface.Addrs()
+}
+
+// Neighbors returns the usable entries of the IPv6 neighbor cache of the kernel
+func (systemNetwork) Neighbors(iface *net.Interface) ([]net.IP, error) {
+	return getNeighbors(iface.Index)
+}
+
+// Forwarding reads the net.ipv6.conf.<iface>.forwarding sysctl
+func (systemNetwork) Forwarding(iface *net.Interface) (bool, error) {
+	value, err := os.ReadFile(filepath.Join(sysctlRoot, "net", "ipv6", "conf", iface.Name, "forwarding"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(value)) != "0", nil
+}
+
+func (systemNetwork) Open(iface string) (PacketConn, error) {
+	return openRawConn(iface, ndpFilter)
+}
+
+// OpenComplete opens a PacketConn whose frames are not truncated to maxFrameLen
+func (systemNetwork) OpenComplete(iface string) (PacketConn, error) {
+	return openRawConn(iface, ndpCompleteFilter)
+}
+
+// openRawConn opens a listening socket with filter f and a socket for sending on iface
+func openRawConn(iface string, f bpfFilter) (PacketConn, error) {
+	niface, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, htons(syscall.ETH_P_IPV6))
+	if err != nil {
+		return nil, fmt.Errorf("failed setting up listener on interface %s: %w", iface, err)
+	}
+	slog.Debug("Obtained fd", "fd", fd)
+
+	sendFd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.IPPROTO_RAW)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	slog.Debug("Obtained fd", "fd", sendFd)
+
+	if err := setupListenSocket(fd, niface, f); err != nil {
+		_ = syscall.Close(fd)
+		_ = syscall.Close(sendFd)
+		return nil, err
+	}
+
+	if err := syscall.BindToDevice(sendFd, iface); err != nil {
+		_ = syscall.Close(fd)
+		_ = syscall.Close(sendFd)
+		return nil, err
+	}
+	slog.Debug("Bound to interface", "fd", sendFd, "interface", iface)
+
+	return &rawConn{
+		listenFile: os.NewFile(uintptr(fd), ""),
+		sendFd:     sendFd,
+	}, nil
+}
+
+// ndpFilter passes the first maxFrameLen bytes of frames that carry a Neighbor Solicitation or Advertisement
+var ndpFilter = ndpFilterWithLength(maxFrameLen)
+
+// ndpCompleteFilter passes complete frames that carry a Neighbor Solicitation or Advertisement
+var ndpCompleteFilter = ndpFilterWithLength(maxTraceFrameLen)
+
+// ndpFilterWithLength passes the first length bytes of frames that carry a Neighbor Solicitation or Advertisement
+func ndpFilterWithLength(length uint32) bpfFilter {
+	return []bpf.Instruction{
+		// Load "EtherType" field from the ethernet header.
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		// Jump to the drop packet instruction if EtherType is not IPv6.
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 0x86dd, SkipTrue: 6},
+		// Load "Next Header" field from IPV6 header.
+		bpf.LoadAbsolute{Off: 20, Size: 1},
+		// Jump to the drop packet instruction if Next Header is not ICMPv6.
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 0x3a, SkipTrue: 4},
+		// Load "Type" field from ICMPv6 header.
+		bpf.LoadAbsolute{Off: 54, Size: 1},
+		// Jump to the accept packet instruction if Type is Neighbor Solicitation.
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x87, SkipTrue: 1},
+		// Jump to the drop packet instruction if Type is not Neighbor Advertisement.
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 0x88, SkipTrue: 1},
+		// Verdict is: send up to length bytes of the packet to userspace.
+		bpf.RetConstant{Val: length},
+		// Verdict is: "ignore packet."
+		bpf.RetConstant{Val: 0},
+	}
+}
+
+// maxTraceFrameLen is the number of bytes of each frame that is passed to a TraceConn
+const maxTraceFrameLen = 65535
+
+// traceFilter passes complete frames that carry any Neighbor Discovery message (Router Solicitation to Redirect)
+var traceFilter bpfFilter = []bpf.Instruction{
+	// Load "EtherType" field from the ethernet header.
+	bpf.LoadAbsolute{Off: 12, Size: 2},
+	// Jump to the drop packet instruction if EtherType is not IPv6.
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 0x86dd, SkipTrue: 6},
+	// Load "Next Header" field from IPV6 header.
+	bpf.LoadAbsolute{Off: 20, Size: 1},
+	// Jump to the drop packet instruction if Next Header is not ICMPv6.
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 0x3a, SkipTrue: 4},
+	// Load "Type" field from ICMPv6 header.
+	bpf.LoadAbsolute{Off: 54, Size: 1},
+	// Jump to the drop packet instruction if Type is below Router Solicitation.
+	bpf.JumpIf{Cond: bpf.JumpLessThan, Val: 133, SkipTrue: 2},
+	// Jump to the drop packet instruction if Type is above Redirect.
+	bpf.JumpIf{Cond: bpf.JumpGreaterThan, Val: 137, SkipTrue: 1},
+	// Verdict is: send the whole packet to userspace.
+	bpf.RetConstant{Val: maxTraceFrameLen},
+	// Verdict is: "ignore packet."
+	bpf.RetConstant{Val: 0},
+}
+
+func setupListenSocket(fd int, iface *net.Interface, f bpfFilter) error {
+	err := syscall.Bind(fd, &syscall.SockaddrLinklayer{
+		Protocol: htons16(syscall.ETH_P_IPV6),
+		Ifindex:  iface.Index,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Debug("Bound to interface", "fd", fd, "interface", iface.Name)
+
+	if err := setPromisc(fd, iface, true); err != nil {
+		return err
+	}
+
+	if err := f.ApplyTo(fd); err != nil {
+		return err
+	}
+
+	// The original length of frames that were truncated by the filter is passed along with them
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_AUXDATA, 1); err != nil {
+		return err
+	}
+
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		slog.Warn("Failed setting nonblock", "fd", fd)
+	}
+	return nil
+}
+
+// isNDPFrame performs the same checks as the BPF filter that is attached to the listening socket
+func isNDPFrame(frame []byte) bool {
+	if len(frame) < 55 {
+		return false
+	}
+	if frame[12] != 0x86 || frame[13] != 0xdd {
+		return false
+	}
+	if frame[20] != 0x3a {
+		return false
+	}
+	return frame[54] == 0x87 || frame[54] == 0x88
+}
+
+type rawConn struct {
+	listenFile *os.File
+	sendFd     int
+	closeOnce  sync.Once
+	closeErr   error
+}
+
+func (c *rawConn) ReadFrame(b []byte) (int, error) {
+	n, _, err := readFrame(c.listenFile, b)
+	return n, err
+}
+
+func (c *rawConn) ReadFrameLength(b []byte) (int, int, error) {
+	return readFrame(c.listenFile, b)
+}
+
+// readFrame reads a frame into b and returns its original length, which the kernel reports in the auxiliary data
+func readFrame(listenFile *os.File, b []byte) (n int, length int, err error) {
+	rc, err := listenFile.SyscallConn()
+	if err != nil {
+		return 0, 0, os.ErrClosed
+	}
+	oob := make([]byte, unix.CmsgSpace(tpacketAuxdataLen))
+	for {
+		var oobn int
+		readErr := rc.Read(func(fd uintptr) bool {
+			n, oobn, _, _, err = unix.Recvmsg(int(fd), b, oob, 0)
+			return err != unix.EAGAIN
+		})
+		if readErr != nil {
+			// The poller only fails once the file has been closed
+			return 0, 0, os.ErrClosed
+		}
+		if errors.Is(err, syscall.ENETDOWN) {
+			// Reported once when the interface goes down. The socket keeps working once it is up again.
+			slog.Debug("Interface went down", "error", err)
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		return n, originalLength(oob[:oobn], n), nil
+	}
+}
+
+// originalLength returns the length of the frame from the auxiliary data of a packet socket, or n if it is missing
+func originalLength(oob []byte, n int) int {
+	messages, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return n
+	}
+	for _, m := range messages {
+		if m.Header.Level == unix.SOL_PACKET && m.Header.Type == unix.PACKET_AUXDATA && len(m.Data) >= tpacketAuxdataLen {
+			// tp_len follows tp_status
+			return max(int(binary.NativeEndian.Uint32(m.Data[4:])), n)
+		}
+	}
+	return n
+}
+
+func (c *rawConn) WritePacket(b []byte, dst []byte) error {
+	if len(dst) != 16 {
+		return errors.New("malformed IP")
+	}
+	return syscall.Sendto(c.sendFd, b, 0, &syscall.SockaddrInet6{
+		Addr: [16]byte(dst),
+	})
+}
+
+// WriteFrame sends the frame on the listening socket, which is bound to the interface.
+// Neither the kernel's neighbor cache nor its IPv6 stack are involved.
+func (c *rawConn) WriteFrame(frame []byte) error {
+	_, err := c.listenFile.Write(frame)
+	return err
+}
+
+// Close may be called more than once. The file descriptors are released only once, as their numbers may have been reused.
+func (c *rawConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.listenFile.Close()
+		_ = syscall.Close(c.sendFd)
+	})
+	return c.closeErr
+}
+
+// TraceConn receives every Neighbor Discovery frame (Router Solicitation to Redirect) of an interface.
+// It uses the same kind of listening socket as instances, but frames are not truncated and nothing can be sent.
+type TraceConn struct {
+	listenFile *os.File
+}
+
+// OpenTraceConn opens a TraceConn on iface (requires root or CAP_NET_RAW)
+func OpenTraceConn(iface string) (*TraceConn, error) {
+	niface, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, htons(syscall.ETH_P_IPV6))
+	if err != nil {
+		return nil, fmt.Errorf("failed setting up listener on interface %s: %w", iface, err)
+	}
+	if err := setupListenSocket(fd, niface, traceFilter); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	return &TraceConn{listenFile: os.NewFile(uintptr(fd), "")}, nil
+}
+
+// ReadFrame reads the next frame. Frames longer than b are truncated. os.ErrClosed is returned once the TraceConn has been closed.
+func (c *TraceConn) ReadFrame(b []byte) (int, error) {
+	n, _, err := readFrame(c.listenFile, b)
+	return n, err
+}
+
+func (c *TraceConn) Close() error {
+	return c.listenFile.Close()
+}
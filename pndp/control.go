@@ -0,0 +1,241 @@
+package pndp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultControlSocket is the path of the control socket used by the CLI commands if no other path is given
+const DefaultControlSocket = "/run/pndpd.sock"
+
+// Commands understood by the control socket. A client sends a single command line after connecting.
+const (
+	// controlTrace streams a TraceDecision (one JSON object per line) for every frame the instances receive
+	controlTrace = "trace"
+	// controlCounters returns the result of GetCounters as a JSON object
+	controlCounters = "counters"
+)
+
+// traceQueueLen is the number of decisions buffered per trace client. Decisions are dropped for slow clients.
+const traceQueueLen = 256
+
+// TraceDecision is the decision an instance made for a received frame
+type TraceDecision struct {
+	Instance string `json:"instance"`
+	Iface    string `json:"iface"`
+	// Frame is the received Ethernet frame (truncated to the length passed to instances)
+	Frame    []byte    `json:"frame"`
+	Time     time.Time `json:"time"`
+	Decision string    `json:"decision"`
+}
+
+// ControlSocket is a unix socket that CLI commands such as "pndpd trace" connect to
+type ControlSocket struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+var (
+	traceMutex   sync.Mutex
+	traceClients = make(map[chan TraceDecision]struct{})
+	// traceActive avoids building decisions while nobody is tracing
+	traceActive atomic.Bool
+)
+
+// ListenControlSocket creates the control socket at path. A stale socket file left behind by a previous run is replaced.
+func ListenControlSocket(path string) (*ControlSocket, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("control socket %s is in use by another process", path)
+	}
+	_ = os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	s := &ControlSocket{listener: listener}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+func (s *ControlSocket) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go serveControlConn(conn)
+	}
+}
+
+// Close stops accepting new clients and removes the socket file
+func (s *ControlSocket) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func serveControlConn(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	command, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	switch strings.TrimSpace(command) {
+	case controlTrace:
+		serveTrace(conn)
+	case controlCounters:
+		_ = json.NewEncoder(conn).Encode(GetCounters())
+	default:
+		_, _ = fmt.Fprintf(conn, "unknown command %q\n", strings.TrimSpace(command))
+	}
+}
+
+func serveTrace(conn net.Conn) {
+	queue := make(chan TraceDecision, traceQueueLen)
+	traceMutex.Lock()
+	traceClients[queue] = struct{}{}
+	traceActive.Store(true)
+	traceMutex.Unlock()
+	defer func() {
+		traceMutex.Lock()
+		delete(traceClients, queue)
+		traceActive.Store(len(traceClients) != 0)
+		traceMutex.Unlock()
+	}()
+
+	// The client never sends anything else. A read returns once it disconnects.
+	closed := make(chan struct{})
+	go func() {
+		_, _ = conn.Read(make([]byte, 1))
+		close(closed)
+	}()
+
+	encoder := json.NewEncoder(conn)
+	for {
+		select {
+		case <-closed:
+			return
+		case decision := <-queue:
+			if err := encoder.Encode(decision); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// traceReceived publishes the decisions made for a received frame to all trace clients
+func traceReceived(instance string, ts time.Time, iface string, frame []byte, actions []Action, dryRun bool) {
+	if !traceActive.Load() {
+		return
+	}
+	decision := TraceDecision{
+		Instance: instance,
+		Iface:    iface,
+		Frame:    frame,
+		Time:     ts,
+		Decision: describeDecisions(actions),
+	}
+	if dryRun {
+		decision.Decision = "dry-run: " + decision.Decision
+	}
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+	for queue := range traceClients {
+		select {
+		case queue <- decision:
+		default:
+		}
+	}
+}
+
+// ReadCounters returns the Counters of the daemon with the control socket at path
+func ReadCounters(path string) (Counters, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return Counters{}, err
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := fmt.Fprintln(conn, controlCounters); err != nil {
+		return Counters{}, err
+	}
+	var counters Counters
+	err = json.NewDecoder(conn).Decode(&counters)
+	return counters, err
+}
+
+// controlSocketPid returns the process ID of the daemon that listens on the control socket at path
+func controlSocketPid(path string) (int, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close() }()
+	raw, err := conn.(*net.UnixConn).SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *unix.Ucred
+	controlErr := raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if controlErr != nil {
+		return 0, controlErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(cred.Pid), nil
+}
+
+// TraceClient receives the decisions of a running daemon from its control socket
+type TraceClient struct {
+	conn    net.Conn
+	decoder *json.Decoder
+}
+
+// DialTrace connects to the control socket at path and subscribes to the decisions of all instances
+func DialTrace(path string) (*TraceClient, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintln(conn, controlTrace); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &TraceClient{conn: conn, decoder: json.NewDecoder(conn)}, nil
+}
+
+// Next blocks until the next decision is received
+func (c *TraceClient) Next() (TraceDecision, error) {
+	var decision TraceDecision
+	err := c.decoder.Decode(&decision)
+	return decision, err
+}
+
+func (c *TraceClient) Close() error {
+	return c.conn.Close()
+}
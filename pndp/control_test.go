@@ -0,0 +1,107 @@
+package pndp
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pndpd/pndp/ndp"
+)
+
+func TestControlSocketTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pndpd.sock")
+	socket, err := ListenControlSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = socket.Close() }()
+	if _, err := ListenControlSocket(path); err == nil {
+		t.Errorf("Expected an error for a control socket that is in use")
+	}
+
+	client, err := DialTrace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	deadline := time.Now().Add(time.Second)
+	for !traceActive.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("The trace client was not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	frame := buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol)
+	actions := []Action{EmitAction{Iface: "eth0", Target: testTarget, Reason: ReasonFilter, Message: "Dropping packet for an IP that is not whitelisted"}}
+	traceReceived("responder eth0", time.Now(), "eth0", frame, actions, true)
+
+	decision, err := client.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Instance != "responder eth0" || decision.Iface != "eth0" || !bytes.Equal(decision.Frame, frame) {
+		t.Errorf("Unexpected decision %+v", decision)
+	}
+	if want := "dry-run: dropped (filter): Dropping packet for an IP that is not whitelisted"; decision.Decision != want {
+		t.Errorf("Expected %q, but got %q", want, decision.Decision)
+	}
+
+	_ = client.Close()
+	deadline = time.Now().Add(time.Second)
+	for traceActive.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("The trace client was not removed after disconnecting")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestControlSocketCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pndpd.sock")
+	socket, err := ListenControlSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = socket.Close() }()
+
+	countDrops("responder counters0", []Action{
+		EmitAction{Iface: "counters0", Reason: ReasonHopLimit},
+		EmitAction{Iface: "counters0", Reason: ReasonHopLimit},
+		SendAction{Iface: "counters0"},
+		EmitAction{Iface: "counters0", Reason: ReasonFilter},
+		EmitAction{Iface: "counters0", Target: testTarget, Reason: ReasonNUDFailed},
+	})
+
+	counters, err := ReadCounters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := counters.Drops["responder counters0"]; got[ReasonHopLimit] != 2 || got[ReasonFilter] != 1 || got[ReasonNUDFailed] != 1 || len(got) != 3 {
+		t.Errorf("Unexpected drop counters %v", got)
+	}
+	if got := counters.NUDFailures["responder counters0"]; got["fd01::99"] != 1 || len(got) != 1 {
+		t.Errorf("Unexpected NUD failure counters %v", got)
+	}
+	if counters.DryRun["responder counters0"] {
+		t.Errorf("Instance is labeled as dry-run")
+	}
+
+	// The packets of instances in observe mode are counted as would-send
+	dryRun := &dryRunCounters{}
+	setDryRun("responder counters1", dryRun)
+	dryRun.count(append(make([]byte, 40), ndp.TypeNeighborAdvertisement))
+	countDrops("responder counters1", []Action{EmitAction{Iface: "counters1", Reason: ReasonFilter}})
+
+	counters, err = ReadCounters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !counters.DryRun["responder counters1"] || counters.Drops["responder counters1"][ReasonFilter] != 1 {
+		t.Errorf("Expected the drops of responder counters1 labeled as dry-run, but got %v %v", counters.DryRun, counters.Drops)
+	}
+	if got := counters.WouldSend["responder counters1"]; got["advertisements"] != 1 || got["solicitations"] != 0 {
+		t.Errorf("Unexpected would-send counters %v", got)
+	}
+}
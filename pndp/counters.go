@@ -0,0 +1,92 @@
+package pndp
+
+import "sync"
+
+// Counters are the statistics of the instances of a daemon since it started. Instances are identified by their
+// description, for example "proxy eth0/eth1".
+type Counters struct {
+	// Drops holds the number of dropped packets per instance and drop reason
+	Drops map[string]map[string]uint64 `json:"drops"`
+	// NUDFailures holds the number of Neighbor Unreachability Detection probes that were forwarded
+	// but not answered per instance and target
+	NUDFailures map[string]map[string]uint64 `json:"nudFailures"`
+	// DryRun holds the instances that run in observe mode. Their drops and failed probes did not affect the network.
+	DryRun map[string]bool `json:"dryRun"`
+	// WouldSend holds the number of packets that instances in observe mode would have sent per instance and message
+	// ("solicitations" and "advertisements")
+	WouldSend map[string]map[string]uint64 `json:"wouldSend"`
+}
+
+var (
+	countersMutex sync.Mutex
+	counters      = Counters{Drops: make(map[string]map[string]uint64), NUDFailures: make(map[string]map[string]uint64)}
+	// dryRuns holds the counters of the instances in observe mode
+	dryRuns = make(map[string]*dryRunCounters)
+)
+
+// setDryRun records whether instance runs in observe mode and the counters of the packets it would send
+func setDryRun(instance string, dryRun *dryRunCounters) {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	if dryRun == nil {
+		delete(dryRuns, instance)
+		return
+	}
+	dryRuns[instance] = dryRun
+}
+
+// countDrops adds the drop reasons (and the failed probes) of actions to the counters of instance
+func countDrops(instance string, actions []Action) {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	for _, a := range actions {
+		action, ok := a.(EmitAction)
+		if !ok {
+			continue
+		}
+		increment(counters.Drops, instance, action.Reason)
+		if action.Reason == ReasonNUDFailed {
+			increment(counters.NUDFailures, instance, action.Target.String())
+		}
+	}
+}
+
+func increment(counts map[string]map[string]uint64, instance string, key string) {
+	instanceCounts, ok := counts[instance]
+	if !ok {
+		instanceCounts = make(map[string]uint64)
+		counts[instance] = instanceCounts
+	}
+	instanceCounts[key]++
+}
+
+// GetCounters returns a copy of the counters of all instances
+func GetCounters() Counters {
+	countersMutex.Lock()
+	defer countersMutex.Unlock()
+	result := Counters{
+		Drops:       copyCounts(counters.Drops),
+		NUDFailures: copyCounts(counters.NUDFailures),
+		DryRun:      make(map[string]bool, len(dryRuns)),
+		WouldSend:   make(map[string]map[string]uint64, len(dryRuns)),
+	}
+	for instance, dryRun := range dryRuns {
+		result.DryRun[instance] = true
+		result.WouldSend[instance] = map[string]uint64{
+			"solicitations":  dryRun.solicitations.Load(),
+			"advertisements": dryRun.advertisements.Load(),
+		}
+	}
+	return result
+}
+
+func copyCounts(counts map[string]map[string]uint64) map[string]map[string]uint64 {
+	result := make(map[string]map[string]uint64, len(counts))
+	for instance, instanceCounts := range counts {
+		result[instance] = make(map[string]uint64, len(instanceCounts))
+		for key, count := range instanceCounts {
+			result[instance][key] = count
+		}
+	}
+	return result
+}
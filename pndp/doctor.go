@@ -0,0 +1,378 @@
+package pndp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Severity ranks a Finding of the diagnostics
+type Severity int
+
+const (
+	// SeverityOK reports a check that passed
+	SeverityOK Severity = 0
+	// SeverityWarning reports a setting that is likely to cause problems
+	SeverityWarning Severity = 1
+	// SeverityError reports a setting that prevents the instance from working
+	SeverityError Severity = 2
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityOK:
+		return "ok"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Finding is the result of a single check of the host configuration
+type Finding struct {
+	Severity Severity
+	// Instance describes the instance the finding is about (empty for findings about the host)
+	Instance string
+	Message  string
+	// Fix is a suggestion to resolve the finding (empty if there is nothing to do)
+	Fix string
+}
+
+// sysctlRoot and procRoot are replaced in tests
+var (
+	sysctlRoot = "/proc/sys"
+	procRoot   = "/proc"
+)
+
+// route is an IPv6 route of the main routing table
+type route struct {
+	dst       *net.IPNet
+	oif       int
+	routeType uint8
+}
+
+// readRoutes returns the IPv6 routes of the main routing table. It is replaced in tests.
+var readRoutes = readSystemRoutes
+
+// DiagnoseHost checks the capabilities that instances need on every host. The capabilities of the daemon that listens
+// on the control socket at socketPath are checked. If no daemon is running, the capabilities of the calling process
+// (and so of the shell it was started from) are checked instead.
+func DiagnoseHost(socketPath string) []Finding {
+	statusPath := filepath.Join(procRoot, "self", "status")
+	subject := "This shell"
+	if pid, err := controlSocketPid(socketPath); err == nil {
+		statusPath = filepath.Join(procRoot, strconv.Itoa(pid), "status")
+		subject = fmt.Sprintf("The running daemon (pid %d)", pid)
+	}
+	status, err := os.ReadFile(statusPath)
+	if err != nil {
+		return []Finding{{Severity: SeverityWarning, Message: "Unable to read the capabilities: " + err.Error()}}
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		value, found := strings.CutPrefix(line, "CapEff:")
+		if !found {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			break
+		}
+		if caps&(1<<unix.CAP_NET_RAW) == 0 {
+			return []Finding{{
+				Severity: SeverityError,
+				Message:  subject + " lacks CAP_NET_RAW, so the raw sockets of the instances cannot be opened",
+				Fix:      "run pndpd as root or grant the capability (for example AmbientCapabilities=CAP_NET_RAW in the systemd unit)",
+			}}
+		}
+		return []Finding{{Severity: SeverityOK, Message: subject + " has CAP_NET_RAW"}}
+	}
+	return []Finding{{Severity: SeverityWarning, Message: "Unable to determine the capabilities"}}
+}
+
+// Diagnose checks the host configuration the proxy depends on: IPv6 forwarding, the kernel NDP proxy,
+// routes towards the internal interface for the filter and the addresses assigned to both interfaces
+func (obj *ProxyObj) Diagnose() []Finding {
+	config := obj.engineConfig()
+	d := diagnosis{network: obj.getNetwork(), instance: config.name()}
+	ext, extOK := d.checkInterface(config.Iface1)
+	internal, intOK := d.checkInterface(config.Iface2)
+	if !extOK || !intOK {
+		return d.findings
+	}
+
+	d.checkSysctl("all", "forwarding", "1", SeverityError, "IPv6 forwarding is disabled, so proxied packets are not routed")
+	for _, iface := range []string{config.Iface1, config.Iface2} {
+		d.checkSysctl(iface, "forwarding", "1", SeverityWarning, "IPv6 forwarding is disabled on "+iface)
+	}
+	d.checkSysctl(config.Iface1, "proxy_ndp", "0", SeverityWarning, "The kernel NDP proxy is enabled on "+config.Iface1+" and answers in addition to pndpd")
+
+	d.checkSharedAddresses(ext, internal)
+
+	filter := obj.filter
+	if config.Autosense != "" {
+		filter = d.autosenseNetworks(config.Autosense)
+	} else if filter == nil {
+		d.add(SeverityWarning, "No filter is configured, so solicitations for any address are proxied", "add filter lines or autosense "+obj.iface2)
+	}
+	// The routing table of the own namespace has no routes towards interfaces in other namespaces
+	if d.namespace(config.Iface2) == "" {
+		d.checkRoutes(filter, internal)
+	}
+	return d.findings
+}
+
+// Diagnose checks the host configuration of the instances of the interfaces that currently match the pattern of the group
+func (group *ProxyGroup) Diagnose() []Finding {
+	instance := group.template.engineConfig().name()
+	ifaces, err := group.matchingInterfaces()
+	if err != nil {
+		return []Finding{{Severity: SeverityWarning, Instance: instance, Message: "Unable to list the interfaces: " + err.Error()}}
+	}
+	if len(ifaces) == 0 {
+		return []Finding{{Severity: SeverityOK, Instance: instance, Message: "No interface matches " + group.template.engineConfig().Iface2 + " yet, instances are started when one is created"}}
+	}
+	var findings []Finding
+	for _, iface := range ifaces {
+		findings = append(findings, group.template.forInterface(iface).Diagnose()...)
+	}
+	return findings
+}
+
+// Diagnose checks the host configuration the responder depends on
+func (obj *ResponderObj) Diagnose() []Finding {
+	config := obj.engineConfig()
+	d := diagnosis{network: obj.getNetwork(), instance: config.name()}
+	if _, ok := d.checkInterface(config.Iface1); !ok {
+		return d.findings
+	}
+	d.checkSysctl(config.Iface1, "proxy_ndp", "0", SeverityWarning, "The kernel NDP proxy is enabled on "+config.Iface1+" and answers in addition to pndpd")
+	if config.Autosense != "" {
+		d.autosenseNetworks(config.Autosense)
+	} else if obj.filter == nil {
+		d.add(SeverityWarning, "No filter is configured, so solicitations for any address are answered", "add filter lines or autosense "+obj.iface)
+	}
+	return d.findings
+}
+
+type diagnosis struct {
+	network  Network
+	instance string
+	findings []Finding
+}
+
+func (d *diagnosis) add(severity Severity, message string, fix string) {
+	d.findings = append(d.findings, Finding{Severity: severity, Instance: d.instance, Message: message, Fix: fix})
+}
+
+// namespace returns the path of the network namespace of iface or "" if it is in the own namespace
+func (d *diagnosis) namespace(iface string) string {
+	if n, ok := d.network.(*namespacedNetwork); ok {
+		return n.namespace(iface)
+	}
+	return ""
+}
+
+// localName returns the name of iface (a namespaced name, see namespacedName) within its network namespace
+func (d *diagnosis) localName(iface string) string {
+	if n, ok := d.network.(*namespacedNetwork); ok {
+		iface, _ = n.split(iface)
+	}
+	return iface
+}
+
+// inNamespace returns command prefixed so that it runs in the network namespace of iface
+func (d *diagnosis) inNamespace(iface string, command string) string {
+	if netns := d.namespace(iface); netns != "" {
+		return "nsenter --net=" + netns + " " + command
+	}
+	return command
+}
+
+// readFile reads a file (such as a sysctl) in the network namespace of iface
+func (d *diagnosis) readFile(iface string, name string) ([]byte, error) {
+	if n, ok := d.network.(*namespacedNetwork); ok {
+		return n.readFile(iface, name)
+	}
+	return os.ReadFile(name)
+}
+
+func (d *diagnosis) checkInterface(name string) (*net.Interface, bool) {
+	iface, err := d.network.InterfaceByName(name)
+	if err != nil {
+		d.add(SeverityError, fmt.Sprintf("Interface %s does not exist", name), "")
+		return nil, false
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		d.add(SeverityWarning, fmt.Sprintf("Interface %s is down", name), d.inNamespace(name, "ip link set "+d.localName(name)+" up"))
+	}
+	return iface, true
+}
+
+// checkSysctl compares net.ipv6.conf.<iface>.<key> with the expected value
+func (d *diagnosis) checkSysctl(iface string, key string, expected string, severity Severity, message string) {
+	localName := d.localName(iface)
+	name := fmt.Sprintf("net.ipv6.conf.%s.%s", localName, key)
+	value, err := d.readFile(iface, filepath.Join(sysctlRoot, "net", "ipv6", "conf", localName, key))
+	if err != nil {
+		d.add(SeverityWarning, "Unable to read "+name+": "+err.Error(), "")
+		return
+	}
+	if actual := strings.TrimSpace(string(value)); actual != expected {
+		d.add(severity, fmt.Sprintf("%s (%s = %s)", message, name, actual), d.inNamespace(iface, fmt.Sprintf("sysctl -w %s=%s", name, expected)))
+		return
+	}
+	d.add(SeverityOK, fmt.Sprintf("%s = %s", name, expected), "")
+}
+
+// checkSharedAddresses reports addresses that are assigned to both interfaces of a proxy
+func (d *diagnosis) checkSharedAddresses(ext *net.Interface, internal *net.Interface) {
+	extAddrs, err := d.network.Addrs(ext)
+	if err != nil {
+		d.add(SeverityWarning, "Unable to read the addresses of "+ext.Name+": "+err.Error(), "")
+		return
+	}
+	intAddrs, err := d.network.Addrs(internal)
+	if err != nil {
+		d.add(SeverityWarning, "Unable to read the addresses of "+internal.Name+": "+err.Error(), "")
+		return
+	}
+	for _, a := range getInterfaceNetworkList(extAddrs) {
+		if a.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		for _, b := range getInterfaceNetworkList(intAddrs) {
+			if a.IP.Equal(b.IP) {
+				d.add(SeverityError, fmt.Sprintf("%s is assigned to both %s and %s", a.IP, ext.Name, internal.Name),
+					fmt.Sprintf("remove the address from one of the interfaces (ip -6 addr del %s dev %s)", b, internal.Name))
+			}
+		}
+	}
+}
+
+// autosenseNetworks returns the networks of the autosense interface and reports if there are none
+func (d *diagnosis) autosenseNetworks(name string) []*net.IPNet {
+	iface, err := d.network.InterfaceByName(name)
+	if err != nil {
+		d.add(SeverityError, fmt.Sprintf("Autosense interface %s does not exist", name), "")
+		return nil
+	}
+	addrs, err := d.network.Addrs(iface)
+	if err != nil {
+		d.add(SeverityWarning, "Unable to read the addresses of "+name+": "+err.Error(), "")
+		return nil
+	}
+	var networks []*net.IPNet
+	for _, n := range getInterfaceNetworkList(addrs) {
+		if !n.IP.IsLinkLocalUnicast() {
+			networks = append(networks, n)
+		}
+	}
+	if len(networks) == 0 {
+		d.add(SeverityWarning, fmt.Sprintf("Autosense interface %s has no IPv6 addresses, so nothing is answered for", name),
+			"assign the routed prefix to "+name)
+	}
+	return networks
+}
+
+// checkRoutes verifies that every network of the filter is routed towards the internal interface
+func (d *diagnosis) checkRoutes(filter []*net.IPNet, internal *net.Interface) {
+	if len(filter) == 0 {
+		return
+	}
+	routes, err := readRoutes()
+	if err != nil {
+		d.add(SeverityWarning, "Unable to read the routing table: "+err.Error(), "")
+		return
+	}
+	for _, n := range filter {
+		_, network, _ := net.ParseCIDR(n.String())
+		best := findRoute(routes, network)
+		switch {
+		case best == nil:
+			d.add(SeverityError, fmt.Sprintf("There is no route for %s", network), fmt.Sprintf("ip -6 route add %s dev %s", network, internal.Name))
+		case best.routeType != unix.RTN_UNICAST:
+			d.add(SeverityError, fmt.Sprintf("%s is covered by a route of type %d (%s) instead of a route towards %s", network, best.routeType, best.dst, internal.Name),
+				fmt.Sprintf("ip -6 route add %s dev %s", network, internal.Name))
+		case best.oif != internal.Index:
+			d.add(SeverityError, fmt.Sprintf("%s is routed via %s instead of %s", network, d.interfaceName(best.oif), internal.Name),
+				fmt.Sprintf("ip -6 route add %s dev %s", network, internal.Name))
+		default:
+			d.add(SeverityOK, fmt.Sprintf("%s is routed towards %s", network, internal.Name), "")
+		}
+	}
+}
+
+func (d *diagnosis) interfaceName(index int) string {
+	if iface, err := net.InterfaceByIndex(index); err == nil {
+		return iface.Name
+	}
+	return fmt.Sprintf("interface %d", index)
+}
+
+// findRoute returns the most specific route that covers the whole network (nil if there is none)
+func findRoute(routes []route, network *net.IPNet) *route {
+	networkLen, _ := network.Mask.Size()
+	var best *route
+	bestLen := -1
+	for i := range routes {
+		routeLen, _ := routes[i].dst.Mask.Size()
+		if routeLen > networkLen || routeLen <= bestLen || !routes[i].dst.Contains(network.IP) {
+			continue
+		}
+		best = &routes[i]
+		bestLen = routeLen
+	}
+	return best
+}
+
+func readSystemRoutes() ([]route, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETROUTE, syscall.AF_INET6)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	var routes []route
+	for _, m := range messages {
+		if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < unix.SizeofRtMsg {
+			continue
+		}
+		var msg unix.RtMsg
+		if err := binary.Read(bytes.NewReader(m.Data[:unix.SizeofRtMsg]), binary.NativeEndian, &msg); err != nil {
+			return nil, err
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return nil, err
+		}
+		r := route{dst: &net.IPNet{IP: net.IPv6unspecified, Mask: net.CIDRMask(int(msg.Dst_len), 128)}, routeType: msg.Type}
+		table := uint32(msg.Table)
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case unix.RTA_DST:
+				r.dst.IP = net.IP(attr.Value)
+			case unix.RTA_OIF:
+				r.oif = int(binary.NativeEndian.Uint32(attr.Value))
+			case unix.RTA_TABLE:
+				table = binary.NativeEndian.Uint32(attr.Value)
+			}
+		}
+		if table == unix.RT_TABLE_MAIN {
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
@@ -0,0 +1,115 @@
+package pndp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeTestSysctl(t *testing.T, root string, iface string, key string, value string) {
+	t.Helper()
+	dir := filepath.Join(root, "net", "ipv6", "conf", iface)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, key), []byte(value+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProxyDiagnose(t *testing.T) {
+	root := t.TempDir()
+	writeTestSysctl(t, root, "all", "forwarding", "0")
+	writeTestSysctl(t, root, "mem-ext", "forwarding", "1")
+	writeTestSysctl(t, root, "mem-int", "forwarding", "1")
+	writeTestSysctl(t, root, "mem-ext", "proxy_ndp", "1")
+	oldRoot, oldRoutes := sysctlRoot, readRoutes
+	defer func() { sysctlRoot, readRoutes = oldRoot, oldRoutes }()
+	sysctlRoot = root
+
+	network := NewMemoryNetwork()
+	ext := network.AddLink("mem-ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+	internal := network.AddLink("mem-int", testIntMAC, mustParseIfaceIP("fd00::1/64"), mustParseIfaceIP("fd01::1/64"))
+	readRoutes = func() ([]route, error) {
+		return []route{
+			{dst: mustParseIfaceIP("::/0"), oif: ext.iface.Index, routeType: 1},
+			{dst: mustParseIfaceIP("fd01::/64"), oif: internal.iface.Index, routeType: 1},
+		}, nil
+	}
+
+	proxy := NewProxy("mem-ext", "mem-int", ParseFilter("fd01::/64;fd02::/64"), "", true)
+	proxy.SetNetwork(network)
+	var got []string
+	for _, finding := range proxy.Diagnose() {
+		got = append(got, finding.Severity.String()+" "+finding.Message)
+	}
+	want := []string{
+		"error IPv6 forwarding is disabled, so proxied packets are not routed (net.ipv6.conf.all.forwarding = 0)",
+		"ok net.ipv6.conf.mem-ext.forwarding = 1",
+		"ok net.ipv6.conf.mem-int.forwarding = 1",
+		"warning The kernel NDP proxy is enabled on mem-ext and answers in addition to pndpd (net.ipv6.conf.mem-ext.proxy_ndp = 1)",
+		"error fd00::1 is assigned to both mem-ext and mem-int",
+		"ok fd01::/64 is routed towards mem-int",
+		"error fd02::/64 is routed via interface -1 instead of mem-int",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected findings\n%q\nbut got\n%q", want, got)
+	}
+}
+
+func TestFindRoute(t *testing.T) {
+	routes := []route{
+		{dst: mustParseIfaceIP("::/0"), oif: 1},
+		{dst: mustParseIfaceIP("fd01::/48"), oif: 2},
+		{dst: mustParseIfaceIP("fd01::/64"), oif: 3},
+		{dst: mustParseIfaceIP("fd01::1/128"), oif: 4},
+	}
+	for network, oif := range map[string]int{"fd01::/64": 3, "fd01:0:0:1::/64": 2, "fd01::/32": 1, "fd01::/56": 2} {
+		_, n, _ := net.ParseCIDR(network)
+		if best := findRoute(routes, n); best == nil || best.oif != oif {
+			t.Errorf("Expected %s to be routed via %d, but got %v", network, oif, best)
+		}
+	}
+}
+
+func TestDiagnoseHost(t *testing.T) {
+	oldRoot := procRoot
+	defer func() { procRoot = oldRoot }()
+	procRoot = t.TempDir()
+	writeStatus := func(pid string, caps string) {
+		if err := os.MkdirAll(filepath.Join(procRoot, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(procRoot, pid, "status"), []byte("Name:\tpndpd\nCapEff:\t"+caps+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	socketPath := filepath.Join(t.TempDir(), "pndpd.sock")
+
+	// Without a running daemon the capabilities of the own process are checked
+	for caps, severity := range map[string]Severity{"0000000000002000": SeverityOK, "0000000000001000": SeverityError} {
+		writeStatus("self", caps)
+		findings := DiagnoseHost(socketPath)
+		if len(findings) != 1 || findings[0].Severity != severity || !strings.HasPrefix(findings[0].Message, "This shell") {
+			t.Errorf("Expected a single finding of severity %s about this shell for %s, but got %v", severity, caps, findings)
+		}
+	}
+
+	// The daemon is found through its control socket
+	socket, err := ListenControlSocket(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = socket.Close() }()
+	writeStatus("self", "0000000000002000")
+	writeStatus(strconv.Itoa(os.Getpid()), "0000000000001000")
+	findings := DiagnoseHost(socketPath)
+	if len(findings) != 1 || findings[0].Severity != SeverityError || !strings.Contains(findings[0].Message, fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("Expected the missing capability of the daemon, but got %v", findings)
+	}
+}
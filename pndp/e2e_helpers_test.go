@@ -0,0 +1,544 @@
+//go:build e2e
+
+package pndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"pndpd/pndp/ndp"
+)
+
+// netnsHandle is a network namespace that is entered by a dedicated, locked OS thread.
+// All functions passed to do() run on that thread and therefore in the namespace.
+type netnsHandle struct {
+	fd    int
+	calls chan func()
+	done  chan struct{}
+}
+
+// newNetns creates a new network namespace. The namespace is destroyed by close().
+func newNetns(t *testing.T) *netnsHandle {
+	t.Helper()
+	ns := &netnsHandle{
+		calls: make(chan func()),
+		done:  make(chan struct{}),
+	}
+	ready := make(chan error)
+	go func() {
+		// The thread is never unlocked, so that it is terminated instead of being reused
+		// by the Go runtime in the wrong namespace
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			ready <- err
+			return
+		}
+		fd, err := unix.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			ready <- err
+			return
+		}
+		ns.fd = fd
+		ready <- nil
+		for {
+			select {
+			case <-ns.done:
+				return
+			case f := <-ns.calls:
+				f()
+			}
+		}
+	}()
+	if err := <-ready; err != nil {
+		t.Fatal("Unable to create network namespace:", err)
+	}
+	t.Cleanup(ns.close)
+	return ns
+}
+
+func (ns *netnsHandle) do(f func()) {
+	finished := make(chan struct{})
+	ns.calls <- func() {
+		defer close(finished)
+		f()
+	}
+	<-finished
+}
+
+func (ns *netnsHandle) close() {
+	close(ns.done)
+	_ = unix.Close(ns.fd)
+}
+
+// rtnetlink performs a single rtnetlink request and waits for the acknowledgement
+func rtnetlink(msgType uint16, flags uint16, body []byte) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	msg := make([]byte, unix.NLMSG_HDRLEN, unix.NLMSG_HDRLEN+len(body))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.NLMSG_HDRLEN+len(body)))
+	binary.NativeEndian.PutUint16(msg[4:6], msgType)
+	binary.NativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK|flags)
+	binary.NativeEndian.PutUint32(msg[8:12], 1)
+	msg = append(msg, body...)
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return err
+	}
+	messages, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		if m.Header.Type == unix.NLMSG_ERROR {
+			if code := int32(binary.NativeEndian.Uint32(m.Data[0:4])); code != 0 {
+				return syscall.Errno(-code)
+			}
+			return nil
+		}
+	}
+	return errors.New("no acknowledgement received")
+}
+
+func rtAttr(attrType uint16, value []byte) []byte {
+	length := unix.SizeofRtAttr + len(value)
+	attr := make([]byte, rtaAlign(length))
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(length))
+	binary.NativeEndian.PutUint16(attr[2:4], attrType)
+	copy(attr[unix.SizeofRtAttr:], value)
+	return attr
+}
+
+func rtaAlign(length int) int {
+	return (length + unix.RTA_ALIGNTO - 1) & ^(unix.RTA_ALIGNTO - 1)
+}
+
+func ifInfoMsg(index int, flags uint32, change uint32) []byte {
+	msg := unix.IfInfomsg{
+		Family: unix.AF_UNSPEC,
+		Index:  int32(index),
+		Flags:  flags,
+		Change: change,
+	}
+	return append([]byte(nil), (*[unix.SizeofIfInfomsg]byte)(unsafe.Pointer(&msg))[:]...)
+}
+
+func cString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func uint32Attr(attrType uint16, value uint32) []byte {
+	b := make([]byte, 4)
+	binary.NativeEndian.PutUint32(b, value)
+	return rtAttr(attrType, b)
+}
+
+// createVeth creates a veth pair in the current namespace and moves the peer into peerNs
+func createVeth(t *testing.T, name string, peerName string, peerNs *netnsHandle) {
+	t.Helper()
+	peer := ifInfoMsg(0, 0, 0)
+	peer = append(peer, rtAttr(unix.IFLA_IFNAME, cString(peerName))...)
+	peer = append(peer, uint32Attr(unix.IFLA_NET_NS_FD, uint32(peerNs.fd))...)
+
+	const vethInfoPeer = 1
+	linkInfo := rtAttr(unix.IFLA_INFO_KIND, cString("veth"))
+	linkInfo = append(linkInfo, rtAttr(unix.IFLA_INFO_DATA, rtAttr(vethInfoPeer, peer))...)
+
+	body := ifInfoMsg(0, 0, 0)
+	body = append(body, rtAttr(unix.IFLA_IFNAME, cString(name))...)
+	body = append(body, rtAttr(unix.IFLA_LINKINFO, linkInfo)...)
+	if err := rtnetlink(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, body); err != nil {
+		t.Fatalf("Unable to create veth pair %s/%s: %s", name, peerName, err)
+	}
+	t.Cleanup(func() {
+		if iface, err := net.InterfaceByName(name); err == nil {
+			_ = rtnetlink(unix.RTM_DELLINK, 0, ifInfoMsg(iface.Index, 0, 0))
+		}
+	})
+}
+
+// moveLink moves an interface of the current namespace into ns
+func moveLink(name string, ns *netnsHandle) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	body := append(ifInfoMsg(iface.Index, 0, 0), uint32Attr(unix.IFLA_NET_NS_FD, uint32(ns.fd))...)
+	if err := rtnetlink(unix.RTM_NEWLINK, 0, body); err != nil {
+		return fmt.Errorf("unable to move %s: %w", name, err)
+	}
+	return nil
+}
+
+// renameLink renames an interface in the namespace of the calling thread. The interface must be down.
+func renameLink(name string, newName string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	body := append(ifInfoMsg(iface.Index, 0, 0), rtAttr(unix.IFLA_IFNAME, cString(newName))...)
+	if err := rtnetlink(unix.RTM_NEWLINK, 0, body); err != nil {
+		return fmt.Errorf("unable to rename %s: %w", name, err)
+	}
+	return nil
+}
+
+// path returns a path of the namespace that can be opened by other threads of the process
+func (ns *netnsHandle) path() string {
+	return fmt.Sprintf("/proc/self/fd/%d", ns.fd)
+}
+
+// deleteLink deletes an interface in the namespace of the calling thread
+func deleteLink(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	return rtnetlink(unix.RTM_DELLINK, 0, ifInfoMsg(iface.Index, 0, 0))
+}
+
+// setLinkUp sets the administrative state of an interface in the namespace of the calling thread
+func setLinkUp(name string, up bool) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	var flags uint32
+	if up {
+		flags = unix.IFF_UP
+	}
+	if err := rtnetlink(unix.RTM_NEWLINK, 0, ifInfoMsg(iface.Index, flags, unix.IFF_UP)); err != nil {
+		return fmt.Errorf("unable to change the state of %s: %w", name, err)
+	}
+	return nil
+}
+
+// setHardwareAddr changes the MAC of an interface in the namespace of the calling thread
+func setHardwareAddr(name string, mac net.HardwareAddr) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	body := append(ifInfoMsg(iface.Index, 0, 0), rtAttr(unix.IFLA_ADDRESS, mac)...)
+	if err := rtnetlink(unix.RTM_NEWLINK, 0, body); err != nil {
+		return fmt.Errorf("unable to change the hardware address of %s: %w", name, err)
+	}
+	return nil
+}
+
+// waitForLink waits until an interface in the namespace of the calling thread is operational
+func waitForLink(name string) error {
+	for i := 0; i < 100; i++ {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return err
+		}
+		if iface.Flags&net.FlagRunning != 0 {
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return fmt.Errorf("interface %s did not come up", name)
+}
+
+// addAddress assigns an IPv6 address in CIDR notation to an interface in the current namespace (without DAD)
+func addAddress(t *testing.T, name string, cidr string) {
+	t.Helper()
+	if err := assignAddress(name, cidr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assignAddress assigns an IPv6 address in CIDR notation to an interface in the namespace of the calling thread (without DAD)
+func assignAddress(name string, cidr string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	prefixLen, _ := ipNet.Mask.Size()
+	msg := unix.IfAddrmsg{
+		Family:    unix.AF_INET6,
+		Prefixlen: uint8(prefixLen),
+		Flags:     unix.IFA_F_NODAD,
+		Index:     uint32(iface.Index),
+	}
+	body := append([]byte(nil), (*[unix.SizeofIfAddrmsg]byte)(unsafe.Pointer(&msg))[:]...)
+	body = append(body, rtAttr(unix.IFA_LOCAL, ip.To16())...)
+	body = append(body, rtAttr(unix.IFA_ADDRESS, ip.To16())...)
+	body = append(body, uint32Attr(unix.IFA_FLAGS, unix.IFA_F_NODAD)...)
+	if err := rtnetlink(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, body); err != nil {
+		return fmt.Errorf("unable to add %s to %s: %w", cidr, name, err)
+	}
+	return nil
+}
+
+func writeSysctl(t *testing.T, path string, value string) {
+	t.Helper()
+	if err := os.WriteFile("/proc/sys/"+path, []byte(value), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// e2ePacket is a decoded Neighbor Solicitation or Advertisement
+type e2ePacket struct {
+	srcMAC net.HardwareAddr
+	dstMAC net.HardwareAddr
+	srcIP  net.IP
+	dstIP  net.IP
+	// icmpType is 135 for solicitations and 136 for advertisements
+	icmpType  byte
+	hopLimit  byte
+	flags     byte
+	target    net.IP
+	optionMAC net.HardwareAddr
+}
+
+func (p *e2ePacket) String() string {
+	name := "NA"
+	if p.icmpType == 135 {
+		name = "NS"
+	}
+	return fmt.Sprintf("%s %s -> %s (%s -> %s) target %s flags %#02x lladdr %s",
+		name, p.srcIP, p.dstIP, p.srcMAC, p.dstMAC, p.target, p.flags, p.optionMAC)
+}
+
+func decodeE2EPacket(frame []byte) *e2ePacket {
+	if len(frame) < 78 || frame[12] != 0x86 || frame[13] != 0xdd || frame[20] != 0x3a {
+		return nil
+	}
+	if frame[54] != 135 && frame[54] != 136 {
+		return nil
+	}
+	p := &e2ePacket{
+		dstMAC:   append(net.HardwareAddr(nil), frame[0:6]...),
+		srcMAC:   append(net.HardwareAddr(nil), frame[6:12]...),
+		hopLimit: frame[21],
+		srcIP:    append(net.IP(nil), frame[22:38]...),
+		dstIP:    append(net.IP(nil), frame[38:54]...),
+		icmpType: frame[54],
+		flags:    frame[58],
+		target:   append(net.IP(nil), frame[62:78]...),
+	}
+	if len(frame) >= 86 && (frame[78] == 1 || frame[78] == 2) && frame[79] == 1 {
+		p.optionMAC = append(net.HardwareAddr(nil), frame[80:86]...)
+	}
+	return p
+}
+
+// buildE2EFrame constructs an Ethernet frame with a Neighbor Solicitation (icmpType 135) or Advertisement (136).
+// The link-layer address option is omitted if optionMAC is nil.
+func buildE2EFrame(dstMAC net.HardwareAddr, srcMAC net.HardwareAddr, srcIP net.IP, dstIP net.IP, icmpType byte, flags byte, target net.IP, optionMAC net.HardwareAddr) []byte {
+	var options []ndp.Option
+	if optionMAC != nil {
+		options = []ndp.Option{&ndp.LinkLayerAddress{Target: icmpType == 136, Addr: optionMAC}}
+	}
+	var message ndp.Message = &ndp.NeighborSolicitation{TargetAddress: target, Options: options}
+	if icmpType == 136 {
+		message = &ndp.NeighborAdvertisement{
+			Router:        flags&0x80 != 0,
+			Solicited:     flags&0x40 != 0,
+			Override:      flags&0x20 != 0,
+			TargetAddress: target,
+			Options:       options,
+		}
+	}
+	packet, err := (&ndp.Packet{Source: srcIP, Destination: dstIP, HopLimit: 255, Message: message}).Marshal()
+	if err != nil {
+		panic(err)
+	}
+
+	frame := append(append([]byte(nil), dstMAC...), srcMAC...)
+	frame = append(frame, 0x86, 0xdd)
+	return append(frame, packet...)
+}
+
+func solicitedNodeMulticast(ip net.IP) net.IP {
+	result := net.ParseIP("ff02::1:ff00:0")
+	copy(result[13:], ip.To16()[13:])
+	return result
+}
+
+// e2eHost simulates a host with a raw socket on an interface inside a namespace.
+// It answers solicitations for its addresses and records all other NDP packets.
+type e2eHost struct {
+	t       *testing.T
+	name    string
+	iface   *net.Interface
+	file    *os.File
+	addrs   []net.IP
+	packets chan *e2ePacket
+}
+
+func newE2EHost(t *testing.T, ns *netnsHandle, ifaceName string, addrs ...string) *e2eHost {
+	t.Helper()
+	host := &e2eHost{
+		t:       t,
+		name:    ifaceName,
+		packets: make(chan *e2ePacket, 100),
+	}
+	for _, a := range addrs {
+		host.addrs = append(host.addrs, net.ParseIP(a))
+	}
+
+	var err error
+	ns.do(func() {
+		host.iface, err = net.InterfaceByName(ifaceName)
+		if err != nil {
+			return
+		}
+		var fd int
+		fd, err = unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, htons(unix.ETH_P_IPV6))
+		if err != nil {
+			return
+		}
+		err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons16(unix.ETH_P_IPV6), Ifindex: host.iface.Index})
+		if err != nil {
+			_ = unix.Close(fd)
+			return
+		}
+		_ = unix.SetNonblock(fd, true)
+		host.file = os.NewFile(uintptr(fd), ifaceName)
+	})
+	if err != nil {
+		t.Fatalf("Unable to set up host on %s: %s", ifaceName, err)
+	}
+	t.Cleanup(func() { _ = host.file.Close() })
+	go host.run()
+	return host
+}
+
+func (h *e2eHost) run() {
+	for {
+		buf := make([]byte, 1500)
+		n, err := h.file.Read(buf)
+		if err != nil {
+			return
+		}
+		p := decodeE2EPacket(buf[:n])
+		if p == nil || p.srcMAC.String() == h.iface.HardwareAddr.String() {
+			continue
+		}
+		if p.icmpType == 135 && h.owns(p.target) {
+			dst := p.srcIP
+			dstMAC := p.srcMAC
+			flags := byte(0x60) // Solicited, Override
+			if p.srcIP.IsUnspecified() {
+				dst = net.ParseIP("ff02::1")
+				dstMAC = multicastMAC(dst)
+				flags = 0x20
+			}
+			h.send(buildE2EFrame(dstMAC, h.iface.HardwareAddr, p.target, dst, 136, flags, p.target, h.iface.HardwareAddr))
+		}
+		select {
+		case h.packets <- p:
+		default:
+		}
+	}
+}
+
+func (h *e2eHost) owns(ip net.IP) bool {
+	for _, a := range h.addrs {
+		if a.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *e2eHost) send(frame []byte) {
+	if _, err := h.file.Write(frame); err != nil {
+		h.t.Errorf("Unable to send frame on %s: %s", h.name, err)
+	}
+}
+
+// solicit sends a multicast Neighbor Solicitation for target from srcIP (which may be unspecified)
+func (h *e2eHost) solicit(srcIP string, target string) {
+	src := net.ParseIP(srcIP)
+	targetIP := net.ParseIP(target)
+	dst := solicitedNodeMulticast(targetIP)
+	var optionMAC net.HardwareAddr
+	if !src.IsUnspecified() {
+		optionMAC = h.iface.HardwareAddr
+	}
+	h.send(buildE2EFrame(multicastMAC(dst), h.iface.HardwareAddr, src, dst, 135, 0, targetIP, optionMAC))
+}
+
+// expect waits for an NDP packet that matches and fails the test with the packets seen otherwise
+func (h *e2eHost) expect(description string, timeout time.Duration, match func(p *e2ePacket) bool) *e2ePacket {
+	h.t.Helper()
+	seen := make([]string, 0)
+	deadline := time.After(timeout)
+	for {
+		select {
+		case p := <-h.packets:
+			if match(p) {
+				return p
+			}
+			seen = append(seen, p.String())
+		case <-deadline:
+			h.t.Fatalf("%s: expected %s, but only saw %q", h.name, description, seen)
+			return nil
+		}
+	}
+}
+
+// expectNone fails the test if a matching NDP packet is received within the timeout
+func (h *e2eHost) expectNone(description string, timeout time.Duration, match func(p *e2ePacket) bool) {
+	h.t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case p := <-h.packets:
+			if match(p) {
+				h.t.Fatalf("%s: expected no %s, but got %s", h.name, description, p)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+func (h *e2eHost) drain() {
+	for {
+		select {
+		case <-h.packets:
+		default:
+			return
+		}
+	}
+}
+
+func isNS(target string) func(p *e2ePacket) bool {
+	ip := net.ParseIP(target)
+	return func(p *e2ePacket) bool { return p.icmpType == 135 && p.target.Equal(ip) }
+}
+
+func isNA(target string) func(p *e2ePacket) bool {
+	ip := net.ParseIP(target)
+	return func(p *e2ePacket) bool { return p.icmpType == 136 && p.target.Equal(ip) }
+}
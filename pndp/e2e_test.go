@@ -0,0 +1,522 @@
+//go:build e2e
+
+package pndp
+
+// End-to-end tests that run proxy and responder instances against real interfaces.
+// They require root and are run with "go test -tags=e2e ./pndp" (or "make test-e2e").
+//
+// The test binary re-executes itself in a new network namespace which acts as the namespace
+// of pndpd. For each test, veth pairs connect it to an upstream namespace and an internal namespace,
+// in which simulated hosts send and receive NDP packets through raw sockets:
+//
+//	upstream ns            pndpd ns (test process)             internal ns
+//	up0 (host fd00::5) --- ext0 fd00::1/64   int0 fd01::1/64 --- host0 (host fd01::99)
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const e2eNetnsEnv = "PNDPD_E2E_NETNS"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(e2eNetnsEnv) == "" && os.Geteuid() == 0 {
+		cmd := exec.Command(os.Args[0], os.Args[1:]...)
+		cmd.Env = append(os.Environ(), e2eNetnsEnv+"=1")
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNET}
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitErr.ExitCode())
+			}
+			os.Stderr.WriteString("Unable to run tests in a new network namespace: " + err.Error() + "\n")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// e2eTopology is the test network described at the top of this file
+type e2eTopology struct {
+	upstream   *e2eHost
+	host       *e2eHost
+	internalNs *netnsHandle
+	extMAC     net.HardwareAddr
+	intMAC     net.HardwareAddr
+}
+
+func newE2ETopology(t *testing.T) *e2eTopology {
+	t.Helper()
+	if os.Getenv(e2eNetnsEnv) == "" {
+		t.Skip("end-to-end tests require root")
+	}
+
+	upstreamNs := newNetns(t)
+	internalNs := newNetns(t)
+	createVeth(t, "ext0", "up0", upstreamNs)
+	createVeth(t, "int0", "host0", internalNs)
+
+	for _, ns := range []struct {
+		handle *netnsHandle
+		iface  string
+	}{{upstreamNs, "up0"}, {internalNs, "host0"}} {
+		var err error
+		ns.handle.do(func() { err = setLinkUp(ns.iface, true) })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without DAD, the link-local addresses are usable immediately. Otherwise the kernel
+	// does not resolve neighbors for packets sent from foreign source addresses until DAD has completed.
+	writeSysctl(t, "net/ipv6/conf/ext0/accept_dad", "0")
+	writeSysctl(t, "net/ipv6/conf/int0/accept_dad", "0")
+	writeSysctl(t, "net/ipv6/conf/int0/keep_addr_on_down", "1")
+	addAddress(t, "ext0", "fd00::1/64")
+	addAddress(t, "int0", "fd01::1/64")
+	for _, iface := range []string{"ext0", "int0"} {
+		if err := setLinkUp(iface, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := waitForLink(iface); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ext, _ := net.InterfaceByName("ext0")
+	int0, _ := net.InterfaceByName("int0")
+	return &e2eTopology{
+		upstream:   newE2EHost(t, upstreamNs, "up0", "fd00::5"),
+		host:       newE2EHost(t, internalNs, "host0", "fd01::99"),
+		internalNs: internalNs,
+		extMAC:     ext.HardwareAddr,
+		intMAC:     int0.HardwareAddr,
+	}
+}
+
+func (topo *e2eTopology) startProxy(t *testing.T, filter string) {
+	t.Helper()
+	autosense := ""
+	if filter == "" {
+		autosense = "int0"
+	}
+	proxy := NewProxy("ext0", "int0", ParseFilter(filter), autosense, true)
+	proxy.Start()
+	t.Cleanup(func() { proxy.Stop() })
+	// Give the listeners time to attach
+	time.Sleep(200 * time.Millisecond)
+}
+
+func TestE2EProxySolicitation(t *testing.T) {
+	topo := newE2ETopology(t)
+	topo.startProxy(t, "")
+
+	topo.upstream.solicit("fd00::5", "fd01::99")
+
+	ns := topo.host.expect("forwarded NS for fd01::99", 2*time.Second, func(p *e2ePacket) bool {
+		return isNS("fd01::99")(p) && p.srcIP.Equal(net.ParseIP("fd01::1"))
+	})
+	if ns.optionMAC.String() != topo.intMAC.String() {
+		t.Errorf("Forwarded %s does not carry the link-layer address of int0 (%s)", ns, topo.intMAC)
+	}
+	if ns.hopLimit != 255 {
+		t.Errorf("Forwarded %s has hop limit %d", ns, ns.hopLimit)
+	}
+
+	na := topo.upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+	if !na.dstIP.Equal(net.ParseIP("fd00::5")) {
+		t.Errorf("%s is not addressed to the asker", na)
+	}
+	if na.optionMAC.String() != topo.extMAC.String() {
+		t.Errorf("%s does not advertise the link-layer address of ext0 (%s)", na, topo.extMAC)
+	}
+}
+
+func TestE2EProxyDAD(t *testing.T) {
+	topo := newE2ETopology(t)
+	topo.startProxy(t, "")
+
+	topo.upstream.solicit("::", "fd01::99")
+
+	ns := topo.host.expect("forwarded DAD NS for fd01::99", 2*time.Second, func(p *e2ePacket) bool {
+		return isNS("fd01::99")(p) && p.srcIP.IsUnspecified()
+	})
+	if ns.optionMAC != nil {
+		t.Errorf("%s carries a source link-layer address", ns)
+	}
+
+	// The host defends its address, so the conflict is reported to all nodes on the external side
+	na := topo.upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+	if !na.dstIP.Equal(net.ParseIP("ff02::1")) || na.flags&0x40 != 0 {
+		t.Errorf("%s is not an unsolicited advertisement to all nodes", na)
+	}
+}
+
+func TestE2EProxyNUD(t *testing.T) {
+	topo := newE2ETopology(t)
+	topo.startProxy(t, "")
+
+	target := net.ParseIP("fd01::99")
+	topo.upstream.send(buildE2EFrame(topo.extMAC, topo.upstream.iface.HardwareAddr, net.ParseIP("fd00::5"), target,
+		135, 0, target, topo.upstream.iface.HardwareAddr))
+
+	topo.host.expect("forwarded unicast NS for fd01::99", 2*time.Second, func(p *e2ePacket) bool {
+		return isNS("fd01::99")(p) && p.dstIP.Equal(target)
+	})
+	na := topo.upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+	if !na.dstIP.Equal(net.ParseIP("fd00::5")) {
+		t.Errorf("%s is not addressed to the asker", na)
+	}
+}
+
+func TestE2EProxyFilterDeny(t *testing.T) {
+	topo := newE2ETopology(t)
+	topo.startProxy(t, "fd01::/64")
+
+	topo.upstream.solicit("fd00::5", "fd02::99")
+	topo.host.expectNone("forwarded NS for fd02::99", 500*time.Millisecond, isNS("fd02::99"))
+
+	topo.upstream.solicit("fd00::5", "fd01::99")
+	topo.host.expect("forwarded NS for fd01::99", 2*time.Second, isNS("fd01::99"))
+}
+
+func TestE2EProxyAutosenseAddressChange(t *testing.T) {
+	topo := newE2ETopology(t)
+	topo.host.addrs = append(topo.host.addrs, net.ParseIP("fd03::99"))
+	topo.startProxy(t, "")
+
+	topo.upstream.solicit("fd00::5", "fd03::99")
+	topo.host.expectNone("forwarded NS for fd03::99", 500*time.Millisecond, isNS("fd03::99"))
+
+	addAddress(t, "int0", "fd03::1/64")
+	time.Sleep(200 * time.Millisecond)
+
+	topo.upstream.solicit("fd00::5", "fd03::99")
+	topo.host.expect("forwarded NS for fd03::99", 2*time.Second, isNS("fd03::99"))
+	topo.upstream.expect("NA for fd03::99", 2*time.Second, isNA("fd03::99"))
+}
+
+func TestE2EProxyInterfaceFlap(t *testing.T) {
+	topo := newE2ETopology(t)
+	topo.startProxy(t, "")
+
+	if err := setLinkUp("int0", false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := setLinkUp("int0", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForLink("int0"); err != nil {
+		t.Fatal(err)
+	}
+	// The host only sees the carrier of its side of the veth pair come back afterwards
+	var err error
+	topo.internalNs.do(func() { err = waitForLink("host0") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	topo.host.drain()
+
+	topo.upstream.solicit("fd00::5", "fd01::99")
+	topo.host.expect("forwarded NS for fd01::99", 2*time.Second, isNS("fd01::99"))
+	topo.upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+}
+
+func TestE2EResponder(t *testing.T) {
+	topo := newE2ETopology(t)
+	responder := NewResponder("ext0", ParseFilter("fd01::/64"), "", true)
+	responder.Start()
+	t.Cleanup(func() { responder.Stop() })
+	time.Sleep(200 * time.Millisecond)
+
+	topo.upstream.solicit("fd00::5", "fd02::99")
+	topo.upstream.expectNone("NA for fd02::99", 500*time.Millisecond, isNA("fd02::99"))
+
+	topo.upstream.solicit("fd00::5", "fd01::99")
+	na := topo.upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+	if !na.dstIP.Equal(net.ParseIP("fd00::5")) || na.optionMAC.String() != topo.extMAC.String() {
+		t.Errorf("Unexpected answer %s", na)
+	}
+}
+
+func TestE2EResponderSendFrames(t *testing.T) {
+	topo := newE2ETopology(t)
+	responder := NewResponder("ext0", ParseFilter("fd01::/64"), "", true)
+	responder.SetSendFrames(true)
+	responder.Start()
+	t.Cleanup(func() { responder.Stop() })
+	time.Sleep(200 * time.Millisecond)
+
+	// Nobody answers for fd00::7, so the kernel would never be able to resolve the asker
+	topo.upstream.solicit("fd00::7", "fd01::99")
+	na := topo.upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+	if !na.dstIP.Equal(net.ParseIP("fd00::7")) || na.dstMAC.String() != topo.upstream.iface.HardwareAddr.String() {
+		t.Errorf("%s is not sent to the link-layer address of the asker", na)
+	}
+	topo.upstream.expectNone("NS for fd00::7", 500*time.Millisecond, isNS("fd00::7"))
+}
+
+func TestE2EResponderHardwareAddrChange(t *testing.T) {
+	topo := newE2ETopology(t)
+	responder := NewResponder("ext0", ParseFilter("fd01::/64"), "", true)
+	responder.Start()
+	t.Cleanup(func() { responder.Stop() })
+	time.Sleep(200 * time.Millisecond)
+
+	changedMAC := net.HardwareAddr{0x02, 0, 0, 0, 0x01, 0x23}
+	if err := setHardwareAddr("ext0", changedMAC); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	topo.upstream.solicit("fd00::5", "fd01::99")
+	na := topo.upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+	if na.optionMAC.String() != changedMAC.String() {
+		t.Errorf("%s does not advertise the new link-layer address %s", na, changedMAC)
+	}
+}
+
+func TestE2EResponderInterfaceHotplug(t *testing.T) {
+	if os.Getenv(e2eNetnsEnv) == "" {
+		t.Skip("end-to-end tests require root")
+	}
+	upstreamNs := newNetns(t)
+	// The interface is created after the responder has started
+	responder := NewResponder("hot0", ParseFilter("fd01::/64"), "", true)
+	responder.Start()
+	t.Cleanup(func() { responder.Stop() })
+	time.Sleep(200 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		createVeth(t, "hot0", "hp0", upstreamNs)
+		var err error
+		upstreamNs.do(func() { err = setLinkUp("hp0", true) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeSysctl(t, "net/ipv6/conf/hot0/accept_dad", "0")
+		addAddress(t, "hot0", "fd00::1/64")
+		if err := setLinkUp("hot0", true); err != nil {
+			t.Fatal(err)
+		}
+		if err := waitForLink("hot0"); err != nil {
+			t.Fatal(err)
+		}
+		upstream := newE2EHost(t, upstreamNs, "hp0", "fd00::5")
+		time.Sleep(200 * time.Millisecond)
+
+		upstream.solicit("fd00::5", "fd01::99")
+		upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+
+		// The socket is reopened once the interface is recreated
+		if err := deleteLink("hot0"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func TestE2EProxyLinkDown(t *testing.T) {
+	topo := newE2ETopology(t)
+	proxy := NewProxy("ext0", "int0", nil, "int0", true)
+	proxy.Start()
+	t.Cleanup(func() { proxy.Stop() })
+	time.Sleep(200 * time.Millisecond)
+
+	if err := setLinkUp("int0", false); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is advertised, the cache entries of the upstream router expire through NUD
+	topo.upstream.expectNone("NA for fd01::1", 500*time.Millisecond, isNA("fd01::1"))
+	topo.upstream.solicit("fd00::5", "fd01::99")
+	topo.upstream.expectNone("NA for fd01::99", 500*time.Millisecond, isNA("fd01::99"))
+
+	if err := setLinkUp("int0", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForLink("int0"); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	topo.internalNs.do(func() { err = waitForLink("host0") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	topo.upstream.solicit("fd00::5", "fd01::99")
+	topo.host.expect("forwarded NS for fd01::99", 2*time.Second, isNS("fd01::99"))
+	topo.upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+}
+
+func TestE2EProxyGroup(t *testing.T) {
+	topo := newE2ETopology(t)
+	template := NewProxy("ext0", "int*", nil, "int*", true)
+	group := NewProxyGroup(template)
+	group.Start()
+	t.Cleanup(func() { group.Stop() })
+	time.Sleep(200 * time.Millisecond)
+
+	// An instance is started for an interface that is created later
+	sessionNs := newNetns(t)
+	createVeth(t, "int1", "host1", sessionNs)
+	var err error
+	sessionNs.do(func() { err = setLinkUp("host1", true) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSysctl(t, "net/ipv6/conf/int1/accept_dad", "0")
+	addAddress(t, "int1", "fd02::1/64")
+	if err := setLinkUp("int1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForLink("int1"); err != nil {
+		t.Fatal(err)
+	}
+	session := newE2EHost(t, sessionNs, "host1", "fd02::99")
+	time.Sleep(200 * time.Millisecond)
+
+	topo.upstream.solicit("fd00::5", "fd02::99")
+	session.expect("forwarded NS for fd02::99", 2*time.Second, isNS("fd02::99"))
+	topo.upstream.expect("NA for fd02::99", 2*time.Second, isNA("fd02::99"))
+	topo.host.expectNone("forwarded NS for fd02::99", 500*time.Millisecond, isNS("fd02::99"))
+
+	topo.upstream.solicit("fd00::5", "fd01::99")
+	topo.upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+
+	// The instance is stopped when the interface is deleted
+	if err := deleteLink("int1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	topo.upstream.solicit("fd00::5", "fd02::99")
+	topo.upstream.expectNone("NA for fd02::99", 500*time.Millisecond, isNA("fd02::99"))
+}
+
+func TestE2EProxyNetns(t *testing.T) {
+	topo := newE2ETopology(t)
+
+	// The internal interface is in a namespace of its own, so pndpd has to enter it.
+	// It has the same name as the external interface.
+	tenantNs := newNetns(t)
+	sessionNs := newNetns(t)
+	createVeth(t, "tenant0", "host1", sessionNs)
+	if err := moveLink("tenant0", tenantNs); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	sessionNs.do(func() { err = setLinkUp("host1", true) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantNs.do(func() {
+		if err = renameLink("tenant0", "ext0"); err == nil {
+			err = setLinkUp("ext0", true)
+		}
+		if err == nil {
+			err = waitForLink("ext0")
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := newE2EHost(t, sessionNs, "host1", "fd02::99")
+
+	proxy := NewProxy("ext0", "ext0", nil, "ext0", true)
+	proxy.SetNetns("", tenantNs.path())
+	proxy.Start()
+	t.Cleanup(func() { proxy.Stop() })
+	time.Sleep(200 * time.Millisecond)
+
+	// Nothing is autosensed yet
+	topo.upstream.solicit("fd00::5", "fd02::99")
+	session.expectNone("forwarded NS for fd02::99", 500*time.Millisecond, isNS("fd02::99"))
+
+	// Address changes are received from the netlink monitor of the namespace
+	tenantNs.do(func() { err = assignAddress("ext0", "fd02::1/64") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	topo.upstream.solicit("fd00::5", "fd02::99")
+	session.expect("forwarded NS for fd02::99", 2*time.Second, isNS("fd02::99"))
+	topo.upstream.expect("NA for fd02::99", 2*time.Second, isNA("fd02::99"))
+}
+
+func TestE2ENetnsWorker(t *testing.T) {
+	ns := newNetns(t)
+	var want unix.Stat_t
+	if err := unix.Stat(ns.path(), &want); err != nil {
+		t.Fatal(err)
+	}
+	// All calls in a namespace are made on the same thread, which has joined it
+	tids := make(map[int]bool)
+	for i := 0; i < 10; i++ {
+		err := inNetns(ns.path(), func() error {
+			tids[unix.Gettid()] = true
+			var got unix.Stat_t
+			if err := unix.Stat("/proc/thread-self/ns/net", &got); err != nil {
+				return err
+			}
+			if got.Ino != want.Ino {
+				t.Errorf("Expected the call to be made in namespace %d, but it was made in %d", want.Ino, got.Ino)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tids) != 1 {
+		t.Errorf("Expected the calls to be made on one thread, but they were made on %d", len(tids))
+	}
+}
+
+func TestE2ECaptureLength(t *testing.T) {
+	topo := newE2ETopology(t)
+	path := filepath.Join(t.TempDir(), "trace.pcapng")
+	capture, err := OpenCapture(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder := NewResponder("ext0", ParseFilter("fd01::/64"), "", true)
+	responder.SetCapture(capture)
+	responder.Start()
+	time.Sleep(200 * time.Millisecond)
+
+	// The listening socket passes the first maxFrameLen bytes of the frame to userspace
+	dst := solicitedNodeMulticast(net.ParseIP("fd01::99"))
+	frame := buildE2EFrame(multicastMAC(dst), topo.upstream.iface.HardwareAddr, net.ParseIP("fd00::5"), dst, 135, 0, net.ParseIP("fd01::99"), topo.upstream.iface.HardwareAddr)
+	frame = append(frame, make([]byte, 16)...)
+	topo.upstream.send(frame)
+	topo.upstream.expect("NA for fd01::99", 2*time.Second, isNA("fd01::99"))
+	responder.Stop()
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	packets, err := ReadCaptureFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other frames (such as those of the kernel) may have been captured as well
+	for _, p := range packets {
+		if !p.Outbound && bytes.Equal(p.Frame, frame[:maxFrameLen]) {
+			if p.Length != len(frame) {
+				t.Errorf("Expected the original length %d, but got %d", len(frame), p.Length)
+			}
+			return
+		}
+	}
+	t.Errorf("The received frame was not captured")
+}
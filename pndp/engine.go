@@ -0,0 +1,794 @@
+package pndp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"time"
+)
+
+// questionLifetime is how long a forwarded solicitation waits for an advertisement
+const questionLifetime = 10 * time.Second
+
+// maxQuestions is the maximum number of pending solicitations per interface
+const maxQuestions = 40
+
+// announceHoldoff is the minimum time between two unsolicited advertisements for the same target
+const announceHoldoff = 5 * time.Minute
+
+// reachableTime is how long a target stays reachable after an advertisement answered a forwarded solicitation.
+// Neighbor Unreachability Detection probes for reachable targets are answered without forwarding them.
+const reachableTime = 30 * time.Second
+
+// learnedLifetime is how long an address that was seen behind the internal interface of a proxy is defended
+const learnedLifetime = 10 * time.Minute
+
+var _, linkLocalSpace, _ = net.ParseCIDR("fe80::/10")
+
+// InstanceType selects the behavior of an Engine
+type InstanceType int
+
+const (
+	// ProxyInstance proxies NDP between two interfaces
+	ProxyInstance InstanceType = 0
+	// ResponderInstance answers solicitations on a single interface
+	ResponderInstance InstanceType = 1
+)
+
+// EngineConfig describes the instance an Engine makes decisions for
+type EngineConfig struct {
+	Type InstanceType
+	// Iface1 is the external interface of a proxy or the interface of a responder
+	Iface1 string
+	// Iface2 is the internal interface of a proxy. The filter and policies are applied
+	// to the solicitations that are forwarded to it.
+	Iface2 string
+	// Filter is an optional whitelist of targets (nil disables address checking)
+	Filter []*net.IPNet
+	// Autosense replaces the filter with the networks assigned to the specified interface
+	Autosense string
+	// Policies are consulted in order for every target that passes the filter
+	Policies []TargetPolicy
+	// Announce sends unsolicited advertisements (with the override flag) to all nodes on Iface1 for allowed
+	// addresses that appear behind Iface2: addresses assigned to it, advertised on it or reported by a NeighborEvent.
+	// It only applies to proxies.
+	Announce bool
+	// Defend answers Duplicate Address Detection on Iface1 right away for allowed addresses that are in use behind Iface2
+	// (assigned to it, or advertised on it, the source of solicitations on it or reported by a NeighborEvent within the
+	// last 10 minutes) instead of forwarding the solicitation. It only applies to proxies.
+	Defend bool
+	// Router selects the router flag of advertisements. By default, it is set if IPv6 forwarding is enabled
+	// on the interface the advertisement is sent on (see InterfaceEvent.Forwarding).
+	Router RouterFlag
+	// NoOverride clears the override flag of answers, so that neighbors keep an existing cache entry
+	// (for anycast addresses that are answered by several hosts)
+	NoOverride bool
+	// Strict additionally drops multicast solicitations without a source link-layer address
+	// and solicitations whose source link-layer address differs from the Ethernet source
+	Strict bool
+	// AdvertiseMAC replaces the link-layer address in advertisements sent on Iface1, for example with the virtual MAC
+	// of a VRRP group. Solicitations always carry the hardware address of the interface they are sent on.
+	AdvertiseMAC net.HardwareAddr
+	// AdvertiseIface is like AdvertiseMAC, but the current hardware address of the specified interface is used
+	AdvertiseIface string
+	// SourceAddress selects the source address of the packets that are sent. Solicitations for Duplicate Address
+	// Detection are always forwarded from the unspecified address.
+	SourceAddress SourceAddress
+	// SourceIP is the source address of all packets if SourceAddress is SourceFixed
+	SourceIP net.IP
+}
+
+// RouterFlag selects how the router flag of the advertisements of an instance is set
+type RouterFlag int
+
+const (
+	// RouterFlagAuto sets the router flag if IPv6 forwarding is enabled on the interface
+	RouterFlagAuto RouterFlag = 0
+	// RouterFlagOn always sets the router flag
+	RouterFlagOn RouterFlag = 1
+	// RouterFlagOff never sets the router flag
+	RouterFlagOff RouterFlag = 2
+)
+
+// Interfaces returns the names of all interfaces the Engine needs to know the state of
+func (c EngineConfig) Interfaces() []string {
+	result := []string{c.Iface1}
+	if c.Type == ProxyInstance {
+		result = append(result, c.Iface2)
+	}
+	for _, iface := range []string{c.Autosense, c.AdvertiseIface} {
+		if iface != "" && !slices.Contains(result, iface) {
+			result = append(result, iface)
+		}
+	}
+	return result
+}
+
+// name describes the instance, for example "proxy eth0/eth1"
+func (c EngineConfig) name() string {
+	if c.Type == ProxyInstance {
+		return fmt.Sprintf("proxy %s/%s", c.Iface1, c.Iface2)
+	}
+	return "responder " + c.Iface1
+}
+
+// Clock provides the current time to an Engine
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock is the Clock backed by the time of the host
+var SystemClock Clock = systemClock{}
+
+// Event is an input to an Engine. It is one of PacketEvent, TickEvent, InterfaceEvent or NeighborEvent.
+type Event interface {
+	isEvent()
+}
+
+// PacketEvent reports an Ethernet frame received on an interface
+type PacketEvent struct {
+	Iface string
+	Frame []byte
+	// Length is the length of the frame before it was truncated to maxFrameLen (0 if it is not known)
+	Length int
+}
+
+// TickEvent is sent periodically to expire state
+type TickEvent struct{}
+
+// InterfaceEvent reports the current hardware and IP addresses of an interface and whether it forwards IPv6 packets.
+// It must be sent for every interface returned by EngineConfig.Interfaces before any packets,
+// and again whenever the addresses change.
+type InterfaceEvent struct {
+	Iface        string
+	HardwareAddr net.HardwareAddr
+	Addrs        []net.Addr
+	// Forwarding is set if IPv6 forwarding is enabled on the interface, which makes the host a router on its link
+	Forwarding bool
+	// Missing is set if the interface does not exist (anymore). The state of the interface is forgotten
+	// and packets that would be sent on it are dropped until it appears again.
+	Missing bool
+	// Down is set if the interface is not operational (administratively down or without carrier).
+	// Targets behind it are not answered for until it is up again.
+	Down bool
+}
+
+// NeighborEvent reports addresses that are known to be reachable through an interface,
+// such as the entries of the neighbor cache of the kernel when an instance starts
+type NeighborEvent struct {
+	Iface string
+	Addrs []net.IP
+}
+
+func (PacketEvent) isEvent()    {}
+func (TickEvent) isEvent()      {}
+func (InterfaceEvent) isEvent() {}
+func (NeighborEvent) isEvent()  {}
+
+// Action is an output of an Engine. It is one of SendAction, InstallAction or EmitAction.
+type Action interface {
+	isAction()
+}
+
+// SendAction requests an IPv6 packet (including the IPv6 header) to be sent on an interface
+type SendAction struct {
+	Iface string
+	Dst   net.IP
+	// DstMAC is the link-layer address of Dst if it is known from the solicitation being answered.
+	// It is nil for multicast destinations (see LinkLayerDst).
+	DstMAC net.HardwareAddr
+	Packet []byte
+}
+
+// LinkLayerDst returns the Ethernet destination of the packet: DstMAC, or the multicast MAC of a multicast Dst (RFC 2464 7).
+// It returns nil if the link-layer address is not known.
+func (a SendAction) LinkLayerDst() net.HardwareAddr {
+	if a.DstMAC != nil {
+		return a.DstMAC
+	}
+	if len(a.Dst) == 16 && a.Dst.IsMulticast() {
+		return multicastMAC(a.Dst)
+	}
+	return nil
+}
+
+// InstallAction reports that a solicitation was forwarded and that advertisements for
+// Target are going to be answered to AskedBy on Iface until Expires
+type InstallAction struct {
+	Iface   string
+	Target  net.IP
+	AskedBy net.IP
+	Expires time.Time
+}
+
+// EmitAction reports a decision that did not result in a packet being sent
+type EmitAction struct {
+	Iface string
+	// Target is the address the packet is about (if known)
+	Target net.IP
+	// Reason is a short machine-readable identifier of the decision
+	Reason string
+	// Message is a human-readable description of the decision
+	Message string
+}
+
+func (SendAction) isAction()    {}
+func (InstallAction) isAction() {}
+func (EmitAction) isAction()    {}
+
+// Drop reasons used in EmitAction
+const (
+	ReasonMalformed       = "malformed"
+	ReasonOwnPacket       = "own-packet"
+	ReasonNoFlags         = "no-flags"
+	ReasonChecksum        = "checksum"
+	ReasonLinkLocalTarget = "link-local-target"
+	ReasonFilter          = "filter"
+	ReasonPolicy          = "policy"
+	ReasonNotAsked        = "not-asked"
+	ReasonUnknownIface    = "unknown-interface"
+	ReasonIgnored         = "ignored"
+	// ReasonNUDFailed is emitted when a forwarded Neighbor Unreachability Detection probe expires without an answer
+	ReasonNUDFailed = "nud-failed"
+	// ReasonLinkDown is emitted for solicitations that are not answered or forwarded because an interface is down
+	ReasonLinkDown = "link-down"
+
+	// Validation of received messages (RFC 4861 7.1.1 and 7.1.2)
+	ReasonHopLimit           = "hop-limit"
+	ReasonICMPCode           = "icmp-code"
+	ReasonICMPLength         = "icmp-length"
+	ReasonOptionLength       = "option-length"
+	ReasonMulticastTarget    = "multicast-target"
+	ReasonDADDestination     = "dad-destination"
+	ReasonDADSourceLLA       = "dad-source-lladdr"
+	ReasonSolicitedMulticast = "solicited-multicast"
+	// Strict mode only
+	ReasonMissingSourceLLA  = "missing-source-lladdr"
+	ReasonSourceLLAMismatch = "source-lladdr-mismatch"
+)
+
+type engineInterface struct {
+	mac         net.HardwareAddr
+	sourceIP    []byte
+	sourceIPULA []byte
+	networks    []*net.IPNet
+	forwarding  bool
+	down        bool
+}
+
+type question struct {
+	targetIP []byte
+	askedBy  []byte
+	// askerMAC is the link-layer address of askedBy
+	askerMAC []byte
+	expires  time.Time
+	// nud is set for unicast solicitations (Neighbor Unreachability Detection probes)
+	nud bool
+}
+
+// neighbor identifies a target behind an interface
+type neighbor struct {
+	iface  string
+	target string
+}
+
+// Engine is the decision logic of proxy and responder instances as a deterministic state machine.
+// Events (received packets, timer ticks and address changes) go in and the resulting actions come out.
+// An Engine performs no I/O by itself (except for calling the configured policies) and is not safe for concurrent use.
+type Engine struct {
+	config     EngineConfig
+	clock      Clock
+	interfaces map[string]*engineInterface
+	// questions holds the forwarded solicitations per interface they were received on
+	questions map[string][]question
+	// announced holds the time of the last unsolicited advertisement per target
+	announced map[string]time.Time
+	// learned holds the time an address was last seen behind the internal interface
+	learned map[string]time.Time
+	// reachable holds the time a target last answered a forwarded solicitation
+	reachable map[neighbor]time.Time
+}
+
+func NewEngine(config EngineConfig, clock Clock) *Engine {
+	return &Engine{
+		config:     config,
+		clock:      clock,
+		interfaces: make(map[string]*engineInterface),
+		questions:  make(map[string][]question),
+		announced:  make(map[string]time.Time),
+		learned:    make(map[string]time.Time),
+		reachable:  make(map[neighbor]time.Time),
+	}
+}
+
+// Handle processes a single Event and returns the actions that need to be performed in order
+func (e *Engine) Handle(ctx context.Context, event Event) []Action {
+	switch ev := event.(type) {
+	case InterfaceEvent:
+		return e.handleInterface(ctx, ev)
+	case NeighborEvent:
+		if ev.Iface != e.config.Iface2 {
+			return nil
+		}
+		var actions []Action
+		for _, addr := range ev.Addrs {
+			e.learn(addr.To16())
+			actions = append(actions, e.announce(ctx, addr.To16())...)
+		}
+		return actions
+	case TickEvent:
+		e.expireAnnouncements()
+		e.expireLearned()
+		return e.expireQuestions()
+	case PacketEvent:
+		return e.handlePacket(ctx, ev)
+	}
+	return nil
+}
+
+func (e *Engine) handleInterface(ctx context.Context, ev InterfaceEvent) []Action {
+	if ev.Missing {
+		delete(e.interfaces, ev.Iface)
+		return nil
+	}
+	info := &engineInterface{
+		mac:        ev.HardwareAddr,
+		networks:   getInterfaceNetworkList(ev.Addrs),
+		forwarding: ev.Forwarding,
+		down:       ev.Down,
+	}
+	info.sourceIP, info.sourceIPULA = selectSourceIP(ev.Addrs)
+	previous := e.interfaces[ev.Iface]
+	e.interfaces[ev.Iface] = info
+
+	if ev.Iface != e.config.Iface2 || e.config.Type != ProxyInstance {
+		return nil
+	}
+	if info.down {
+		if previous != nil && !previous.down {
+			e.linkDown()
+		}
+		return nil
+	}
+	// Addresses that were added to the internal interface (all of them once it is up again)
+	var actions []Action
+	for _, n := range info.networks {
+		if previous == nil || previous.down || !containsAddress(previous.networks, n.IP) {
+			actions = append(actions, e.announce(ctx, n.IP.To16())...)
+		}
+	}
+	return actions
+}
+
+func containsAddress(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// expireQuestions removes expired solicitations and reports the Neighbor Unreachability Detection probes among them
+func (e *Engine) expireQuestions() []Action {
+	now := e.clock.Now()
+	var actions []Action
+	for _, iface := range slices.Sorted(maps.Keys(e.questions)) {
+		list := e.questions[iface]
+		kept := list[:0]
+		for _, q := range list {
+			if now.Before(q.expires) {
+				kept = append(kept, q)
+			} else if q.nud {
+				actions = append(actions, drop(iface, q.targetIP, ReasonNUDFailed, "No advertisement for the Neighbor Unreachability Detection probe from "+net.IP(q.askedBy).String()))
+			}
+		}
+		e.questions[iface] = kept
+	}
+	for n, last := range e.reachable {
+		if !now.Before(last.Add(reachableTime)) {
+			delete(e.reachable, n)
+		}
+	}
+	return actions
+}
+
+func (e *Engine) handlePacket(ctx context.Context, ev PacketEvent) []Action {
+	info, ok := e.interfaces[ev.Iface]
+	if !ok {
+		return []Action{drop(ev.Iface, nil, ReasonUnknownIface, "Dropping packet received on an interface without known state")}
+	}
+
+	req, reason, message := parseFrame(ev.Iface, ev.Frame, info.mac)
+	if req == nil {
+		return []Action{drop(ev.Iface, nil, reason, message)}
+	}
+
+	v6Header, err := newIpv6Header(req.srcIP, req.dstIP)
+	if err != nil {
+		return []Action{drop(ev.Iface, req.answeringForIP, ReasonMalformed, "Dropping malformed packet")}
+	}
+	if !checkPacketChecksum(v6Header, req.payload) {
+		return []Action{drop(ev.Iface, req.answeringForIP, ReasonChecksum, "Dropping packet with an invalid checksum")}
+	}
+	if reason, message := validateMessage(ev.Frame, req, e.config.Strict); reason != "" {
+		return []Action{drop(ev.Iface, req.answeringForIP, reason, message)}
+	}
+
+	if linkLocalSpace.Contains(req.answeringForIP) {
+		return []Action{drop(ev.Iface, req.answeringForIP, ReasonLinkLocalTarget, "Dropping packet asking for a link-local IP")}
+	}
+
+	if e.config.Type == ResponderInstance {
+		if req.requestType != ndpSol {
+			return []Action{drop(ev.Iface, req.answeringForIP, ReasonIgnored, "Ignoring advertisement")}
+		}
+		if action := e.checkTarget(ctx, req); action != nil {
+			return []Action{action}
+		}
+		if info, ok := e.interfaces[e.config.Autosense]; ok && info.down {
+			return []Action{drop(ev.Iface, req.answeringForIP, ReasonLinkDown, "Dropping solicitation for a target behind an interface that is down")}
+		}
+		dstIP := req.srcIP
+		if bytes.Equal(req.srcIP, emptyIpv6) {
+			// Duplicate Address Detection for an address that is answered for (RFC 4861 7.2.4)
+			dstIP = allNodesLinkLocal
+		}
+		ownIP := req.answeringForIP
+		if e.config.SourceAddress != SourceAuto {
+			ownIP = e.sourceIP(ev.Iface, dstIP, req.answeringForIP)
+		}
+		return []Action{e.send(ev.Iface, ownIP, dstIP, req.srcMAC, req.answeringForIP, ndpAdv)}
+	}
+
+	// Proxy
+	respondIface := e.config.Iface1
+	if ev.Iface == e.config.Iface1 {
+		respondIface = e.config.Iface2
+	}
+	if _, ok := e.interfaces[respondIface]; !ok {
+		return []Action{drop(respondIface, req.answeringForIP, ReasonUnknownIface, "Dropping packet for an interface without known state")}
+	}
+	if e.interfaces[respondIface].down {
+		return []Action{drop(ev.Iface, req.answeringForIP, ReasonLinkDown, "Dropping packet for an interface that is down")}
+	}
+
+	var actions []Action
+	var ownIP []byte
+	dstIP := req.dstIP
+	// The link-layer address of a unicast destination is only known for answers
+	var dstMAC []byte
+	if ev.Iface == e.config.Iface2 {
+		// Both the target of an advertisement and the source of a solicitation are in use behind the internal interface
+		if req.requestType == ndpAdv {
+			e.learn(req.answeringForIP)
+		} else {
+			e.learn(req.srcIP)
+		}
+	}
+	if req.requestType == ndpAdv {
+		if ev.Iface == e.config.Iface2 {
+			// The target is reachable behind the internal interface
+			actions = append(actions, e.announce(ctx, req.answeringForIP)...)
+		}
+		if allNodesLinkLocal.Equal(req.dstIP) {
+			if e.takeDADQuestion(respondIface, req.answeringForIP) {
+				// A node performing Duplicate Address Detection has to learn that the address is in use
+				return append(actions, e.send(respondIface, e.sourceIP(respondIface, allNodesLinkLocal, req.answeringForIP), allNodesLinkLocal, nil, req.answeringForIP, ndpAdv))
+			}
+			if len(actions) != 0 {
+				// Already announced
+				return actions
+			}
+			return []Action{e.relayUnsolicited(ctx, req, respondIface, e.sourceIP(respondIface, allNodesLinkLocal, req.answeringForIP))}
+		}
+		q, success := e.takeQuestion(respondIface, req.answeringForIP)
+		if !success {
+			return append(actions, drop(ev.Iface, req.answeringForIP, ReasonNotAsked, "Nobody has asked for this IP"))
+		}
+		dstIP, dstMAC = q.askedBy, q.askerMAC
+		e.reachable[neighbor{ev.Iface, string(req.answeringForIP)}] = e.clock.Now()
+		if bytes.Equal(dstIP, emptyIpv6) {
+			// Answers to Duplicate Address Detection go to all nodes (RFC 4861 7.2.4)
+			dstIP, dstMAC = allNodesLinkLocal, nil
+		}
+	} else {
+		if respondIface == e.config.Iface2 {
+			if action := e.checkTarget(ctx, req); action != nil {
+				return []Action{action}
+			}
+		}
+		// Neighbor Unreachability Detection probes are sent to the address of the target instead of its solicited-node address
+		nud := !net.IP(req.dstIP).IsMulticast()
+		if bytes.Equal(req.srcIP, emptyIpv6) {
+			// Duplicate Address detection is in progress. The answer is correlated with the question like any other.
+			ownIP = emptyIpv6
+			if ev.Iface == e.config.Iface1 && e.config.Defend && e.inUseBehindProxy(req.answeringForIP) {
+				return []Action{e.send(ev.Iface, e.sourceIP(ev.Iface, allNodesLinkLocal, req.answeringForIP), allNodesLinkLocal, nil, req.answeringForIP, ndpAdv)}
+			}
+		} else if nud && e.isReachable(respondIface, req.answeringForIP) {
+			// The target answered recently, so the probe does not need to be forwarded
+			return []Action{e.send(ev.Iface, e.sourceIP(ev.Iface, req.srcIP, req.answeringForIP), req.srcIP, req.srcMAC, req.answeringForIP, ndpAdv)}
+		}
+		actions = append(actions, e.addQuestion(ev.Iface, req.answeringForIP, req.srcIP, req.srcMAC, nud))
+	}
+	if ownIP == nil {
+		// An address from the interface needs to be used instead of the one from the packet
+		ownIP = e.sourceIP(respondIface, dstIP, req.answeringForIP)
+	}
+	return append(actions, e.send(respondIface, ownIP, dstIP, dstMAC, req.answeringForIP, req.requestType))
+}
+
+// checkTarget applies the filter (or autosense) and the policies. It returns nil if the target is allowed.
+func (e *Engine) checkTarget(ctx context.Context, req *ndpRequest) Action {
+	filter := e.config.Filter
+	if e.config.Autosense != "" {
+		filter = make([]*net.IPNet, 0)
+		if info, ok := e.interfaces[e.config.Autosense]; ok {
+			filter = info.networks
+		}
+	}
+
+	if filter != nil {
+		ok := false
+		for _, i := range filter {
+			if i.Contains(req.answeringForIP) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return drop(req.sourceIface, req.answeringForIP, ReasonFilter, "Dropping packet for an IP that is not whitelisted")
+		}
+	}
+
+	if !evaluatePolicies(ctx, e.config.Policies, req.sourceIface, req.srcIP, req.answeringForIP) {
+		return drop(req.sourceIface, req.answeringForIP, ReasonPolicy, "Dropping packet denied by policy")
+	}
+	return nil
+}
+
+// send builds a solicitation or advertisement for ndpTargetIP. dstMAC is the link-layer address of a unicast dstIP (if known).
+func (e *Engine) send(iface string, ownIP []byte, dstIP []byte, dstMAC []byte, ndpTargetIP []byte, packetType ndpType) Action {
+	var packet []byte
+	var err error
+	if packetType == ndpAdv {
+		// Answers to a multicast destination are not solicited (RFC 4861 7.2.4)
+		flags := e.advertisementFlags(iface, !net.IP(dstIP).IsMulticast())
+		packet, err = buildNDPAdvertisement(ownIP, dstIP, ndpTargetIP, e.advertisedMAC(iface), flags)
+	} else {
+		packet, err = buildNDPPacket(ownIP, dstIP, ndpTargetIP, e.interfaces[iface].mac, packetType)
+	}
+	if err != nil {
+		return drop(iface, ndpTargetIP, ReasonMalformed, "Unable to construct packet: "+err.Error())
+	}
+	action := SendAction{
+		Iface:  iface,
+		Dst:    dstIP,
+		Packet: packet,
+	}
+	if len(dstMAC) == 6 && !net.IP(dstIP).IsMulticast() {
+		action.DstMAC = dstMAC
+	}
+	return action
+}
+
+// advertisedMAC returns the link-layer address that advertisements sent on iface carry
+func (e *Engine) advertisedMAC(iface string) net.HardwareAddr {
+	if iface == e.config.Iface1 {
+		if e.config.AdvertiseMAC != nil {
+			return e.config.AdvertiseMAC
+		}
+		if info, ok := e.interfaces[e.config.AdvertiseIface]; ok && e.config.AdvertiseIface != "" {
+			return info.mac
+		}
+	}
+	return e.interfaces[iface].mac
+}
+
+// advertisementFlags returns the flags of answers sent on iface
+func (e *Engine) advertisementFlags(iface string, solicited bool) naFlags {
+	flags := naFlags{solicited: solicited, override: !e.config.NoOverride}
+	switch e.config.Router {
+	case RouterFlagAuto:
+		flags.router = e.interfaces[iface].forwarding
+	case RouterFlagOn:
+		flags.router = true
+	}
+	return flags
+}
+
+// addQuestion records a solicitation received on iface, so that the advertisement can be sent back to the asker
+func (e *Engine) addQuestion(iface string, targetIP []byte, askedBy []byte, askerMAC []byte, nud bool) Action {
+	q := question{
+		targetIP: targetIP,
+		askedBy:  askedBy,
+		askerMAC: askerMAC,
+		expires:  e.clock.Now().Add(questionLifetime),
+		nud:      nud,
+	}
+	list := append(e.questions[iface], q)
+	if toRemove := len(list) - maxQuestions; toRemove > 0 {
+		list = list[toRemove:]
+	}
+	e.questions[iface] = list
+	return InstallAction{
+		Iface:   iface,
+		Target:  targetIP,
+		AskedBy: askedBy,
+		Expires: q.expires,
+	}
+}
+
+// takeQuestion removes the oldest solicitation for targetIP that was received on iface and returns it
+func (e *Engine) takeQuestion(iface string, targetIP []byte) (question, bool) {
+	list := e.questions[iface]
+	for i := range list {
+		if bytes.Equal(list[i].targetIP, targetIP) {
+			result := list[i]
+			// Remove while keeping the order
+			e.questions[iface] = append(list[:i], list[i+1:]...)
+			return result, true
+		}
+	}
+	return question{}, false
+}
+
+// takeDADQuestion removes the oldest solicitation for targetIP from the unspecified address that was received on iface.
+// It returns false if there is none.
+func (e *Engine) takeDADQuestion(iface string, targetIP []byte) bool {
+	list := e.questions[iface]
+	for i := range list {
+		if bytes.Equal(list[i].targetIP, targetIP) && bytes.Equal(list[i].askedBy, emptyIpv6) {
+			e.questions[iface] = append(list[:i], list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// isReachable returns whether target answered a forwarded solicitation on iface within reachableTime
+func (e *Engine) isReachable(iface string, target []byte) bool {
+	_, ok := e.reachable[neighbor{iface, string(target)}]
+	return ok
+}
+
+// sourceIP returns the address that packets about target are sent from on iface to dst (see EngineConfig.SourceAddress)
+func (e *Engine) sourceIP(iface string, dst []byte, target []byte) []byte {
+	info := e.interfaces[iface]
+	var result []byte
+	switch e.config.SourceAddress {
+	case SourceLinkLocal:
+		result = selectLinkLocal(info.networks)
+	case SourceMatchingPrefix:
+		result = selectMatchingPrefix(info.networks, dst, target)
+	case SourceFixed:
+		result = e.config.SourceIP.To16()
+	case SourceRFC6724:
+		result = selectRFC6724(info.networks, dst)
+	}
+	if result != nil {
+		return result
+	}
+	if ulaSpace.Contains(target) {
+		return info.sourceIPULA
+	}
+	return info.sourceIP
+}
+
+// learn records that ip is in use behind the internal interface
+func (e *Engine) learn(ip []byte) {
+	if len(ip) != 16 || bytes.Equal(ip, emptyIpv6) || linkLocalSpace.Contains(ip) {
+		return
+	}
+	e.learned[string(ip)] = e.clock.Now()
+}
+
+// inUseBehindProxy returns whether target is assigned to or has recently been seen behind the internal interface
+func (e *Engine) inUseBehindProxy(target []byte) bool {
+	if info, ok := e.interfaces[e.config.Iface2]; ok && containsAddress(info.networks, target) {
+		return true
+	}
+	_, ok := e.learned[string(target)]
+	return ok
+}
+
+func (e *Engine) expireLearned() {
+	now := e.clock.Now()
+	for ip, last := range e.learned {
+		if !now.Before(last.Add(learnedLifetime)) {
+			delete(e.learned, ip)
+		}
+	}
+}
+
+// relayUnsolicited forwards an unsolicited advertisement to all nodes on iface. The override and router flags of the
+// original are kept. The filter and policies apply to advertisements received on the internal interface.
+func (e *Engine) relayUnsolicited(ctx context.Context, req *ndpRequest, iface string, ownIP []byte) Action {
+	if req.sourceIface == e.config.Iface2 {
+		if action := e.checkTarget(ctx, req); action != nil {
+			return action
+		}
+	}
+	flags := naFlags{router: req.payload[4]&0x80 != 0, override: req.payload[4]&0x20 != 0}
+	packet, err := buildNDPAdvertisement(ownIP, allNodesLinkLocal, req.answeringForIP, e.advertisedMAC(iface), flags)
+	if err != nil {
+		return drop(iface, req.answeringForIP, ReasonMalformed, "Unable to construct packet: "+err.Error())
+	}
+	return SendAction{
+		Iface:  iface,
+		Dst:    allNodesLinkLocal,
+		Packet: packet,
+	}
+}
+
+// announce returns an unsolicited advertisement for target on the external interface of a proxy with Announce enabled.
+// Nothing is returned for targets that are not allowed or that have been announced recently.
+func (e *Engine) announce(ctx context.Context, target []byte) []Action {
+	if e.config.Type != ProxyInstance || !e.config.Announce || len(target) != 16 || linkLocalSpace.Contains(target) {
+		return nil
+	}
+	if info, ok := e.interfaces[e.config.Iface1]; !ok || info.down {
+		return nil
+	}
+	if info, ok := e.interfaces[e.config.Iface2]; ok && info.down {
+		return nil
+	}
+	if last, ok := e.announced[string(target)]; ok && e.clock.Now().Before(last.Add(announceHoldoff)) {
+		return nil
+	}
+	req := &ndpRequest{requestType: ndpAdv, srcIP: emptyIpv6, answeringForIP: target, sourceIface: e.config.Iface2}
+	if e.checkTarget(ctx, req) != nil {
+		return nil
+	}
+	e.announced[string(target)] = e.clock.Now()
+
+	ownIP := e.sourceIP(e.config.Iface1, allNodesLinkLocal, target)
+	// Announcements always override the cache entries of the neighbors
+	flags := e.advertisementFlags(e.config.Iface1, false)
+	flags.override = true
+	packet, err := buildNDPAdvertisement(ownIP, allNodesLinkLocal, target, e.advertisedMAC(e.config.Iface1), flags)
+	if err != nil {
+		return []Action{drop(e.config.Iface1, target, ReasonMalformed, "Unable to construct packet: "+err.Error())}
+	}
+	return []Action{SendAction{
+		Iface:  e.config.Iface1,
+		Dst:    allNodesLinkLocal,
+		Packet: packet,
+	}}
+}
+
+// linkDown forgets what is reachable behind Iface2, which has gone down. Nothing is advertised: the cache entries of the
+// neighbors expire through Neighbor Unreachability Detection, which is not answered while Iface2 is down.
+func (e *Engine) linkDown() {
+	for n := range e.reachable {
+		if n.iface == e.config.Iface2 {
+			delete(e.reachable, n)
+		}
+	}
+}
+
+func (e *Engine) expireAnnouncements() {
+	now := e.clock.Now()
+	for target, last := range e.announced {
+		if !now.Before(last.Add(announceHoldoff)) {
+			delete(e.announced, target)
+		}
+	}
+}
+
+func drop(iface string, target []byte, reason string, message string) EmitAction {
+	return EmitAction{
+		Iface:   iface,
+		Target:  target,
+		Reason:  reason,
+		Message: message,
+	}
+}
@@ -0,0 +1,602 @@
+package pndp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"pndpd/pndp/ndp"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+type denyPolicy struct{}
+
+func (denyPolicy) Allow(context.Context, string, net.IP, net.IP) Decision { return PolicyDeny }
+
+var (
+	testExtMAC   = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	testIntMAC   = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+	testHostMAC  = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xaa}
+	testAsker    = net.ParseIP("fd00::5")
+	testTarget   = net.ParseIP("fd01::99")
+	testSolNode  = net.ParseIP("ff02::1:ff00:99")
+	testUnspec   = net.ParseIP("::")
+	testInterval = questionLifetime + time.Second
+)
+
+// step is a single input of an engine test. If advance is set, the clock is moved forward and a TickEvent is sent.
+type step struct {
+	iface   string
+	frame   func(t *testing.T) []byte
+	advance time.Duration
+}
+
+func ns(src net.IP, dst net.IP, target net.IP) func(t *testing.T) []byte {
+	return func(t *testing.T) []byte { return buildTestFrame(t, testHostMAC, src, dst, target, ndpSol) }
+}
+
+func na(src net.IP, dst net.IP, target net.IP) func(t *testing.T) []byte {
+	return func(t *testing.T) []byte { return buildTestFrame(t, testHostMAC, src, dst, target, ndpAdv) }
+}
+
+// message returns a frame from testHostMAC carrying an arbitrary Neighbor Discovery message
+func message(src net.IP, dst net.IP, m ndp.Message) func(t *testing.T) []byte {
+	return func(t *testing.T) []byte {
+		packet, err := (&ndp.Packet{Source: src, Destination: dst, HopLimit: 255, Message: m}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := append([]byte{0x33, 0x33, 0, 0, 0, 0x01}, testHostMAC...)
+		return append(append(frame, 0x86, 0xdd), packet...)
+	}
+}
+
+// unsolicitedNA returns an unsolicited advertisement with the override flag to all nodes
+func unsolicitedNA(target net.IP) func(t *testing.T) []byte {
+	return message(target, allNodesLinkLocal, &ndp.NeighborAdvertisement{Override: true, TargetAddress: target,
+		Options: []ndp.Option{&ndp.LinkLayerAddress{Target: true, Addr: testHostMAC}}})
+}
+
+// modify changes a frame and updates the checksum
+func modify(f func(t *testing.T) []byte, change func(frame []byte)) func(t *testing.T) []byte {
+	return func(t *testing.T) []byte {
+		frame := f(t)
+		change(frame)
+		frame[56], frame[57] = 0, 0
+		binary.BigEndian.PutUint16(frame[56:], ndp.Checksum(frame[22:38], frame[38:54], frame[54:]))
+		return frame
+	}
+}
+
+func TestEngine(t *testing.T) {
+	proxyConfig := EngineConfig{Type: ProxyInstance, Iface1: "ext", Iface2: "int", Autosense: "int"}
+	responderConfig := EngineConfig{Type: ResponderInstance, Iface1: "ext", Filter: ParseFilter("fd01::/64")}
+	strictConfig := EngineConfig{Type: ResponderInstance, Iface1: "ext", Filter: ParseFilter("fd01::/64"), Strict: true}
+	defendConfig := EngineConfig{Type: ProxyInstance, Iface1: "ext", Iface2: "int", Autosense: "int", Defend: true}
+	dad := message(testUnspec, testSolNode, &ndp.NeighborSolicitation{TargetAddress: testTarget})
+
+	type testCase struct {
+		name   string
+		config EngineConfig
+		steps  []step
+		// want are the actions of the last step
+		want []string
+	}
+
+	cases := []testCase{
+		{"forward solicitation", proxyConfig,
+			[]step{{iface: "ext", frame: ns(testAsker, testSolNode, testTarget)}},
+			[]string{"install ext fd01::99 asked by fd00::5", "send int ns fd01::1 -> ff02::1:ff00:99 for fd01::99"}},
+		{"forward duplicate address detection", proxyConfig,
+			[]step{{iface: "ext", frame: dad}},
+			[]string{"install ext fd01::99 asked by ::", "send int ns :: -> ff02::1:ff00:99 for fd01::99"}},
+		{"address in use behind the proxy", proxyConfig,
+			[]step{
+				{iface: "ext", frame: dad},
+				{iface: "int", frame: unsolicitedNA(testTarget)},
+			},
+			[]string{"send ext na fd00::1 -> ff02::1 for fd01::99"}},
+		{"address in use on the external side", proxyConfig,
+			[]step{
+				{iface: "int", frame: message(testUnspec, net.ParseIP("ff02::1:ff00:5"), &ndp.NeighborSolicitation{TargetAddress: testAsker})},
+				{iface: "ext", frame: unsolicitedNA(testAsker)},
+			},
+			[]string{"send int na fd01::1 -> ff02::1 for fd00::5"}},
+		{"duplicate address detection answered with a unicast advertisement", proxyConfig,
+			[]step{
+				{iface: "int", frame: message(testUnspec, net.ParseIP("ff02::1:ff00:5"), &ndp.NeighborSolicitation{TargetAddress: testAsker})},
+				{iface: "ext", frame: na(testAsker, net.ParseIP("fd00::1"), testAsker)},
+			},
+			[]string{"send int na fd01::1 -> ff02::1 for fd00::5"}},
+		{"defend", defendConfig,
+			[]step{
+				{iface: "int", frame: ns(testTarget, net.ParseIP("ff02::1:ff00:1"), net.ParseIP("fd01::1"))},
+				{iface: "ext", frame: dad},
+			},
+			[]string{"send ext na fd00::1 -> ff02::1 for fd01::99"}},
+		{"defend address of the internal interface", defendConfig,
+			[]step{{iface: "ext", frame: message(testUnspec, net.ParseIP("ff02::1:ff00:1"), &ndp.NeighborSolicitation{TargetAddress: net.ParseIP("fd01::1")})}},
+			[]string{"send ext na fd00::1 -> ff02::1 for fd01::1"}},
+		{"defend forwards unknown addresses", defendConfig,
+			[]step{{iface: "ext", frame: dad}},
+			[]string{"install ext fd01::99 asked by ::", "send int ns :: -> ff02::1:ff00:99 for fd01::99"}},
+		{"defend forgets addresses", defendConfig,
+			[]step{
+				{iface: "int", frame: unsolicitedNA(testTarget)},
+				{advance: learnedLifetime},
+				{iface: "ext", frame: dad},
+			},
+			[]string{"install ext fd01::99 asked by ::", "send int ns :: -> ff02::1:ff00:99 for fd01::99"}},
+		{"responder defends", responderConfig,
+			[]step{{iface: "ext", frame: dad}},
+			[]string{"send ext na fd01::99 -> ff02::1 for fd01::99"}},
+		{"filter", proxyConfig,
+			[]step{{iface: "ext", frame: ns(testAsker, testSolNode, net.ParseIP("fd02::1"))}},
+			[]string{"emit ext filter"}},
+		{"link-local target", proxyConfig,
+			[]step{{iface: "ext", frame: ns(testAsker, testSolNode, net.ParseIP("fe80::1"))}},
+			[]string{"emit ext link-local-target"}},
+		{"policy", EngineConfig{Type: ProxyInstance, Iface1: "ext", Iface2: "int", Policies: []TargetPolicy{denyPolicy{}}},
+			[]step{{iface: "ext", frame: ns(testAsker, testSolNode, testTarget)}},
+			[]string{"emit ext policy"}},
+		{"answer forwarded solicitation", proxyConfig,
+			[]step{
+				{iface: "ext", frame: ns(testAsker, testSolNode, testTarget)},
+				{iface: "int", frame: na(testTarget, net.ParseIP("fd01::1"), testTarget)},
+			},
+			[]string{"send ext na fd00::1 -> fd00::5 for fd01::99"}},
+		{"advertisement without solicitation", proxyConfig,
+			[]step{{iface: "int", frame: na(testTarget, net.ParseIP("fd01::1"), testTarget)}},
+			[]string{"emit int not-asked"}},
+		{"expired solicitation", proxyConfig,
+			[]step{
+				{iface: "ext", frame: ns(testAsker, testSolNode, testTarget)},
+				{advance: testInterval},
+				{iface: "int", frame: na(testTarget, net.ParseIP("fd01::1"), testTarget)},
+			},
+			[]string{"emit int not-asked"}},
+		{"relay unsolicited advertisement", proxyConfig,
+			[]step{{iface: "int", frame: unsolicitedNA(testTarget)}},
+			[]string{"send ext na fd00::1 -> ff02::1 for fd01::99"}},
+		{"unsolicited advertisement filter", proxyConfig,
+			[]step{{iface: "int", frame: unsolicitedNA(net.ParseIP("fd02::1"))}},
+			[]string{"emit int filter"}},
+		{"relay unsolicited advertisement from the external interface", proxyConfig,
+			[]step{{iface: "ext", frame: unsolicitedNA(testAsker)}},
+			[]string{"send int na fd01::1 -> ff02::1 for fd00::5"}},
+		{"forward unreachability probe", proxyConfig,
+			[]step{{iface: "ext", frame: ns(testAsker, testTarget, testTarget)}},
+			[]string{"install ext fd01::99 asked by fd00::5", "send int ns fd01::1 -> fd01::99 for fd01::99"}},
+		{"answer unreachability probe for a reachable target", proxyConfig,
+			[]step{
+				{iface: "ext", frame: ns(testAsker, testSolNode, testTarget)},
+				{iface: "int", frame: na(testTarget, net.ParseIP("fd01::1"), testTarget)},
+				{iface: "ext", frame: ns(testAsker, testTarget, testTarget)},
+			},
+			[]string{"send ext na fd00::1 -> fd00::5 for fd01::99"}},
+		{"forward unreachability probe once the target is no longer reachable", proxyConfig,
+			[]step{
+				{iface: "ext", frame: ns(testAsker, testSolNode, testTarget)},
+				{iface: "int", frame: na(testTarget, net.ParseIP("fd01::1"), testTarget)},
+				{advance: reachableTime},
+				{iface: "ext", frame: ns(testAsker, testTarget, testTarget)},
+			},
+			[]string{"install ext fd01::99 asked by fd00::5", "send int ns fd01::1 -> fd01::99 for fd01::99"}},
+		{"unanswered unreachability probe", proxyConfig,
+			[]step{
+				{iface: "ext", frame: ns(testAsker, testTarget, testTarget)},
+				{advance: testInterval},
+			},
+			[]string{"emit ext nud-failed"}},
+		{"unanswered multicast solicitation", proxyConfig,
+			[]step{
+				{iface: "ext", frame: ns(testAsker, testSolNode, testTarget)},
+				{advance: testInterval},
+			},
+			[]string{}},
+		{"solicitation from the internal interface is not filtered", proxyConfig,
+			[]step{{iface: "int", frame: ns(testTarget, testSolNode, net.ParseIP("fd02::1"))}},
+			[]string{"install int fd02::1 asked by fd01::99", "send ext ns fd00::1 -> ff02::1:ff00:99 for fd02::1"}},
+		{"responder answers", responderConfig,
+			[]step{{iface: "ext", frame: ns(testAsker, testSolNode, testTarget)}},
+			[]string{"send ext na fd01::99 -> fd00::5 for fd01::99"}},
+		{"responder filter", responderConfig,
+			[]step{{iface: "ext", frame: ns(testAsker, testSolNode, net.ParseIP("fd02::1"))}},
+			[]string{"emit ext filter"}},
+		{"own packet", responderConfig,
+			[]step{{iface: "ext", frame: func(t *testing.T) []byte {
+				return buildTestFrame(t, testExtMAC, testAsker, testSolNode, testTarget, ndpSol)
+			}}},
+			[]string{"emit ext own-packet"}},
+		{"invalid checksum", responderConfig,
+			[]step{{iface: "ext", frame: func(t *testing.T) []byte {
+				frame := buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol)
+				frame[56] ^= 0xff
+				return frame
+			}}},
+			[]string{"emit ext checksum"}},
+		{"truncated packet", responderConfig,
+			[]step{{iface: "ext", frame: func(t *testing.T) []byte {
+				return buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol)[:70]
+			}}},
+			[]string{"emit ext malformed"}},
+		{"hop limit", responderConfig,
+			[]step{{iface: "ext", frame: modify(ns(testAsker, testSolNode, testTarget), func(frame []byte) { frame[21] = 254 })}},
+			[]string{"emit ext hop-limit"}},
+		{"icmp code", responderConfig,
+			[]step{{iface: "ext", frame: modify(ns(testAsker, testSolNode, testTarget), func(frame []byte) { frame[55] = 1 })}},
+			[]string{"emit ext icmp-code"}},
+		{"truncated solicitation", responderConfig,
+			[]step{{iface: "ext", frame: modify(ns(testAsker, testSolNode, testTarget), func(frame []byte) { frame[18], frame[19] = 0, 16 })}},
+			[]string{"emit ext icmp-length"}},
+		{"truncated advertisement", proxyConfig,
+			[]step{{iface: "int", frame: modify(na(testTarget, testAsker, testTarget), func(frame []byte) { frame[18], frame[19] = 0, 23 })}},
+			[]string{"emit int icmp-length"}},
+		{"option length", responderConfig,
+			[]step{{iface: "ext", frame: modify(ns(testAsker, testSolNode, testTarget), func(frame []byte) { frame[79] = 0 })}},
+			[]string{"emit ext option-length"}},
+		{"multicast target", responderConfig,
+			[]step{{iface: "ext", frame: ns(testAsker, testSolNode, net.ParseIP("ff0e::99"))}},
+			[]string{"emit ext multicast-target"}},
+		{"duplicate address detection to a unicast address", responderConfig,
+			[]step{{iface: "ext", frame: message(testUnspec, testTarget, &ndp.NeighborSolicitation{TargetAddress: testTarget})}},
+			[]string{"emit ext dad-destination"}},
+		{"duplicate address detection with a source link-layer address", proxyConfig,
+			[]step{{iface: "ext", frame: message(testUnspec, testSolNode, &ndp.NeighborSolicitation{TargetAddress: testTarget,
+				Options: []ndp.Option{&ndp.LinkLayerAddress{Addr: testHostMAC}}})}},
+			[]string{"emit ext dad-source-lladdr"}},
+		{"solicited advertisement to all nodes", proxyConfig,
+			[]step{{iface: "int", frame: na(testTarget, allNodesLinkLocal, testTarget)}},
+			[]string{"emit int solicited-multicast"}},
+		{"strict mode answers", strictConfig,
+			[]step{{iface: "ext", frame: ns(testAsker, testSolNode, testTarget)}},
+			[]string{"send ext na fd01::99 -> fd00::5 for fd01::99"}},
+		{"missing source link-layer address", responderConfig,
+			[]step{{iface: "ext", frame: message(testAsker, testSolNode, &ndp.NeighborSolicitation{TargetAddress: testTarget})}},
+			[]string{"send ext na fd01::99 -> fd00::5 for fd01::99"}},
+		{"strict mode missing source link-layer address", strictConfig,
+			[]step{{iface: "ext", frame: message(testAsker, testSolNode, &ndp.NeighborSolicitation{TargetAddress: testTarget})}},
+			[]string{"emit ext missing-source-lladdr"}},
+		{"strict mode source link-layer address mismatch", strictConfig,
+			[]step{{iface: "ext", frame: modify(ns(testAsker, testSolNode, testTarget), func(frame []byte) { frame[85] = 0xbb })}},
+			[]string{"emit ext source-lladdr-mismatch"}},
+		{"unknown interface", responderConfig,
+			[]step{{iface: "other", frame: ns(testAsker, testSolNode, testTarget)}},
+			[]string{"emit other unknown-interface"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(0, 0)}
+			engine := NewEngine(tc.config, clock)
+			engine.Handle(context.Background(), InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd00::1/64")}})
+			engine.Handle(context.Background(), InterfaceEvent{Iface: "int", HardwareAddr: testIntMAC, Addrs: []net.Addr{mustParseIfaceIP("fd01::1/64")}})
+
+			var actions []Action
+			for _, s := range tc.steps {
+				if s.advance != 0 {
+					clock.now = clock.now.Add(s.advance)
+					actions = engine.Handle(context.Background(), TickEvent{})
+					continue
+				}
+				actions = engine.Handle(context.Background(), PacketEvent{Iface: s.iface, Frame: s.frame(t)})
+			}
+
+			got := make([]string, 0)
+			for _, action := range actions {
+				got = append(got, describeAction(action))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected %q, but got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEngineAutosenseUpdate(t *testing.T) {
+	engine := NewEngine(EngineConfig{Type: ResponderInstance, Iface1: "ext", Autosense: "ext"}, &fakeClock{})
+	engine.Handle(context.Background(), InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd00::1/64")}})
+
+	actions := engine.Handle(context.Background(), PacketEvent{Iface: "ext", Frame: buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol)})
+	if len(actions) != 1 || describeAction(actions[0]) != "emit ext filter" {
+		t.Errorf("Expected the target to be filtered, but got %v", actions)
+	}
+
+	engine.Handle(context.Background(), InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd01::1/64")}})
+	actions = engine.Handle(context.Background(), PacketEvent{Iface: "ext", Frame: buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol)})
+	if len(actions) != 1 || describeAction(actions[0]) != "send ext na fd01::99 -> fd00::5 for fd01::99" {
+		t.Errorf("Expected an answer after the address change, but got %v", actions)
+	}
+}
+
+func describeAction(action Action) string {
+	switch a := action.(type) {
+	case SendAction:
+		packetType := "na"
+		if a.Packet[40] == 0x87 {
+			packetType = "ns"
+		}
+		return fmt.Sprintf("send %s %s %s -> %s for %s", a.Iface, packetType, net.IP(a.Packet[8:24]), a.Dst, net.IP(a.Packet[48:64]))
+	case InstallAction:
+		return fmt.Sprintf("install %s %s asked by %s", a.Iface, a.Target, a.AskedBy)
+	case EmitAction:
+		return fmt.Sprintf("emit %s %s", a.Iface, a.Reason)
+	}
+	return "unknown"
+}
+
+func TestEngineUnsolicitedFlags(t *testing.T) {
+	engine := NewEngine(EngineConfig{Type: ProxyInstance, Iface1: "ext", Iface2: "int", Filter: ParseFilter("fd01::/64")}, &fakeClock{now: time.Unix(0, 0)})
+	engine.Handle(context.Background(), InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd00::1/64")}})
+	engine.Handle(context.Background(), InterfaceEvent{Iface: "int", HardwareAddr: testIntMAC, Addrs: []net.Addr{mustParseIfaceIP("fd01::1/64")}})
+
+	for _, tc := range []struct {
+		name     string
+		original naFlags
+		want     byte
+	}{
+		{"router and override flags are kept", naFlags{router: true, override: true}, 0xa0},
+		{"override flag is kept", naFlags{override: true}, 0x20},
+		{"router flag is kept", naFlags{router: true}, 0x80},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			packet, err := buildNDPAdvertisement(testTarget.To16(), allNodesLinkLocal, testTarget.To16(), testHostMAC, tc.original)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame := append([]byte{0x33, 0x33, 0, 0, 0, 0x01}, testHostMAC...)
+			frame = append(append(frame, 0x86, 0xdd), packet...)
+
+			actions := engine.Handle(context.Background(), PacketEvent{Iface: "int", Frame: frame})
+			if len(actions) != 1 {
+				t.Fatalf("Expected one action, but got %d", len(actions))
+			}
+			send, ok := actions[0].(SendAction)
+			if !ok {
+				t.Fatalf("Expected a SendAction, but got %s", describeAction(actions[0]))
+			}
+			if send.Packet[44] != tc.want {
+				t.Errorf("Expected flags %x, but got %x", tc.want, send.Packet[44])
+			}
+			if !bytes.Equal(send.Packet[66:72], testExtMAC) {
+				t.Errorf("Expected the hardware address of ext, but got %s", net.HardwareAddr(send.Packet[66:72]))
+			}
+		})
+	}
+}
+
+func TestEngineAdvertisementFlags(t *testing.T) {
+	proxyConfig := EngineConfig{Type: ProxyInstance, Iface1: "ext", Iface2: "int", Autosense: "int"}
+	responderConfig := EngineConfig{Type: ResponderInstance, Iface1: "ext", Filter: ParseFilter("fd01::/64")}
+	withFlags := func(config EngineConfig, router RouterFlag, noOverride bool) EngineConfig {
+		config.Router = router
+		config.NoOverride = noOverride
+		return config
+	}
+
+	for _, tc := range []struct {
+		name       string
+		config     EngineConfig
+		forwarding bool
+		want       byte
+	}{
+		{"proxy without forwarding", proxyConfig, false, 0x60},
+		{"proxy with forwarding", proxyConfig, true, 0xe0},
+		{"router flag off", withFlags(proxyConfig, RouterFlagOff, false), true, 0x60},
+		{"router flag on", withFlags(responderConfig, RouterFlagOn, false), false, 0xe0},
+		{"override off", withFlags(responderConfig, RouterFlagAuto, true), false, 0x40},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := NewEngine(tc.config, &fakeClock{now: time.Unix(0, 0)})
+			engine.Handle(context.Background(), InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd00::1/64")}, Forwarding: tc.forwarding})
+			engine.Handle(context.Background(), InterfaceEvent{Iface: "int", HardwareAddr: testIntMAC, Addrs: []net.Addr{mustParseIfaceIP("fd01::1/64")}, Forwarding: tc.forwarding})
+
+			actions := engine.Handle(context.Background(), PacketEvent{Iface: "ext", Frame: ns(testAsker, testSolNode, testTarget)(t)})
+			if tc.config.Type == ProxyInstance {
+				actions = engine.Handle(context.Background(), PacketEvent{Iface: "int", Frame: na(testTarget, net.ParseIP("fd01::1"), testTarget)(t)})
+			}
+			send, ok := actions[len(actions)-1].(SendAction)
+			if !ok || send.Packet[40] != 0x88 {
+				t.Fatalf("Expected an advertisement, but got %s", describeAction(actions[len(actions)-1]))
+			}
+			if send.Packet[44] != tc.want {
+				t.Errorf("Expected flags %x, but got %x", tc.want, send.Packet[44])
+			}
+		})
+	}
+}
+
+func TestEngineLinkLayerDestination(t *testing.T) {
+	optionMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xcc}
+	withOption := message(testAsker, testSolNode, &ndp.NeighborSolicitation{TargetAddress: testTarget,
+		Options: []ndp.Option{&ndp.LinkLayerAddress{Addr: optionMAC}}})
+	probe := message(testAsker, testTarget, &ndp.NeighborSolicitation{TargetAddress: testTarget})
+
+	for _, tc := range []struct {
+		name   string
+		config EngineConfig
+		frame  func(t *testing.T) []byte
+		want   net.HardwareAddr
+	}{
+		{"responder answers the source link-layer address", EngineConfig{Type: ResponderInstance, Iface1: "ext"}, withOption, optionMAC},
+		{"responder answers the Ethernet source without the option", EngineConfig{Type: ResponderInstance, Iface1: "ext"}, probe, testHostMAC},
+		{"proxy answers the source link-layer address", EngineConfig{Type: ProxyInstance, Iface1: "ext", Iface2: "int"}, withOption, optionMAC},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := NewEngine(tc.config, &fakeClock{now: time.Unix(0, 0)})
+			engine.Handle(context.Background(), InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd00::1/64")}})
+			engine.Handle(context.Background(), InterfaceEvent{Iface: "int", HardwareAddr: testIntMAC, Addrs: []net.Addr{mustParseIfaceIP("fd01::1/64")}})
+
+			actions := engine.Handle(context.Background(), PacketEvent{Iface: "ext", Frame: tc.frame(t)})
+			if tc.config.Type == ProxyInstance {
+				// The forwarded solicitation goes to the multicast MAC of the solicited-node address
+				forwarded := actions[len(actions)-1].(SendAction)
+				if want := (net.HardwareAddr{0x33, 0x33, 0xff, 0, 0, 0x99}); forwarded.DstMAC != nil || !bytes.Equal(forwarded.LinkLayerDst(), want) {
+					t.Errorf("Expected the solicitation to be sent to %s, but got %v/%s", want, forwarded.DstMAC, forwarded.LinkLayerDst())
+				}
+				actions = engine.Handle(context.Background(), PacketEvent{Iface: "int", Frame: na(testTarget, net.ParseIP("fd01::1"), testTarget)(t)})
+			}
+			send, ok := actions[len(actions)-1].(SendAction)
+			if !ok || send.Packet[40] != 0x88 {
+				t.Fatalf("Expected an advertisement, but got %s", describeAction(actions[len(actions)-1]))
+			}
+			if !bytes.Equal(send.DstMAC, tc.want) || !bytes.Equal(send.LinkLayerDst(), tc.want) {
+				t.Errorf("Expected the answer to be sent to %s, but got %s", tc.want, send.DstMAC)
+			}
+		})
+	}
+}
+
+func TestEngineLinkDown(t *testing.T) {
+	ctx := context.Background()
+	engine := NewEngine(EngineConfig{Type: ProxyInstance, Iface1: "ext", Iface2: "int", Filter: ParseFilter("fd01::/64")}, &fakeClock{now: time.Unix(0, 0)})
+	intEvent := func(down bool) InterfaceEvent {
+		return InterfaceEvent{Iface: "int", HardwareAddr: testIntMAC, Addrs: []net.Addr{mustParseIfaceIP("fd01::1/64"), mustParseIfaceIP("fe80::1/64")}, Down: down}
+	}
+
+	steps := []struct {
+		name  string
+		event Event
+		want  []string
+	}{
+		{"external interface", InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd00::1/64")}}, []string{}},
+		{"internal interface", intEvent(false), []string{}},
+		{"solicitation from behind the internal interface", PacketEvent{Iface: "int", Frame: ns(net.ParseIP("fd01::98"), testSolNode, testTarget)(t)}, []string{"install int fd01::99 asked by fd01::98", "send ext ns fd00::1 -> ff02::1:ff00:99 for fd01::99"}},
+		// Nothing is advertised, the neighbors' cache entries expire as NUD is not answered
+		{"link down", intEvent(true), []string{}},
+		{"still down", intEvent(true), []string{}},
+		{"solicitation while down", PacketEvent{Iface: "ext", Frame: ns(testAsker, testSolNode, testTarget)(t)}, []string{"emit ext link-down"}},
+		{"link up", intEvent(false), []string{}},
+		{"solicitation after the link is up", PacketEvent{Iface: "ext", Frame: ns(testAsker, testSolNode, testTarget)(t)}, []string{"install ext fd01::99 asked by fd00::5", "send int ns fd01::1 -> ff02::1:ff00:99 for fd01::99"}},
+	}
+	for _, s := range steps {
+		actions := engine.Handle(ctx, s.event)
+		got := []string{}
+		for _, action := range actions {
+			got = append(got, describeAction(action))
+		}
+		if !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: expected %v, but got %v", s.name, s.want, got)
+		}
+	}
+}
+
+func TestEngineAdvertiseMAC(t *testing.T) {
+	virtualMAC := net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x01, 0x01}
+	config := EngineConfig{Type: ResponderInstance, Iface1: "ext", Filter: ParseFilter("fd01::/64"), AdvertiseMAC: virtualMAC}
+	engine := NewEngine(config, &fakeClock{now: time.Unix(0, 0)})
+	engine.Handle(context.Background(), InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd00::1/64")}})
+
+	for _, frame := range []func(t *testing.T) []byte{ns(testAsker, testSolNode, testTarget), ns(testUnspec, testSolNode, testTarget)} {
+		actions := engine.Handle(context.Background(), PacketEvent{Iface: "ext", Frame: frame(t)})
+		send, ok := actions[0].(SendAction)
+		if !ok {
+			t.Fatalf("Expected an advertisement, but got %s", describeAction(actions[0]))
+		}
+		if !bytes.Equal(send.Packet[66:72], virtualMAC) {
+			t.Errorf("Expected link-layer address %s, but got %s", virtualMAC, net.HardwareAddr(send.Packet[66:72]))
+		}
+	}
+}
+
+func TestEngineSourceAddress(t *testing.T) {
+	asker := net.ParseIP("2001:db8::5")
+	for _, tc := range []struct {
+		name          string
+		source        SourceAddress
+		wantForwarded string
+		wantAnswer    string
+	}{
+		{"auto", SourceAuto, "fd01::1", "fd00::1"},
+		{"link-local", SourceLinkLocal, "fe80::2", "fe80::1"},
+		{"matching prefix", SourceMatchingPrefix, "fd01::1", "2001:db8::1"},
+		{"fixed", SourceFixed, "fd00::99", "fd00::99"},
+		{"RFC 6724", SourceRFC6724, "fe80::2", "2001:db8::1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := EngineConfig{Type: ProxyInstance, Iface1: "ext", Iface2: "int", SourceAddress: tc.source, SourceIP: net.ParseIP("fd00::99")}
+			engine := NewEngine(config, &fakeClock{now: time.Unix(0, 0)})
+			engine.Handle(context.Background(), InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{
+				mustParseIfaceIP("fd00::1/64"), mustParseIfaceIP("2001:db8::1/64"), mustParseIfaceIP("fe80::1/64")}})
+			engine.Handle(context.Background(), InterfaceEvent{Iface: "int", HardwareAddr: testIntMAC, Addrs: []net.Addr{
+				mustParseIfaceIP("fe80::2/64"), mustParseIfaceIP("fd01::1/64")}})
+
+			actions := engine.Handle(context.Background(), PacketEvent{Iface: "ext", Frame: ns(asker, testSolNode, testTarget)(t)})
+			if got := net.IP(actions[len(actions)-1].(SendAction).Packet[8:24]); !got.Equal(net.ParseIP(tc.wantForwarded)) {
+				t.Errorf("Expected the solicitation to be forwarded from %s, but got %s", tc.wantForwarded, got)
+			}
+			actions = engine.Handle(context.Background(), PacketEvent{Iface: "int", Frame: na(testTarget, net.ParseIP("fd01::1"), testTarget)(t)})
+			if got := net.IP(actions[len(actions)-1].(SendAction).Packet[8:24]); !got.Equal(net.ParseIP(tc.wantAnswer)) {
+				t.Errorf("Expected the answer to be sent from %s, but got %s", tc.wantAnswer, got)
+			}
+		})
+	}
+}
+
+func TestEngineAnnounce(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	engine := NewEngine(EngineConfig{Type: ProxyInstance, Iface1: "ext", Iface2: "int", Filter: ParseFilter("fd01::/64"), Announce: true}, clock)
+	describe := func(actions []Action) []string {
+		result := []string{}
+		for _, action := range actions {
+			result = append(result, describeAction(action))
+			if send, ok := action.(SendAction); ok && send.Packet[44] != 0x20 {
+				t.Errorf("Expected only the override flag to be set, but got %x", send.Packet[44])
+			}
+		}
+		return result
+	}
+	intEvent := func(cidrs ...string) InterfaceEvent {
+		event := InterfaceEvent{Iface: "int", HardwareAddr: testIntMAC}
+		for _, cidr := range cidrs {
+			event.Addrs = append(event.Addrs, mustParseIfaceIP(cidr))
+		}
+		return event
+	}
+	announcement := func(target string) string {
+		return "send ext na fd00::1 -> ff02::1 for " + target
+	}
+
+	steps := []struct {
+		name  string
+		event Event
+		want  []string
+	}{
+		{"external interface", InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd00::1/64")}}, []string{}},
+		{"address of the internal interface", intEvent("fd01::1/64"), []string{announcement("fd01::1")}},
+		{"unchanged addresses", intEvent("fd01::1/64"), []string{}},
+		{"added addresses", intEvent("fd01::1/64", "fd01::2/64", "fd02::1/64", "fe80::1/64"), []string{announcement("fd01::2")}},
+		{"neighbors", NeighborEvent{Iface: "int", Addrs: []net.IP{testTarget, net.ParseIP("fe80::5"), net.ParseIP("fd02::5")}}, []string{announcement("fd01::99")}},
+		{"neighbors of the external interface", NeighborEvent{Iface: "ext", Addrs: []net.IP{net.ParseIP("fd01::77")}}, []string{}},
+		{"recently announced target", PacketEvent{Iface: "int", Frame: buildTestFrame(t, testHostMAC, testTarget, net.ParseIP("fd01::1"), testTarget, ndpAdv)}, []string{"emit int not-asked"}},
+		{"learned target", PacketEvent{Iface: "int", Frame: buildTestFrame(t, testHostMAC, net.ParseIP("fd01::98"), net.ParseIP("fd01::1"), net.ParseIP("fd01::98"), ndpAdv)}, []string{announcement("fd01::98"), "emit int not-asked"}},
+	}
+	for _, s := range steps {
+		if got := describe(engine.Handle(ctx, s.event)); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: expected %v, but got %v", s.name, s.want, got)
+		}
+	}
+
+	clock.now = clock.now.Add(announceHoldoff)
+	engine.Handle(ctx, TickEvent{})
+	if got := describe(engine.Handle(ctx, NeighborEvent{Iface: "int", Addrs: []net.IP{testTarget}})); !reflect.DeepEqual(got, []string{announcement("fd01::99")}) {
+		t.Errorf("Expected the target to be announced again after %s, but got %v", announceHoldoff, got)
+	}
+
+	disabled := NewEngine(EngineConfig{Type: ProxyInstance, Iface1: "ext", Iface2: "int"}, clock)
+	disabled.Handle(ctx, InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd00::1/64")}})
+	if actions := disabled.Handle(ctx, intEvent("fd01::1/64")); len(actions) != 0 {
+		t.Errorf("Expected no announcements without Announce, but got %v", describe(actions))
+	}
+}
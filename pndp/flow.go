@@ -9,6 +9,8 @@ import (
 	"time"
 )
 
+// ResponderObj is a responder instance. Its options are set with the Set methods, which must be called before Start().
+// They correspond to the fields of EngineConfig and the options of the responder block in pndpd.conf.
 type ResponderObj struct {
 	stopChan          chan struct{}
 	stopWG            *sync.WaitGroup
@@ -16,7 +18,24 @@ type ResponderObj struct {
 	filter            []*net.IPNet
 	autosense         string
 	monitorInterfaces bool
+	policies          []TargetPolicy
+	network           Network
+	capture           *Capture
+	dryRun            *dryRunCounters
+	router            RouterFlag
+	noOverride        bool
+	strict            bool
+	sendFrames        bool
+	advertiseMAC      net.HardwareAddr
+	advertiseIface    string
+	sourceAddress     SourceAddress
+	sourceIP          net.IP
+	netns             string
 }
+
+// ProxyObj is a proxy instance. Its options are set with the Set methods, which must be called before Start().
+// They correspond to the fields of EngineConfig and the options of the proxy block in pndpd.conf.
+// With SetNetns the interfaces can be in other network namespaces, so that an instance bridges namespaces.
 type ProxyObj struct {
 	stopChan          chan struct{}
 	stopWG            *sync.WaitGroup
@@ -25,6 +44,24 @@ type ProxyObj struct {
 	filter            []*net.IPNet
 	autosense         string
 	monitorInterfaces bool
+	policies          []TargetPolicy
+	network           Network
+	capture           *Capture
+	dryRun            *dryRunCounters
+	announce          bool
+	defend            bool
+	router            RouterFlag
+	noOverride        bool
+	strict            bool
+	sendFrames        bool
+	advertiseMAC      net.HardwareAddr
+	advertiseIface    string
+	sourceAddress     SourceAddress
+	sourceIP          net.IP
+	extNetns          string
+	intNetns          string
+	// shared is the socket on iface1 of the proxy group the instance belongs to (nil for other instances)
+	shared *sharedSocket
 }
 
 // NewResponder
@@ -38,11 +75,6 @@ type ProxyObj struct {
 //
 // Start() must be called on the object to actually start responding
 func NewResponder(iface string, filter []*net.IPNet, autosenseInterface string, monitorInterfaces bool) *ResponderObj {
-	if filter == nil && autosenseInterface == "" {
-		fmt.Println("WARNING: You should use a whitelist for the responder unless you really know what you are doing")
-	}
-	checkIsValidNetworkInterfaceFatal(iface, autosenseInterface)
-
 	var s sync.WaitGroup
 	return &ResponderObj{
 		stopChan:          make(chan struct{}),
@@ -51,33 +83,109 @@ func NewResponder(iface string, filter []*net.IPNet, autosenseInterface string,
 		filter:            filter,
 		autosense:         autosenseInterface,
 		monitorInterfaces: monitorInterfaces,
+		network:           SystemNetwork,
 	}
 }
-func (obj *ResponderObj) Start() {
-	go obj.start()
+
+// SetPolicies attaches a chain of policies that is consulted (in order) for every target that passes the filter
+func (obj *ResponderObj) SetPolicies(policies ...TargetPolicy) {
+	obj.policies = policies
 }
-func (obj *ResponderObj) start() {
-	obj.stopWG.Add(1)
 
-	startInterfaceMon()
+// SetNetwork replaces the Network (SystemNetwork by default) used for packet I/O and interface information
+func (obj *ResponderObj) SetNetwork(network Network) {
+	obj.network = network
+}
 
-	addInterfaceToMon(obj.iface, obj.monitorInterfaces)
-	addInterfaceToMon(obj.autosense, true)
+// SetCapture writes the frames of the instance to c instead of the default capture (see SetDefaultCapture)
+func (obj *ResponderObj) SetCapture(c *Capture) {
+	obj.capture = c
+}
 
-	requests := make(chan *ndpRequest, 100)
-	defer func() {
-		close(requests)
-		obj.stopWG.Done()
-	}()
-	go respond(obj.iface, requests, ndpAdv, nil, obj.filter, obj.autosense, obj.stopWG, obj.stopChan)
-	go listen(obj.iface, requests, ndpSol, obj.stopWG, obj.stopChan)
-	fmt.Printf("Started responder instance on interface %s", obj.iface)
+// SetObserve enables observe mode (dry-run), in which the packets that would be sent are logged and counted instead
+func (obj *ResponderObj) SetObserve(observe bool) {
+	obj.dryRun = nil
+	if observe {
+		obj.dryRun = &dryRunCounters{}
+	}
+}
+
+// SetFlags selects the router flag of the advertisements (see EngineConfig.Router) and whether answers carry the override flag
+func (obj *ResponderObj) SetFlags(router RouterFlag, override bool) {
+	obj.router = router
+	obj.noOverride = !override
+}
+
+// SetStrict enables the additional checks of received solicitations (see EngineConfig.Strict)
+func (obj *ResponderObj) SetStrict(strict bool) {
+	obj.strict = strict
+}
+
+// SetSendFrames sends packets as complete Ethernet frames where the link-layer destination is known (see FrameWriter)
+func (obj *ResponderObj) SetSendFrames(sendFrames bool) {
+	obj.sendFrames = sendFrames
+}
+
+// SetAdvertiseMAC replaces the advertised link-layer address with mac, or with the address of iface if mac is nil (see EngineConfig.AdvertiseMAC)
+func (obj *ResponderObj) SetAdvertiseMAC(mac net.HardwareAddr, iface string) {
+	obj.advertiseMAC = mac
+	obj.advertiseIface = iface
+}
+
+// SetSourceAddress selects the source address of the packets that are sent (see EngineConfig.SourceAddress). ip is only used with SourceFixed.
+func (obj *ResponderObj) SetSourceAddress(source SourceAddress, ip net.IP) {
+	obj.sourceAddress = source
+	obj.sourceIP = ip
+}
+
+// SetNetns places the interface, its autosense interface and the interface of SetAdvertiseMAC in the network namespace netns
+func (obj *ResponderObj) SetNetns(netns string) {
+	obj.netns = netns
+}
+
+// getNetwork returns the Network of the instance that takes the namespaces of the interfaces into account
+func (obj *ResponderObj) getNetwork() Network {
+	return withNamespaces(obj.network, obj.netns)
+}
+
+func (obj *ResponderObj) getCapture() *Capture {
+	if obj.capture != nil {
+		return obj.capture
+	}
+	return defaultCapture
+}
+
+func (obj *ResponderObj) Start() {
+	go obj.start()
+}
+func (obj *ResponderObj) start() {
+	if obj.filter == nil && obj.autosense == "" {
+		fmt.Println("WARNING: You should use a whitelist for the responder unless you really know what you are doing")
+	}
+	config := obj.engineConfig()
+	fmt.Printf("Started responder instance on interface %s", config.Iface1)
+	if obj.dryRun != nil {
+		fmt.Print(" in observe mode (dry-run)")
+	}
 	fmt.Println()
-	<-obj.stopChan
+	runEngine(obj.getNetwork(), config, obj.getCapture(), obj.monitorInterfaces, obj.dryRun, obj.sendFrames, nil, obj.stopWG, obj.stopChan)
+}
 
-	removeInterfaceFromMon(obj.iface)
-	removeInterfaceFromMon(obj.autosense)
-	stopInterfaceMon()
+func (obj *ResponderObj) engineConfig() EngineConfig {
+	return EngineConfig{
+		Type:           ResponderInstance,
+		Iface1:         namespacedName(obj.iface, obj.netns),
+		Filter:         obj.filter,
+		Autosense:      namespacedName(obj.autosense, obj.netns),
+		Policies:       obj.policies,
+		Router:         obj.router,
+		NoOverride:     obj.noOverride,
+		Strict:         obj.strict,
+		AdvertiseMAC:   obj.advertiseMAC,
+		AdvertiseIface: namespacedName(obj.advertiseIface, obj.netns),
+		SourceAddress:  obj.sourceAddress,
+		SourceIP:       obj.sourceIP,
+	}
 }
 
 // Stop a running Responder instance
@@ -86,6 +194,9 @@ func (obj *ResponderObj) Stop() bool {
 	close(obj.stopChan)
 	fmt.Println("Shutting down responder instance..")
 	if wgWaitTimout(obj.stopWG, 10*time.Second) {
+		if obj.dryRun != nil {
+			fmt.Println("Observe mode:", obj.dryRun)
+		}
 		fmt.Println("Done")
 		return true
 	} else {
@@ -103,9 +214,6 @@ func (obj *ResponderObj) Stop() bool {
 //
 // Start() must be called on the object to actually start proxying
 func NewProxy(iface1 string, iface2 string, filter []*net.IPNet, autosenseInterface string, monitorInterfaces bool) *ProxyObj {
-
-	checkIsValidNetworkInterfaceFatal(iface1, iface2, autosenseInterface)
-
 	var s sync.WaitGroup
 	return &ProxyObj{
 		stopChan:          make(chan struct{}),
@@ -115,56 +223,120 @@ func NewProxy(iface1 string, iface2 string, filter []*net.IPNet, autosenseInterf
 		filter:            filter,
 		autosense:         autosenseInterface,
 		monitorInterfaces: monitorInterfaces,
+		network:           SystemNetwork,
 	}
 }
 
+// SetPolicies attaches a chain of policies that is consulted (in order) for every target that passes the filter
+func (obj *ProxyObj) SetPolicies(policies ...TargetPolicy) {
+	obj.policies = policies
+}
+
+// SetNetwork replaces the Network (SystemNetwork by default) used for packet I/O and interface information
+func (obj *ProxyObj) SetNetwork(network Network) {
+	obj.network = network
+}
+
+// SetCapture writes the frames of the instance to c instead of the default capture (see SetDefaultCapture)
+func (obj *ProxyObj) SetCapture(c *Capture) {
+	obj.capture = c
+}
+
+// SetObserve enables observe mode (dry-run), in which the packets that would be sent are logged and counted instead
+func (obj *ProxyObj) SetObserve(observe bool) {
+	obj.dryRun = nil
+	if observe {
+		obj.dryRun = &dryRunCounters{}
+	}
+}
+
+// SetAnnounce enables unsolicited advertisements for addresses that appear behind the internal interface (see EngineConfig.Announce)
+func (obj *ProxyObj) SetAnnounce(announce bool) {
+	obj.announce = announce
+}
+
+// SetDefend answers Duplicate Address Detection for addresses in use behind the internal interface (see EngineConfig.Defend)
+func (obj *ProxyObj) SetDefend(defend bool) {
+	obj.defend = defend
+}
+
+// SetFlags selects the router flag of the advertisements (see EngineConfig.Router) and whether answers carry the override flag
+func (obj *ProxyObj) SetFlags(router RouterFlag, override bool) {
+	obj.router = router
+	obj.noOverride = !override
+}
+
+// SetStrict enables the additional checks of received solicitations (see EngineConfig.Strict)
+func (obj *ProxyObj) SetStrict(strict bool) {
+	obj.strict = strict
+}
+
+// SetSendFrames sends packets as complete Ethernet frames where the link-layer destination is known (see FrameWriter)
+func (obj *ProxyObj) SetSendFrames(sendFrames bool) {
+	obj.sendFrames = sendFrames
+}
+
+// SetAdvertiseMAC replaces the advertised link-layer address with mac, or with the address of iface if mac is nil (see EngineConfig.AdvertiseMAC)
+func (obj *ProxyObj) SetAdvertiseMAC(mac net.HardwareAddr, iface string) {
+	obj.advertiseMAC = mac
+	obj.advertiseIface = iface
+}
+
+// SetSourceAddress selects the source address of the packets that are sent (see EngineConfig.SourceAddress). ip is only used with SourceFixed.
+func (obj *ProxyObj) SetSourceAddress(source SourceAddress, ip net.IP) {
+	obj.sourceAddress = source
+	obj.sourceIP = ip
+}
+
+// SetNetns places the external and internal interface in the network namespaces extNetns and intNetns (see NetnsPath)
+func (obj *ProxyObj) SetNetns(extNetns string, intNetns string) {
+	obj.extNetns = extNetns
+	obj.intNetns = intNetns
+}
+
+// getNetwork returns the Network of the instance that takes the namespaces of the interfaces into account
+func (obj *ProxyObj) getNetwork() Network {
+	return withNamespaces(obj.network, obj.extNetns, obj.intNetns)
+}
+
+func (obj *ProxyObj) getCapture() *Capture {
+	if obj.capture != nil {
+		return obj.capture
+	}
+	return defaultCapture
+}
+
 func (obj *ProxyObj) Start() {
 	go obj.start()
 }
 func (obj *ProxyObj) start() {
-	obj.stopWG.Add(1)
-	defer func() {
-		obj.stopWG.Done()
-	}()
-
-	startInterfaceMon()
-	addInterfaceToMon(obj.iface1, obj.monitorInterfaces)
-	addInterfaceToMon(obj.iface2, obj.monitorInterfaces)
-	addInterfaceToMon(obj.autosense, true)
-
-	out_iface1_sol_questions_iface2_adv := make(chan ndpQuestion, 100)
-	defer close(out_iface1_sol_questions_iface2_adv)
-	out_iface2_sol_questions_iface1_adv := make(chan ndpQuestion, 100)
-	defer close(out_iface2_sol_questions_iface1_adv)
-
-	req_iface1_sol_iface2 := make(chan *ndpRequest, 100)
-	defer close(req_iface1_sol_iface2)
-	go listen(obj.iface1, req_iface1_sol_iface2, ndpSol, obj.stopWG, obj.stopChan)
-	go respond(obj.iface2, req_iface1_sol_iface2, ndpSol, out_iface2_sol_questions_iface1_adv, obj.filter, obj.autosense, obj.stopWG, obj.stopChan)
-
-	req_iface2_sol_iface1 := make(chan *ndpRequest, 100)
-	defer close(req_iface2_sol_iface1)
-	go listen(obj.iface2, req_iface2_sol_iface1, ndpSol, obj.stopWG, obj.stopChan)
-	go respond(obj.iface1, req_iface2_sol_iface1, ndpSol, out_iface1_sol_questions_iface2_adv, nil, "", obj.stopWG, obj.stopChan)
-
-	req_iface1_adv_iface2 := make(chan *ndpRequest, 100)
-	defer close(req_iface1_adv_iface2)
-	go listen(obj.iface1, req_iface1_adv_iface2, ndpAdv, obj.stopWG, obj.stopChan)
-	go respond(obj.iface2, req_iface1_adv_iface2, ndpAdv, out_iface1_sol_questions_iface2_adv, nil, "", obj.stopWG, obj.stopChan)
-
-	req_iface2_adv_iface1 := make(chan *ndpRequest, 100)
-	defer close(req_iface2_adv_iface1)
-	go listen(obj.iface2, req_iface2_adv_iface1, ndpAdv, obj.stopWG, obj.stopChan)
-	go respond(obj.iface1, req_iface2_adv_iface1, ndpAdv, out_iface2_sol_questions_iface1_adv, nil, "", obj.stopWG, obj.stopChan)
-
-	fmt.Printf("Started Proxy instance on interfaces %s and %s (if enabled, the whitelist is applied on %s)", obj.iface1, obj.iface2, obj.iface2)
+	config := obj.engineConfig()
+	fmt.Printf("Started Proxy instance on interfaces %s and %s (if enabled, the whitelist is applied on %s)", config.Iface1, config.Iface2, config.Iface2)
+	if obj.dryRun != nil {
+		fmt.Print(" in observe mode (dry-run)")
+	}
 	fmt.Println()
-	<-obj.stopChan
+	runEngine(obj.getNetwork(), config, obj.getCapture(), obj.monitorInterfaces, obj.dryRun, obj.sendFrames, obj.shared, obj.stopWG, obj.stopChan)
+}
 
-	removeInterfaceFromMon(obj.iface1)
-	removeInterfaceFromMon(obj.iface2)
-	removeInterfaceFromMon(obj.autosense)
-	stopInterfaceMon()
+func (obj *ProxyObj) engineConfig() EngineConfig {
+	return EngineConfig{
+		Type:           ProxyInstance,
+		Iface1:         namespacedName(obj.iface1, obj.extNetns),
+		Iface2:         namespacedName(obj.iface2, obj.intNetns),
+		Filter:         obj.filter,
+		Autosense:      namespacedName(obj.autosense, obj.intNetns),
+		Policies:       obj.policies,
+		Announce:       obj.announce,
+		Defend:         obj.defend,
+		Router:         obj.router,
+		NoOverride:     obj.noOverride,
+		Strict:         obj.strict,
+		AdvertiseMAC:   obj.advertiseMAC,
+		AdvertiseIface: namespacedName(obj.advertiseIface, obj.extNetns),
+		SourceAddress:  obj.sourceAddress,
+		SourceIP:       obj.sourceIP,
+	}
 }
 
 // Stop a running Proxy instance
@@ -173,6 +345,9 @@ func (obj *ProxyObj) Stop() bool {
 	close(obj.stopChan)
 	fmt.Println("Shutting down proxy instance..")
 	if wgWaitTimout(obj.stopWG, 10*time.Second) {
+		if obj.dryRun != nil {
+			fmt.Println("Observe mode:", obj.dryRun)
+		}
 		fmt.Println("Done")
 		return true
 	} else {
@@ -212,24 +387,6 @@ func wgWaitTimout(wg *sync.WaitGroup, timeout time.Duration) bool {
 	}
 }
 
-func isValidNetworkInterface(iface string) bool {
-	if iface == "" {
-		return true
-	}
-	if _, err := net.InterfaceByName(iface); err != nil {
-		return false
-	}
-	return true
-}
-
-func checkIsValidNetworkInterfaceFatal(iface ...string) {
-	for i := range iface {
-		if !isValidNetworkInterface(iface[i]) {
-			showFatalError(fmt.Sprintf("No such network interface \"%s\"", iface[i]))
-		}
-	}
-}
-
 func showFatalError(error ...string) {
 	fmt.Print("Error: ")
 	for _, err := range error {
@@ -0,0 +1,406 @@
+package pndp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProxyMemoryNetwork(t *testing.T) {
+	network := NewMemoryNetwork()
+	extMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	intMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+	extLink := network.AddLink("mem-ext", extMAC, mustParseIfaceIP("fd00::1/64"))
+	intLink := network.AddLink("mem-int", intMAC, mustParseIfaceIP("fd01::1/64"))
+
+	proxy := NewProxy("mem-ext", "mem-int", nil, "mem-int", true)
+	proxy.SetNetwork(network)
+	proxy.Start()
+	defer proxy.Stop()
+	waitForConns(t, extLink, 1)
+	waitForConns(t, intLink, 1)
+
+	askerMAC := []byte{0x02, 0, 0, 0, 0, 0xaa}
+	asker := net.ParseIP("fd00::5")
+	target := net.ParseIP("fd01::99")
+	solicitedNode := net.ParseIP("ff02::1:ff00:99")
+
+	// A solicitation on the external interface is forwarded to the internal interface
+	extLink.Inject(buildTestFrame(t, askerMAC, asker, solicitedNode, target, ndpSol))
+	sent := expectPacket(t, intLink)
+	checkTestPacket(t, sent, ndpSol, net.ParseIP("fd01::1"), solicitedNode, target, intMAC)
+
+	// The answer on the internal interface is sent back to the asker
+	intLink.Inject(buildTestFrame(t, []byte{0x02, 0, 0, 0, 0, 0xbb}, target, net.ParseIP("fd01::1"), target, ndpAdv))
+	sent = expectPacket(t, extLink)
+	checkTestPacket(t, sent, ndpAdv, net.ParseIP("fd00::1"), asker, target, extMAC)
+
+	// Targets outside the autosensed networks are ignored
+	extLink.Inject(buildTestFrame(t, askerMAC, asker, net.ParseIP("ff02::1:ff00:1"), net.ParseIP("fd02::1"), ndpSol))
+	expectNoPacket(t, intLink)
+}
+
+func TestResponderMemoryNetwork(t *testing.T) {
+	network := NewMemoryNetwork()
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x03}
+	link := network.AddLink("mem-resp", mac, mustParseIfaceIP("fd00::1/64"))
+
+	responder := NewResponder("mem-resp", ParseFilter("fd01::/64"), "", true)
+	responder.SetNetwork(network)
+	responder.Start()
+	defer responder.Stop()
+	waitForConns(t, link, 1)
+
+	askerMAC := []byte{0x02, 0, 0, 0, 0, 0xaa}
+	asker := net.ParseIP("fd00::5")
+	target := net.ParseIP("fd01::99")
+
+	link.Inject(buildTestFrame(t, askerMAC, asker, net.ParseIP("ff02::1:ff00:99"), target, ndpSol))
+	sent := expectPacket(t, link)
+	checkTestPacket(t, sent, ndpAdv, target, asker, target, mac)
+
+	link.Inject(buildTestFrame(t, askerMAC, asker, net.ParseIP("ff02::1:ff00:99"), net.ParseIP("fd02::99"), ndpSol))
+	expectNoPacket(t, link)
+}
+
+func TestProxyObserveMode(t *testing.T) {
+	network := NewMemoryNetwork()
+	extLink := network.AddLink("mem-ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+	intLink := network.AddLink("mem-int", testIntMAC, mustParseIfaceIP("fd01::1/64"))
+
+	proxy := NewProxy("mem-ext", "mem-int", nil, "mem-int", true)
+	proxy.SetNetwork(network)
+	proxy.SetObserve(true)
+	proxy.Start()
+	defer proxy.Stop()
+	waitForConns(t, extLink, 1)
+	waitForConns(t, intLink, 1)
+
+	// The decisions are made as usual, but nothing is sent
+	extLink.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol))
+	expectNoPacket(t, intLink)
+	intLink.Inject(buildTestFrame(t, testHostMAC, testTarget, net.ParseIP("fd01::1"), testTarget, ndpAdv))
+	expectNoPacket(t, extLink)
+
+	if got := proxy.dryRun.String(); got != "1 solicitations and 1 advertisements would have been sent (dry-run)" {
+		t.Errorf("Unexpected counts: %s", got)
+	}
+}
+
+func TestProxyAnnounceOnStart(t *testing.T) {
+	network := NewMemoryNetwork()
+	extLink := network.AddLink("mem-ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+	intLink := network.AddLink("mem-int", testIntMAC, mustParseIfaceIP("fd01::1/64"))
+	intLink.SetNeighbors(testTarget, net.ParseIP("fd02::1"))
+
+	proxy := NewProxy("mem-ext", "mem-int", nil, "mem-int", true)
+	proxy.SetNetwork(network)
+	proxy.SetAnnounce(true)
+	proxy.Start()
+	defer proxy.Stop()
+
+	// The address of the internal interface and its allowed neighbors are announced
+	for _, target := range []net.IP{net.ParseIP("fd01::1"), testTarget} {
+		sent := expectPacket(t, extLink)
+		checkTestPacket(t, sent, ndpAdv, net.ParseIP("fd00::1"), net.ParseIP("ff02::1"), target, testExtMAC)
+	}
+	expectNoPacket(t, extLink)
+}
+
+func TestResponderSendFrames(t *testing.T) {
+	network := NewMemoryNetwork()
+	link := network.AddLink("mem-resp", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+
+	responder := NewResponder("mem-resp", ParseFilter("fd01::/64"), "", true)
+	responder.SetNetwork(network)
+	responder.SetSendFrames(true)
+	responder.Start()
+	defer responder.Stop()
+	waitForConns(t, link, 1)
+
+	// Answers are sent as frames to the source link-layer address of the solicitation
+	link.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol))
+	sent := expectPacket(t, link)
+	checkTestPacket(t, sent, ndpAdv, testTarget, testAsker, testTarget, testExtMAC)
+	if !bytes.Equal(sent.DstMAC, testHostMAC) {
+		t.Errorf("Expected a frame to %s, but got %s", testHostMAC, sent.DstMAC)
+	}
+
+	// Answers to Duplicate Address Detection go to the multicast MAC of all nodes
+	link.Inject(buildTestFrame(t, testHostMAC, testUnspec, testSolNode, testTarget, ndpSol))
+	sent = expectPacket(t, link)
+	if want := (net.HardwareAddr{0x33, 0x33, 0, 0, 0, 0x01}); !bytes.Equal(sent.DstMAC, want) {
+		t.Errorf("Expected a frame to %s, but got %s", want, sent.DstMAC)
+	}
+}
+
+func TestProxyAdvertiseMAC(t *testing.T) {
+	network := NewMemoryNetwork()
+	extLink := network.AddLink("mem-ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+	intLink := network.AddLink("mem-int", testIntMAC, mustParseIfaceIP("fd01::1/64"))
+	virtualMAC := net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x01, 0x01}
+	vrrpLink := network.AddLink("mem-vrrp", virtualMAC)
+
+	proxy := NewProxy("mem-ext", "mem-int", nil, "mem-int", true)
+	proxy.SetNetwork(network)
+	proxy.SetAdvertiseMAC(nil, "mem-vrrp")
+	proxy.Start()
+	defer proxy.Stop()
+	waitForConns(t, extLink, 1)
+	waitForConns(t, intLink, 1)
+
+	// Forwarded solicitations carry the own address, answers the address of the other interface
+	extLink.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol))
+	checkTestPacket(t, expectPacket(t, intLink), ndpSol, net.ParseIP("fd01::1"), testSolNode, testTarget, testIntMAC)
+	intLink.Inject(buildTestFrame(t, testHostMAC, testTarget, net.ParseIP("fd01::1"), testTarget, ndpAdv))
+	checkTestPacket(t, expectPacket(t, extLink), ndpAdv, net.ParseIP("fd00::1"), testAsker, testTarget, virtualMAC)
+
+	// The address is followed when it changes
+	changedMAC := net.HardwareAddr{0x00, 0x00, 0x5e, 0x00, 0x01, 0x02}
+	vrrpLink.SetHardwareAddr(changedMAC)
+	extLink.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol))
+	expectPacket(t, intLink)
+	intLink.Inject(buildTestFrame(t, testHostMAC, testTarget, net.ParseIP("fd01::1"), testTarget, ndpAdv))
+	checkTestPacket(t, expectPacket(t, extLink), ndpAdv, net.ParseIP("fd00::1"), testAsker, testTarget, changedMAC)
+}
+
+func TestResponderHardwareAddrChange(t *testing.T) {
+	network := NewMemoryNetwork()
+	link := network.AddLink("mem-resp", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+
+	responder := NewResponder("mem-resp", ParseFilter("fd01::/64"), "", true)
+	responder.SetNetwork(network)
+	responder.Start()
+	defer responder.Stop()
+	waitForConns(t, link, 1)
+
+	changedMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x05}
+	link.SetHardwareAddr(changedMAC)
+	link.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol))
+	checkTestPacket(t, expectPacket(t, link), ndpAdv, testTarget, testAsker, testTarget, changedMAC)
+}
+
+func TestProxyInterfaceHotplug(t *testing.T) {
+	network := NewMemoryNetwork()
+	extLink := network.AddLink("mem-ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+
+	// The internal interface does not exist yet
+	proxy := NewProxy("mem-ext", "mem-int", nil, "mem-int", true)
+	proxy.SetNetwork(network)
+	proxy.Start()
+	defer proxy.Stop()
+	waitForConns(t, extLink, 1)
+
+	for i := 0; i < 2; i++ {
+		intLink := network.AddLink("mem-int", testIntMAC, mustParseIfaceIP("fd01::1/64"))
+		waitForConns(t, intLink, 1)
+		extLink.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol))
+		checkTestPacket(t, expectPacket(t, intLink), ndpSol, net.ParseIP("fd01::1"), testSolNode, testTarget, testIntMAC)
+
+		// The socket is reopened once the interface is recreated
+		network.RemoveLink("mem-int")
+	}
+}
+
+func TestProxyLinkDown(t *testing.T) {
+	network := NewMemoryNetwork()
+	extLink := network.AddLink("mem-ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+	intLink := network.AddLink("mem-int", testIntMAC, mustParseIfaceIP("fd01::1/64"))
+
+	// The operational state is followed even if address changes are not
+	proxy := NewProxy("mem-ext", "mem-int", ParseFilter("fd01::/64"), "", false)
+	proxy.SetNetwork(network)
+	proxy.Start()
+	defer proxy.Stop()
+	waitForConns(t, extLink, 1)
+	waitForConns(t, intLink, 1)
+
+	intLink.SetRunning(false)
+	expectNoPacket(t, extLink)
+	extLink.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol))
+	expectNoPacket(t, intLink)
+
+	intLink.SetRunning(true)
+	extLink.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol))
+	checkTestPacket(t, expectPacket(t, intLink), ndpSol, net.ParseIP("fd01::1"), testSolNode, testTarget, testIntMAC)
+}
+
+func TestProxyGroup(t *testing.T) {
+	network := NewMemoryNetwork()
+	extLink := network.AddLink("mem-ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+	link0 := network.AddLink("mem-ppp0", testIntMAC, mustParseIfaceIP("fd01::1/64"))
+
+	template := NewProxy("mem-ext", "mem-ppp*", nil, "mem-ppp*", true)
+	template.SetNetwork(network)
+	group := NewProxyGroup(template)
+	group.Start()
+	stopped := false
+	defer func() {
+		if !stopped {
+			group.Stop()
+		}
+	}()
+	waitForConns(t, link0, 1)
+
+	target := net.ParseIP("fd02::99")
+	for i := 0; i < 2; i++ {
+		link1 := network.AddLink("mem-ppp1", testIntMAC, mustParseIfaceIP("fd02::1/64"))
+		waitForConns(t, link1, 1)
+		// The instances share a single socket on the external interface
+		extLink.mu.Lock()
+		if len(extLink.conns) != 1 {
+			t.Errorf("Expected a single socket on the external interface, but got %d", len(extLink.conns))
+		}
+		extLink.mu.Unlock()
+
+		// Each instance only forwards for the addresses of its own interface
+		extLink.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, target, ndpSol))
+		checkTestPacket(t, expectPacket(t, link1), ndpSol, net.ParseIP("fd02::1"), testSolNode, target, testIntMAC)
+		expectNoPacket(t, link1)
+		expectNoPacket(t, link0)
+
+		// The instance is stopped with the interface, but the socket stays open for the other instance
+		network.RemoveLink("mem-ppp1")
+	}
+
+	extLink.Inject(buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol))
+	checkTestPacket(t, expectPacket(t, link0), ndpSol, net.ParseIP("fd01::1"), testSolNode, testTarget, testIntMAC)
+
+	// The socket is closed with the last instance
+	group.Stop()
+	stopped = true
+	waitForClosedConns(t, extLink, 0)
+}
+
+func TestProxyGroupChurn(t *testing.T) {
+	network := NewMemoryNetwork()
+	network.AddLink("mem-ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+	link := network.AddLink("mem-ppp", testIntMAC, mustParseIfaceIP("fd01::1/64"))
+
+	template := NewProxy("mem-ext", "mem-ppp*", nil, "mem-ppp*", true)
+	template.SetNetwork(network)
+	group := NewProxyGroup(template)
+	group.Start()
+	waitForConns(t, link, 1)
+
+	// More interfaces come and go than the notifications that an instance or the group could buffer,
+	// while instances are being stopped
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for round := 0; round < 3; round++ {
+			for i := 0; i < 150; i++ {
+				network.AddLink(fmt.Sprintf("mem-ppp%d", i), testIntMAC, mustParseIfaceIP("fd01::1/64"))
+			}
+			for i := 0; i < 150; i++ {
+				network.RemoveLink(fmt.Sprintf("mem-ppp%d", i))
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Interface notifications are blocked")
+	}
+	if !group.Stop() {
+		t.Error("Unable to stop the group")
+	}
+}
+
+func mustParseIfaceIP(cidr string) *net.IPNet {
+	ip, result, _ := net.ParseCIDR(cidr)
+	result.IP = ip
+	return result
+}
+
+func buildTestFrame(t *testing.T, srcMAC []byte, srcIP net.IP, dstIP net.IP, target net.IP, packetType ndpType) []byte {
+	t.Helper()
+	packet, err := buildNDPPacket(srcIP.To16(), dstIP.To16(), target.To16(), srcMAC, packetType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := []byte{0x33, 0x33, 0, 0, 0, 0x01}
+	frame = append(frame, srcMAC...)
+	frame = append(frame, 0x86, 0xdd)
+	return append(frame, packet...)
+}
+
+func checkTestPacket(t *testing.T, sent MemoryPacket, packetType ndpType, srcIP net.IP, dstIP net.IP, target net.IP, mac []byte) {
+	t.Helper()
+	packet := sent.Packet
+	if len(packet) != 72 {
+		t.Fatalf("Unexpected packet length %d", len(packet))
+	}
+	if !sent.Dst.Equal(dstIP) || !net.IP(packet[24:40]).Equal(dstIP) {
+		t.Errorf("Expected destination %s, but got %s", dstIP, sent.Dst)
+	}
+	if !net.IP(packet[8:24]).Equal(srcIP) {
+		t.Errorf("Expected source %s, but got %s", srcIP, net.IP(packet[8:24]))
+	}
+	if (packetType == ndpSol && packet[40] != 0x87) || (packetType == ndpAdv && packet[40] != 0x88) {
+		t.Errorf("Unexpected ICMPv6 type %x", packet[40])
+	}
+	if !net.IP(packet[48:64]).Equal(target) {
+		t.Errorf("Expected target %s, but got %s", target, net.IP(packet[48:64]))
+	}
+	if !bytes.Equal(packet[66:72], mac) {
+		t.Errorf("Expected link-layer address %x, but got %x", mac, packet[66:72])
+	}
+	v6, _ := newIpv6Header(packet[8:24], packet[24:40])
+	if !checkPacketChecksum(v6, packet[40:]) {
+		t.Errorf("Invalid checksum")
+	}
+}
+
+func waitForConns(t *testing.T, link *MemoryLink, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		link.mu.Lock()
+		count := len(link.conns)
+		link.mu.Unlock()
+		if count >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timeout waiting for the instance to open %s", link.iface.Name)
+}
+
+// waitForClosedConns waits until at most n PacketConns are open on link
+func waitForClosedConns(t *testing.T, link *MemoryLink, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		link.mu.Lock()
+		count := len(link.conns)
+		link.mu.Unlock()
+		if count <= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timeout waiting for the instances to close %s", link.iface.Name)
+}
+
+func expectPacket(t *testing.T, link *MemoryLink) MemoryPacket {
+	t.Helper()
+	select {
+	case p := <-link.Sent():
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timeout waiting for a packet on %s", link.iface.Name)
+	}
+	return MemoryPacket{}
+}
+
+func expectNoPacket(t *testing.T, link *MemoryLink) {
+	t.Helper()
+	select {
+	case p := <-link.Sent():
+		t.Errorf("Unexpected packet on %s: %x", link.iface.Name, p.Packet)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
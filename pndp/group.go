@@ -0,0 +1,321 @@
+package pndp
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyGroup runs a proxy instance for every interface whose name matches a pattern, such as the interfaces of
+// PPPoE or WireGuard sessions that come and go. Instances are started when a matching interface is created
+// and stopped when it is deleted.
+//
+// The instances share a single socket on the external interface (see sharedSocket), so every frame is received once
+// by the group. Each instance decides on its own about every solicitation it receives, so the template must autosense
+// the pattern: with a filter, every solicitation for an allowed target would be forwarded to every matching interface.
+type ProxyGroup struct {
+	template *ProxyObj
+	stopChan chan struct{}
+	stopWG   *sync.WaitGroup
+}
+
+// IsInterfacePattern reports whether name is a pattern (see matchInterface) rather than the name of an interface.
+// Only shell patterns with the wildcards * and ? and character classes are supported, not regular expressions.
+func IsInterfacePattern(name string) bool {
+	return strings.ContainsAny(name, "*?[\\")
+}
+
+// NewProxyGroup creates a group of proxy instances that are configured like template. The internal interface of template
+// is a pattern (see matchInterface) and every instance proxies between the external interface and one interface matching it.
+// If the autosense interface of template is the same pattern, each instance autosenses the addresses of its own interface.
+//
+// Start() must be called on the object to actually start proxying
+func NewProxyGroup(template *ProxyObj) *ProxyGroup {
+	var s sync.WaitGroup
+	return &ProxyGroup{
+		template: template,
+		stopChan: make(chan struct{}),
+		stopWG:   &s,
+	}
+}
+
+func (group *ProxyGroup) Start() {
+	group.stopWG.Add(1)
+	go group.start()
+}
+
+func (group *ProxyGroup) start() {
+	defer group.stopWG.Done()
+	config := group.template.engineConfig()
+	pattern := config.Iface2
+	fmt.Printf("Started Proxy group on interface %s for the interfaces matching %s\n", config.Iface1, pattern)
+	if config.Autosense != pattern {
+		slog.Warn("Proxy group without autosense: every instance forwards the solicitations for all allowed targets", "pattern", pattern)
+	}
+
+	network := group.template.getNetwork()
+	startInterfaceMon(network)
+	defer stopInterfaceMon(network)
+	shared := &sharedSocket{network: network, iface: config.Iface1}
+
+	changes := newInterfaceChanges()
+	subscriber := subscribeInterfaceMonPattern(changes.add, pattern)
+	defer unsubscribeInterfaceMon(subscriber)
+
+	instances := make(map[string]*ProxyObj)
+	defer func() {
+		for _, instance := range instances {
+			instance.Stop()
+		}
+	}()
+	// update starts or stops the instance of iface depending on whether the interface exists
+	update := func(iface string) {
+		_, err := network.InterfaceByName(iface)
+		instance, running := instances[iface]
+		switch {
+		case err == nil && !running:
+			instance = group.template.forInterface(iface)
+			instance.shared = shared
+			instances[iface] = instance
+			instance.Start()
+		case err != nil && running:
+			instance.Stop()
+			delete(instances, iface)
+		}
+	}
+
+	ifaces, err := group.matchingInterfaces()
+	if err != nil {
+		slog.Warn("Unable to list the interfaces", "pattern", pattern, "error", err)
+	}
+	for _, iface := range ifaces {
+		update(iface)
+	}
+
+	for {
+		select {
+		case <-group.stopChan:
+			return
+		case <-changes.wake:
+			for _, iface := range changes.take() {
+				update(iface)
+			}
+		}
+	}
+}
+
+// Stop a running Proxy group and all of its instances
+// Returns false on error
+func (group *ProxyGroup) Stop() bool {
+	close(group.stopChan)
+	fmt.Println("Shutting down proxy group..")
+	if wgWaitTimout(group.stopWG, 10*time.Second) {
+		fmt.Println("Done")
+		return true
+	} else {
+		fmt.Println("Error shutting down group")
+		return false
+	}
+}
+
+// matchingInterfaces returns the (namespaced) names of the existing interfaces that match the pattern of the group.
+// Networks that cannot list their interfaces have none.
+func (group *ProxyGroup) matchingInterfaces() ([]string, error) {
+	lister, ok := group.template.getNetwork().(InterfaceLister)
+	if !ok {
+		return nil, nil
+	}
+	ifaces, err := lister.Interfaces()
+	pattern := group.template.engineConfig().Iface2
+	var result []string
+	for _, iface := range ifaces {
+		if matchInterface(pattern, iface.Name) {
+			result = append(result, iface.Name)
+		}
+	}
+	return result, err
+}
+
+// forInterface returns a new instance that is configured like obj and proxies for iface2 instead of the pattern of obj.
+// iface2 is the namespaced name of the interface (see namespacedName).
+func (obj *ProxyObj) forInterface(iface2 string) *ProxyObj {
+	if obj.intNetns != "" {
+		iface2 = strings.TrimSuffix(iface2, netnsSeparator+obj.intNetns)
+	}
+	instance := *obj
+	instance.stopChan = make(chan struct{})
+	instance.stopWG = &sync.WaitGroup{}
+	if obj.dryRun != nil {
+		// Every instance counts the packets it would have sent on its own
+		instance.dryRun = &dryRunCounters{}
+	}
+	if obj.autosense == obj.iface2 {
+		instance.autosense = iface2
+	}
+	instance.iface2 = iface2
+	return &instance
+}
+
+// sharedSocket is the socket on the external interface of a proxy group. The frames it receives are read once and
+// passed to the sharedConns of all instances, which would otherwise each open a socket that receives every frame.
+type sharedSocket struct {
+	network Network
+	iface   string
+	mu      sync.Mutex
+	// current is the socket that was opened last (nil before the first one), which may have been closed since
+	current *socketShare
+}
+
+// open returns a PacketConn on the shared socket of the interface with the given index. A new socket is opened
+// if there is none yet or the interface has been recreated since.
+func (s *sharedSocket) open(index int) (PacketConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &sharedConn{
+		frames: make(chan sharedFrame, 100),
+		closed: make(chan struct{}),
+	}
+	if s.current == nil || s.current.index != index || !s.current.add(c) {
+		conn, err := s.network.Open(s.iface)
+		if err != nil {
+			return nil, err
+		}
+		s.current = &socketShare{conn: conn, iface: s.iface, index: index}
+		s.current.add(c)
+		go s.current.read()
+	}
+	if _, ok := s.current.conn.(FrameWriter); ok {
+		return &sharedFrameConn{c}, nil
+	}
+	return c, nil
+}
+
+// socketShare is a socket that is read by one or more sharedConns. It is closed with the last of them.
+type socketShare struct {
+	conn    PacketConn
+	iface   string
+	index   int
+	mu      sync.Mutex
+	readers []*sharedConn
+	closed  bool
+}
+
+// add passes the frames of the socket to c unless the socket has already been closed
+func (s *socketShare) add(c *sharedConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	c.share = s
+	s.readers = append(s.readers, c)
+	return true
+}
+
+// remove stops passing frames to c and closes the socket if c was its last reader
+func (s *socketShare) remove(c *sharedConn) {
+	s.mu.Lock()
+	for i := range s.readers {
+		if s.readers[i] == c {
+			s.readers = append(s.readers[:i], s.readers[i+1:]...)
+			break
+		}
+	}
+	last := len(s.readers) == 0
+	s.closed = last
+	s.mu.Unlock()
+	if last {
+		_ = s.conn.Close()
+	}
+}
+
+// read passes the frames of the socket to the readers until it is closed. Frames are dropped for readers that
+// do not keep up, as they would be by a socket of their own whose receive buffer is full.
+func (s *socketShare) read() {
+	lengthReader, _ := s.conn.(FrameLengthReader)
+	for {
+		var frame sharedFrame
+		buf := make([]byte, maxFrameLen)
+		var n int
+		if lengthReader != nil {
+			n, frame.length, frame.err = lengthReader.ReadFrameLength(buf)
+		} else {
+			n, frame.err = s.conn.ReadFrame(buf)
+		}
+		frame.data = buf[:n]
+		if errors.Is(frame.err, os.ErrClosed) {
+			return
+		}
+		s.mu.Lock()
+		readers := append([]*sharedConn(nil), s.readers...)
+		s.mu.Unlock()
+		for _, reader := range readers {
+			select {
+			case reader.frames <- frame:
+			default:
+				slog.Debug("Dropping frame for a busy instance", "interface", s.iface)
+			}
+		}
+		if frame.err != nil {
+			return
+		}
+	}
+}
+
+// sharedFrame is a frame read from a shared socket (or the error that ended reading). data must not be modified.
+type sharedFrame struct {
+	data   []byte
+	length int
+	err    error
+}
+
+// sharedConn is the PacketConn of an instance on a shared socket
+type sharedConn struct {
+	share     *socketShare
+	frames    chan sharedFrame
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *sharedConn) ReadFrame(b []byte) (int, error) {
+	n, _, err := c.ReadFrameLength(b)
+	return n, err
+}
+
+func (c *sharedConn) ReadFrameLength(b []byte) (int, int, error) {
+	select {
+	case <-c.closed:
+		return 0, 0, os.ErrClosed
+	case frame := <-c.frames:
+		if frame.err != nil {
+			return 0, 0, frame.err
+		}
+		return copy(b, frame.data), frame.length, nil
+	}
+}
+
+func (c *sharedConn) WritePacket(b []byte, dst []byte) error {
+	return c.share.conn.WritePacket(b, dst)
+}
+
+// Close stops passing frames to c. The socket is closed with its last sharedConn.
+func (c *sharedConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.share.remove(c)
+	})
+	return nil
+}
+
+// sharedFrameConn is a sharedConn on a socket that can send frames
+type sharedFrameConn struct {
+	*sharedConn
+}
+
+func (c *sharedFrameConn) WriteFrame(frame []byte) error {
+	return c.share.conn.(FrameWriter).WriteFrame(frame)
+}
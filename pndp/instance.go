@@ -0,0 +1,289 @@
+package pndp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"pndpd/pndp/ndp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tickInterval is the interval at which TickEvents are sent to the Engine
+const tickInterval = time.Second
+
+// runEngine connects an Engine to the interfaces of a Network and runs it until stopChan is closed.
+// Interfaces that do not exist are waited for, and the sockets are reopened when an interface is recreated.
+// If capture is not nil, all received and sent frames are written to it.
+// If dryRun is not nil, the instance runs in observe mode: packets are counted in dryRun and logged instead of being sent.
+// If sendFrames is set, packets with a known link-layer destination are sent as complete Ethernet frames (see FrameWriter).
+// If shared is not nil, the socket on its interface is shared with other instances instead of opening one of their own.
+func runEngine(network Network, config EngineConfig, capture *Capture, monitorInterfaces bool, dryRun *dryRunCounters, sendFrames bool, shared *sharedSocket, stopWG *sync.WaitGroup, stopChan chan struct{}) {
+	stopWG.Add(1)
+	defer stopWG.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine := NewEngine(config, SystemClock)
+	setDryRun(config.name(), dryRun)
+	events := make(chan Event, 100)
+	changes := newInterfaceChanges()
+
+	startInterfaceMon(network)
+	defer stopInterfaceMon(network)
+
+	// All interfaces are watched for being created and deleted, but address changes are only passed on for these
+	monitored := []string{config.Autosense, config.AdvertiseIface}
+	if monitorInterfaces {
+		monitored = config.Interfaces()
+	}
+	subscriber := subscribeInterfaceMon(changes.add, config.Interfaces()...)
+	defer unsubscribeInterfaceMon(subscriber)
+
+	conns := &interfaceConns{
+		network:    network,
+		sendFrames: sendFrames,
+		shared:     shared,
+		conns:      make(map[string]PacketConn),
+		indexes:    make(map[string]int),
+		macs:       make(map[string]net.HardwareAddr),
+	}
+	defer conns.closeAll()
+	missing := make(map[string]bool)
+	down := make(map[string]bool)
+	// refresh passes the current state of iface to the engine and (re)opens or closes its socket
+	refresh := func(iface string) []Action {
+		niface, err := network.InterfaceByName(iface)
+		var event InterfaceEvent
+		if err == nil {
+			event, err = getInterfaceEvent(network, niface)
+		}
+		if err != nil {
+			if !missing[iface] {
+				fmt.Printf("Waiting for interface %s (%s)\n", iface, err)
+				missing[iface] = true
+			}
+			conns.close(iface)
+			return engine.Handle(ctx, InterfaceEvent{Iface: iface, Missing: true})
+		}
+		reopened := false
+		if iface == config.Iface1 || iface == config.Iface2 {
+			if reopened, err = conns.open(iface, niface, events, stopWG, stopChan); err != nil {
+				slog.Warn("Unable to open interface", "interface", iface, "error", err)
+				return nil
+			}
+		}
+		changed := reopened || event.Down != down[iface]
+		if event.Down != down[iface] {
+			if event.Down {
+				fmt.Printf("Interface %s is down\n", iface)
+			} else if !missing[iface] {
+				fmt.Printf("Interface %s is up\n", iface)
+			}
+			down[iface] = event.Down
+		}
+		if missing[iface] {
+			fmt.Printf("Interface %s is available\n", iface)
+			delete(missing, iface)
+		} else if !changed && !slices.Contains(monitored, iface) {
+			return nil
+		}
+		return engine.Handle(ctx, event)
+	}
+
+	// Actions of the initial events (such as announcements) are performed once the interfaces are open
+	var initialActions []Action
+	for _, iface := range config.Interfaces() {
+		initialActions = append(initialActions, refresh(iface)...)
+	}
+	if config.Announce || config.Defend {
+		if event, ok := getNeighborEvent(network, config.Iface2); ok {
+			initialActions = append(initialActions, engine.Handle(ctx, event)...)
+		}
+	}
+
+	onSend := func(action SendAction) {
+		captureSent(capture, time.Now(), conns.macs[action.Iface], action, dryRun != nil)
+	}
+	executeActions(initialActions, dryRun, conns.send, onSend)
+
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		var event Event
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			event = TickEvent{}
+		case <-changes.wake:
+			for _, iface := range changes.take() {
+				executeActions(refresh(iface), dryRun, conns.send, onSend)
+			}
+			continue
+		case event = <-events:
+		}
+		actions := engine.Handle(ctx, event)
+		countDrops(config.name(), actions)
+		if packet, ok := event.(PacketEvent); ok {
+			now := time.Now()
+			captureReceived(capture, now, packet.Iface, packet.Frame, packet.Length, actions, dryRun != nil)
+			traceReceived(config.name(), now, packet.Iface, packet.Frame, actions, dryRun != nil)
+		}
+		executeActions(actions, dryRun, conns.send, onSend)
+	}
+}
+
+// interfaceConns holds the sockets of the interfaces of an instance that currently exist
+type interfaceConns struct {
+	network    Network
+	sendFrames bool
+	shared     *sharedSocket
+	conns      map[string]PacketConn
+	// indexes holds the index of the interface a socket was opened on, so that recreated interfaces are noticed
+	indexes map[string]int
+	macs    map[string]net.HardwareAddr
+}
+
+// open opens a socket on iface unless one is already open on the same interface. A socket on a previous
+// interface of the same name is closed. It returns whether a socket was opened.
+func (c *interfaceConns) open(iface string, niface *net.Interface, events chan Event, stopWG *sync.WaitGroup, stopChan chan struct{}) (bool, error) {
+	c.macs[iface] = niface.HardwareAddr
+	if _, ok := c.conns[iface]; ok && c.indexes[iface] == niface.Index {
+		return false, nil
+	}
+	c.close(iface)
+	var conn PacketConn
+	var err error
+	if c.shared != nil && c.shared.iface == iface {
+		conn, err = c.shared.open(niface.Index)
+	} else {
+		conn, err = c.network.Open(iface)
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, ok := conn.(FrameWriter); c.sendFrames && !ok {
+		slog.Warn("Unable to send frames, packets are sent through the kernel", "interface", iface)
+	}
+	c.conns[iface] = conn
+	c.indexes[iface] = niface.Index
+	go listen(conn, iface, events, stopWG, stopChan)
+	return true, nil
+}
+
+// close closes the socket on iface (if any)
+func (c *interfaceConns) close(iface string) {
+	if conn, ok := c.conns[iface]; ok {
+		_ = conn.Close()
+		delete(c.conns, iface)
+	}
+}
+
+func (c *interfaceConns) closeAll() {
+	for iface := range c.conns {
+		c.close(iface)
+	}
+}
+
+// send sends the packet of action on the socket of its interface
+func (c *interfaceConns) send(action SendAction) {
+	conn, ok := c.conns[action.Iface]
+	if !ok {
+		slog.Warn("Not sending packet on a missing interface", "interface", action.Iface)
+		return
+	}
+	if w, ok := conn.(FrameWriter); ok && c.sendFrames {
+		if dstMAC := action.LinkLayerDst(); dstMAC != nil {
+			sendNDPFrame(w, c.macs[action.Iface], dstMAC, action.Packet)
+			return
+		}
+	}
+	sendNDPPacket(conn, action.Packet, action.Dst)
+}
+
+func getInterfaceEvent(network Network, niface *net.Interface) (InterfaceEvent, error) {
+	addrs, err := network.Addrs(niface)
+	if err != nil {
+		return InterfaceEvent{}, err
+	}
+	event := InterfaceEvent{
+		Iface:        niface.Name,
+		HardwareAddr: niface.HardwareAddr,
+		Addrs:        addrs,
+		Down:         niface.Flags&net.FlagUp == 0 || niface.Flags&net.FlagRunning == 0,
+	}
+	if reader, ok := network.(ForwardingReader); ok {
+		if event.Forwarding, err = reader.Forwarding(niface); err != nil {
+			slog.Warn("Unable to determine whether forwarding is enabled", "interface", niface.Name, "error", err)
+		}
+	}
+	return event, nil
+}
+
+// getNeighborEvent reports the neighbors of iface if the Network knows them
+func getNeighborEvent(network Network, iface string) (NeighborEvent, bool) {
+	lister, ok := network.(NeighborLister)
+	if !ok {
+		return NeighborEvent{}, false
+	}
+	niface, err := network.InterfaceByName(iface)
+	if err != nil {
+		return NeighborEvent{}, false
+	}
+	neighbors, err := lister.Neighbors(niface)
+	if err != nil {
+		slog.Warn("Unable to obtain the neighbors", "interface", iface, "error", err)
+		return NeighborEvent{}, false
+	}
+	return NeighborEvent{Iface: iface, Addrs: neighbors}, true
+}
+
+// executeActions performs the actions of an Engine. Packets are sent with send and onSend is called for every packet that is sent.
+// If dryRun is not nil, packets are counted and logged instead of being sent, and onSend is still called for them.
+func executeActions(actions []Action, dryRun *dryRunCounters, send func(SendAction), onSend func(SendAction)) {
+	for _, a := range actions {
+		switch action := a.(type) {
+		case SendAction:
+			if dryRun != nil {
+				dryRun.count(action.Packet)
+				slog.Info("Would send packet", "dryRun", true, "interface", action.Iface, "dest", ipValue{action.Dst}, "packet", hexValue{action.Packet})
+			} else {
+				slog.Debug("Sending packet", "interface", action.Iface, "dest", ipValue{action.Dst}, "packet", hexValue{action.Packet})
+				send(action)
+			}
+			onSend(action)
+		case InstallAction:
+			slog.Debug("Waiting for advertisement", "dryRun", dryRun != nil, "interface", action.Iface, "targetIP", ipValue{action.Target}, "askedBy", ipValue{action.AskedBy})
+		case EmitAction:
+			slog.Debug(action.Message, "dryRun", dryRun != nil, "interface", action.Iface, "reason", action.Reason, "ip", ipValue{action.Target})
+		}
+	}
+}
+
+// dryRunCounters counts the packets an instance in observe mode would have sent
+type dryRunCounters struct {
+	solicitations  atomic.Uint64
+	advertisements atomic.Uint64
+}
+
+func (c *dryRunCounters) count(packet []byte) {
+	if len(packet) <= 40 {
+		return
+	}
+	switch packet[40] {
+	case ndp.TypeNeighborSolicitation:
+		c.solicitations.Add(1)
+	case ndp.TypeNeighborAdvertisement:
+		c.advertisements.Add(1)
+	}
+}
+
+func (c *dryRunCounters) String() string {
+	return fmt.Sprintf("%d solicitations and %d advertisements would have been sent (dry-run)", c.solicitations.Load(), c.advertisements.Load())
+}
@@ -34,15 +34,9 @@ func (filter bpfFilter) ApplyTo(fd int) (err error) {
 	return nil
 }
 
-func setPromisc(fd int, iface string, enable bool) {
-	iFace, err := net.InterfaceByName(iface)
-	if err != nil {
-		showFatalError(err.Error())
-		return
-	}
-
+func setPromisc(fd int, iface *net.Interface, enable bool) error {
 	mReq := unix.PacketMreq{
-		Ifindex: int32(iFace.Index),
+		Ifindex: int32(iface.Index),
 		Type:    unix.PACKET_MR_PROMISC,
 	}
 
@@ -53,19 +47,12 @@ func setPromisc(fd int, iface string, enable bool) {
 		opt = unix.PACKET_DROP_MEMBERSHIP
 	}
 
-	err = unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, opt, &mReq)
-	if err != nil {
-		showFatalError(err.Error())
-	}
+	return unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, opt, &mReq)
 }
 
-func selectSourceIP(iface *net.Interface) (gua []byte, ula []byte) {
+func selectSourceIP(interfaceAddresses []net.Addr) (gua []byte, ula []byte) {
 	gua = emptyIpv6
 	ula = emptyIpv6
-	interfaceAddresses, err := iface.Addrs()
-	if err != nil {
-		return gua, ula
-	}
 
 	var haveUla = false
 	var haveGua = false
@@ -102,12 +89,8 @@ func selectSourceIP(iface *net.Interface) (gua []byte, ula []byte) {
 	return gua, ula
 }
 
-func getInterfaceNetworkList(iface *net.Interface) []*net.IPNet {
+func getInterfaceNetworkList(autoifaceaddrs []net.Addr) []*net.IPNet {
 	filter := make([]*net.IPNet, 0)
-	autoifaceaddrs, err := iface.Addrs()
-	if err != nil {
-		return filter
-	}
 	for _, l := range autoifaceaddrs {
 		ipNet, ok := l.(*net.IPNet)
 		if !ok {
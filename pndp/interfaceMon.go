@@ -1,163 +1,251 @@
 package pndp
 
 import (
+	"log/slog"
 	"net"
+	"slices"
 	"sync"
+	"time"
 )
 
+// interfaceMonitor receives the netlink events of a network namespace
+type interfaceMonitor struct {
+	count int
+	wg    sync.WaitGroup
+	stop  chan interface{}
+}
+
 var (
-	interfaceMonSync    sync.Mutex
-	interfaceMonRunning = false
-	startCount          = 0
-	wg                  sync.WaitGroup
-	s                   chan interface{}
-	u                   chan *interfaceAddressUpdate
+	interfaceMonSync sync.Mutex
+	// interfaceMons holds the running monitors by the name or path of their namespace ("" for the own namespace)
+	interfaceMons = make(map[string]*interfaceMonitor)
 )
 
-func startInterfaceMon() {
+// startInterfaceMon starts the monitor of the own namespace and the monitors of the namespaces that network uses
+func startInterfaceMon(network Network) {
 	interfaceMonSync.Lock()
 	defer interfaceMonSync.Unlock()
-	if !interfaceMonRunning {
-		interfaceMonRunning = true
-		u = make(chan *interfaceAddressUpdate, 10)
-		s = make(chan interface{})
-		err := getInterfaceUpdates(u, s)
-		if err != nil {
-			showFatalError(err.Error())
+	for _, netns := range monitoredNamespaces(network) {
+		mon, ok := interfaceMons[netns]
+		if !ok {
+			mon = &interfaceMonitor{stop: make(chan interface{})}
+			interfaceMons[netns] = mon
+			mon.wg.Add(1)
+			if netns == "" {
+				u := make(chan *interfaceAddressUpdate, 10)
+				err := getInterfaceUpdates(u, mon.stop)
+				if err != nil {
+					showFatalError(err.Error())
+				}
+				go func() {
+					defer mon.wg.Done()
+					mon.getUpdates(u, net.InterfaceByIndex, "")
+				}()
+			} else {
+				go mon.watchNamespace(netns)
+			}
 		}
-		go getUpdates()
+		mon.count++
 	}
-	startCount++
 }
 
-func stopInterfaceMon() {
+func stopInterfaceMon(network Network) {
 	interfaceMonSync.Lock()
 	defer interfaceMonSync.Unlock()
-	startCount--
-	if interfaceMonRunning && startCount <= 0 {
-		if s != nil {
-			close(s)
-			wg.Wait()
-			interfaceMonRunning = false
+	for _, netns := range monitoredNamespaces(network) {
+		mon, ok := interfaceMons[netns]
+		if !ok {
+			continue
+		}
+		mon.count--
+		if mon.count <= 0 {
+			close(mon.stop)
+			mon.wg.Wait()
+			delete(interfaceMons, netns)
+		}
+	}
+}
+
+func monitoredNamespaces(network Network) []string {
+	result := []string{""}
+	if n, ok := network.(*namespacedNetwork); ok {
+		result = append(result, n.namespaces...)
+	}
+	return result
+}
+
+// watchNamespace subscribes to the netlink events of another namespace (a name or path, see NetnsPath) and notifies
+// the subscribers of the namespaced names of its interfaces (see namespacedName). The subscription is retried until the
+// namespace exists (and after errors), and the subscribers of all of its interfaces are notified whenever it succeeds,
+// as events may have been missed in the meantime.
+func (mon *interfaceMonitor) watchNamespace(netns string) {
+	defer mon.wg.Done()
+	netnsPath := NetnsPath(netns)
+	interfaceByIndex := func(index int) (iface *net.Interface, err error) {
+		err = inNetns(netnsPath, func() error {
+			iface, err = net.InterfaceByIndex(index)
+			return err
+		})
+		return iface, err
+	}
+	failed := false
+	for {
+		u := make(chan *interfaceAddressUpdate, 10)
+		var ifaces []net.Interface
+		err := inNetns(netnsPath, func() error {
+			if err := getInterfaceUpdates(u, mon.stop); err != nil {
+				return err
+			}
+			ifaces, _ = net.Interfaces()
+			return nil
+		})
+		if err != nil {
+			if !failed {
+				slog.Warn("Unable to monitor the interfaces, retrying", "netns", netns, "error", err)
+				failed = true
+			}
+		} else {
+			if failed {
+				slog.Info("Monitoring the interfaces", "netns", netns)
+				failed = false
+			}
+			for _, iface := range ifaces {
+				notifyInterfaceMon(namespacedName(iface.Name, netns))
+			}
+			mon.getUpdates(u, interfaceByIndex, netns)
+		}
+		select {
+		case <-mon.stop:
+			return
+		case <-time.After(time.Second):
 		}
 	}
 }
 
-func getUpdates() {
-	wg.Add(1)
+// getUpdates notifies the subscribers of the interfaces of the updates received on u until it is closed.
+// interfaceByIndex looks up the interfaces in the namespace netns of the monitor ("" for the own namespace).
+func (mon *interfaceMonitor) getUpdates(u chan *interfaceAddressUpdate, interfaceByIndex func(int) (*net.Interface, error), netns string) {
 	for {
 		update := <-u
 		if update == nil {
 			//channel closed
-			wg.Done()
 			return
 		}
-		if update.NetworkFamily != IPv6 {
+		if update.Event != LinkChange && update.NetworkFamily != IPv6 {
 			continue
 		}
-		iface, err := net.InterfaceByIndex(update.InterfaceIndex)
-		if err != nil {
+		if update.InterfaceName != "" {
+			notifyInterfaceMon(namespacedName(update.InterfaceName, netns))
 			continue
 		}
-
-		srcIP, srcIPUla := selectSourceIP(iface)
-		monMutex.Lock()
-
-		for i := range monInterfaceList {
-			if monInterfaceList[i].iface.Name == iface.Name {
-				oldMonIface := monInterfaceList[i]
-				oldMonIface.sourceIP = srcIP
-				oldMonIface.sourceIPULA = srcIPUla
-				if oldMonIface.autosense {
-					oldMonIface.networks = getInterfaceNetworkList(iface)
-				}
-				break
-			}
+		iface, err := interfaceByIndex(update.InterfaceIndex)
+		if err != nil {
+			continue
 		}
-		monMutex.Unlock()
+		notifyInterfaceMon(namespacedName(iface.Name, netns))
 	}
 }
 
-type monInterface struct {
-	addCount    int
-	sourceIP    []byte
-	sourceIPULA []byte
-	networks    []*net.IPNet
-	iface       *net.Interface
-	autosense   bool
+type monSubscriber struct {
+	ifaces []string
+	// pattern matches the names of the interfaces of the subscriber (see path.Match) if it is not empty
+	pattern  string
+	callback func(iface string)
 }
 
 var (
-	monInterfaceList = make([]*monInterface, 0)
-	monMutex         sync.RWMutex
+	monSubscribers = make([]*monSubscriber, 0)
+	monMutex       sync.RWMutex
 )
 
-func addInterfaceToMon(iface string, autosense bool) {
-	if iface == "" {
-		return
-	}
+// subscribeInterfaceMon calls callback with the name of the interface whenever one of ifaces is created or deleted,
+// or its IPv6 addresses or link attributes (such as the hardware address) change.
+// The callback must not block (see interfaceChanges).
+func subscribeInterfaceMon(callback func(iface string), ifaces ...string) *monSubscriber {
 	monMutex.Lock()
 	defer monMutex.Unlock()
+	subscriber := &monSubscriber{
+		ifaces:   ifaces,
+		callback: callback,
+	}
+	monSubscribers = append(monSubscribers, subscriber)
+	return subscriber
+}
 
-	niface, err := net.InterfaceByName(iface)
-	if err != nil {
-		showFatalError(err.Error())
-		return
+// subscribeInterfaceMonPattern is like subscribeInterfaceMon for all interfaces whose name matches pattern (see matchInterface)
+func subscribeInterfaceMonPattern(callback func(iface string), pattern string) *monSubscriber {
+	monMutex.Lock()
+	defer monMutex.Unlock()
+	subscriber := &monSubscriber{
+		pattern:  pattern,
+		callback: callback,
 	}
+	monSubscribers = append(monSubscribers, subscriber)
+	return subscriber
+}
 
-	for i := range monInterfaceList {
-		if monInterfaceList[i].iface.Name == niface.Name {
-			oldMonIface := monInterfaceList[i]
-			if autosense {
-				oldMonIface.autosense = true
-			}
-			oldMonIface.addCount++
+func unsubscribeInterfaceMon(subscriber *monSubscriber) {
+	monMutex.Lock()
+	defer monMutex.Unlock()
+	for i := range monSubscribers {
+		if monSubscribers[i] == subscriber {
+			monSubscribers = append(monSubscribers[:i], monSubscribers[i+1:]...)
 			return
 		}
 	}
-	newMonIface := &monInterface{
-		addCount:  1,
-		autosense: autosense,
-		iface:     niface,
-	}
-	newMonIface.sourceIP, newMonIface.sourceIPULA = selectSourceIP(niface)
-	newMonIface.networks = getInterfaceNetworkList(niface)
+}
 
-	monInterfaceList = append(monInterfaceList, newMonIface)
+// interfaceChanges collects the interfaces that subscribers are notified of until they are taken. Its add method is a
+// callback that never blocks, as the notifications of an interface are coalesced while it is pending.
+type interfaceChanges struct {
+	mu      sync.Mutex
+	pending []string
+	// wake receives a value when interfaces become pending
+	wake chan struct{}
 }
 
-func removeInterfaceFromMon(iface string) {
-	if iface == "" {
-		return
-	}
-	monMutex.Lock()
-	defer monMutex.Unlock()
-	niface, err := net.InterfaceByName(iface)
-	if err != nil {
-		showFatalError(err.Error())
+func newInterfaceChanges() *interfaceChanges {
+	return &interfaceChanges{wake: make(chan struct{}, 1)}
+}
+
+func (c *interfaceChanges) add(iface string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.Contains(c.pending, iface) {
 		return
 	}
-	for i := range monInterfaceList {
-		if monInterfaceList[i].iface.Name == niface.Name {
-			oldMonIface := monInterfaceList[i]
-			oldMonIface.addCount--
-			if oldMonIface.addCount <= 0 {
-				monInterfaceList[i] = monInterfaceList[len(monInterfaceList)-1]
-				monInterfaceList = monInterfaceList[:len(monInterfaceList)-1]
-			}
-			return
-		}
+	c.pending = append(c.pending, iface)
+	select {
+	case c.wake <- struct{}{}:
+	default:
 	}
 }
 
-func getInterfaceInfo(iface *net.Interface) *monInterface {
-	ifaceName := iface.Name
+// take returns the pending interfaces in the order they have been added
+func (c *interfaceChanges) take() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := c.pending
+	c.pending = nil
+	return pending
+}
+
+// notifyInterfaceMon informs all subscribers of an interface that its addresses have changed
+func notifyInterfaceMon(ifaceName string) {
 	monMutex.RLock()
 	defer monMutex.RUnlock()
-	for i := range monInterfaceList {
-		if monInterfaceList[i].iface.Name == ifaceName {
-			return monInterfaceList[i]
+	for _, subscriber := range monSubscribers {
+		if subscriber.pattern != "" {
+			if matchInterface(subscriber.pattern, ifaceName) {
+				subscriber.callback(ifaceName)
+			}
+			continue
+		}
+		for _, name := range subscriber.ifaces {
+			if name == ifaceName {
+				subscriber.callback(ifaceName)
+				break
+			}
 		}
 	}
-	return nil
 }
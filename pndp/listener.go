@@ -3,86 +3,26 @@ package pndp
 import (
 	"bytes"
 	"errors"
-	"golang.org/x/net/bpf"
 	"log/slog"
-	"net"
 	"os"
 	"sync"
-	"syscall"
 )
 
-func listen(iface string, responder chan *ndpRequest, requestType ndpType, stopWG *sync.WaitGroup, stopChan chan struct{}) {
+// listen reads frames from conn and passes them to the events channel until conn is closed
+func listen(conn PacketConn, iface string, events chan Event, stopWG *sync.WaitGroup, stopChan chan struct{}) {
 	stopWG.Add(1)
 	defer stopWG.Done()
 
-	niface, err := net.InterfaceByName(iface)
-	if err != nil {
-		showFatalError(err.Error())
-	}
-
-	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, htons(syscall.ETH_P_IPV6))
-	if err != nil {
-		showFatalError("Failed setting up listener on interface", iface)
-	}
-
-	slog.Debug("Obtained fd", "fd", fd)
-	err = syscall.Bind(fd, &syscall.SockaddrLinklayer{
-		Protocol: htons16(syscall.ETH_P_IPV6),
-		Ifindex:  niface.Index,
-	})
-	if err != nil {
-		showFatalError(err.Error())
-	}
-	slog.Debug("Bound to interface", "fd", fd, "interface", iface)
-
-	setPromisc(fd, iface, true)
-
-	var protocolNo uint32
-	if requestType == ndpSol {
-		//Neighbor Solicitation
-		protocolNo = 0x87
-	} else {
-		//Neighbor Advertisement
-		protocolNo = 0x88
-	}
-	var f bpfFilter = []bpf.Instruction{
-		// Load "EtherType" field from the ethernet header.
-		bpf.LoadAbsolute{Off: 12, Size: 2},
-		// Jump to the drop packet instruction if EtherType is not IPv6.
-		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 0x86dd, SkipTrue: 5},
-		// Load "Next Header" field from IPV6 header.
-		bpf.LoadAbsolute{Off: 20, Size: 1},
-		// Jump to the drop packet instruction if Next Header is not ICMPv6.
-		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 0x3a, SkipTrue: 3},
-		// Load "Type" field from ICMPv6 header.
-		bpf.LoadAbsolute{Off: 54, Size: 1},
-		// Jump to the drop packet instruction if Type is not Neighbor Solicitation / Advertisement.
-		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: protocolNo, SkipTrue: 1},
-		// Verdict is: send up to 86 bytes of the packet to userspace.
-		bpf.RetConstant{Val: 86},
-		// Verdict is: "ignore packet."
-		bpf.RetConstant{Val: 0},
-	}
-
-	err = f.ApplyTo(fd)
-	if err != nil {
-		showFatalError(err.Error())
-	}
-
-	err = syscall.SetNonblock(fd, true)
-	if err != nil {
-		slog.Warn("Failed setting nonblock", "fd", fd)
-	}
-
-	fdN := os.NewFile(uintptr(fd), "")
-	go func() {
-		<-stopChan
-		_ = fdN.Close()
-	}()
-
+	lengthReader, _ := conn.(FrameLengthReader)
 	for {
-		buf := make([]byte, 86)
-		numRead, err := fdN.Read(buf)
+		buf := make([]byte, maxFrameLen)
+		var numRead, length int
+		var err error
+		if lengthReader != nil {
+			numRead, length, err = lengthReader.ReadFrameLength(buf)
+		} else {
+			numRead, err = conn.ReadFrame(buf)
+		}
 		if err != nil {
 			if errors.Is(err, os.ErrClosed) {
 				return
@@ -90,39 +30,49 @@ func listen(iface string, responder chan *ndpRequest, requestType ndpType, stopW
 			showFatalError(err.Error())
 		}
 
-		pLogger := slog.Default().With("packet", hexValue{buf[:numRead]})
-
-		if numRead < 78 {
-			pLogger.Debug("Dropping packet since it does not meet the minimum length requirement")
-			continue
-		}
-
-		if bytes.Equal(buf[6:12], niface.HardwareAddr) {
-			pLogger.Debug("Dropping packet from ourselves")
-			continue
+		select {
+		case <-stopChan:
+			return
+		case events <- PacketEvent{Iface: iface, Frame: buf[:numRead], Length: length}:
 		}
+	}
+}
 
-		if requestType == ndpAdv {
-			if buf[58] == 0x0 {
-				pLogger.Debug("Dropping advertisement packet without any NDP flags set")
-				continue
-			}
-		}
+// parseFrame decodes an Ethernet frame carrying a Neighbor Solicitation or Advertisement.
+// If the frame is to be dropped, the returned request is nil and the reason is given.
+func parseFrame(iface string, frame []byte, ownMAC []byte) (req *ndpRequest, reason string, message string) {
+	if len(frame) < 78 || !isNDPFrame(frame) {
+		return nil, ReasonMalformed, "Dropping packet since it does not meet the minimum length requirement"
+	}
 
-		pLogger.Debug("Got packet", "interface", iface, "type", requestType,
-			"source MAC", macValue{buf[6:12]},
-			"source IP", ipValue{buf[22:38]},
-			"destination IP", ipValue{buf[38:54]},
-			"requested IP", ipValue{buf[62:78]},
-		)
+	if bytes.Equal(frame[6:12], ownMAC) {
+		return nil, ReasonOwnPacket, "Dropping packet from ourselves"
+	}
 
-		responder <- &ndpRequest{
-			requestType:    requestType,
-			srcIP:          buf[22:38],
-			dstIP:          buf[38:54],
-			answeringForIP: buf[62:78],
-			payload:        buf[54:numRead],
-			sourceIface:    iface,
+	var requestType ndpType
+	if frame[54] == 0x87 {
+		requestType = ndpSol
+	} else {
+		requestType = ndpAdv
+		if frame[58] == 0x0 {
+			return nil, ReasonNoFlags, "Dropping advertisement packet without any NDP flags set"
 		}
 	}
+
+	slog.Debug("Got packet", "interface", iface, "type", requestType,
+		"source MAC", macValue{frame[6:12]},
+		"source IP", ipValue{frame[22:38]},
+		"destination IP", ipValue{frame[38:54]},
+		"requested IP", ipValue{frame[62:78]},
+	)
+
+	return &ndpRequest{
+		requestType:    requestType,
+		srcIP:          frame[22:38],
+		dstIP:          frame[38:54],
+		answeringForIP: frame[62:78],
+		srcMAC:         frame[6:12],
+		payload:        frame[54:],
+		sourceIface:    iface,
+	}, "", ""
 }
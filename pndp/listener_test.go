@@ -0,0 +1,46 @@
+package pndp
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+)
+
+func FuzzParseFrame(f *testing.F) {
+	for _, tc := range goldenPackets {
+		packet, err := buildNDPPacket(net.ParseIP(tc.srcIP), net.ParseIP(tc.dstIP), net.ParseIP(tc.target), tc.mac, tc.ndpType)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(append(append([]byte(tc.linkDest), testHostMAC...), append([]byte{0x86, 0xdd}, packet...)...))
+	}
+	f.Add([]byte{})
+	f.Add(make([]byte, maxFrameLen))
+
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		original := append([]byte(nil), frame...)
+		req, reason, _ := parseFrame("ext", frame, testExtMAC)
+		if (req == nil) == (reason == "") {
+			t.Fatalf("Expected either a request or a reason, but got %v and %q", req, reason)
+		}
+		if req != nil && len(req.answeringForIP) != 16 {
+			t.Fatalf("Decoded target %x has the wrong length", req.answeringForIP)
+		}
+
+		// Whole frames (as received by listen) must not panic the engine either
+		for _, config := range []EngineConfig{
+			{Type: ProxyInstance, Iface1: "ext", Iface2: "int", Autosense: "int"},
+			{Type: ResponderInstance, Iface1: "ext"},
+		} {
+			engine := NewEngine(config, &fakeClock{})
+			engine.Handle(context.Background(), InterfaceEvent{Iface: "ext", HardwareAddr: testExtMAC, Addrs: []net.Addr{mustParseIfaceIP("fd00::1/64")}})
+			engine.Handle(context.Background(), InterfaceEvent{Iface: "int", HardwareAddr: testIntMAC, Addrs: []net.Addr{mustParseIfaceIP("fd01::1/64")}})
+			engine.Handle(context.Background(), PacketEvent{Iface: "ext", Frame: frame})
+			engine.Handle(context.Background(), PacketEvent{Iface: "int", Frame: frame})
+		}
+		if !bytes.Equal(original, frame) {
+			t.Fatalf("Frame was modified")
+		}
+	})
+}
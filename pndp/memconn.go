@@ -0,0 +1,328 @@
+package pndp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MemoryNetwork is an in-memory Network. Frames injected into one of its links are received by
+// every instance that uses the link and the packets that instances send are recorded per link.
+// This allows exercising the proxy and responder logic without raw sockets or root privileges.
+type MemoryNetwork struct {
+	mu    sync.Mutex
+	links map[string]*MemoryLink
+	// created is the number of links that have been added, including removed ones
+	created int
+}
+
+// MemoryLink is a network interface of a MemoryNetwork
+type MemoryLink struct {
+	mu         sync.Mutex
+	iface      *net.Interface
+	addrs      []net.Addr
+	neighbors  []net.IP
+	forwarding bool
+	conns      []*memoryConn
+	sent       chan MemoryPacket
+}
+
+// MemoryPacket is a packet sent by an instance on a MemoryLink
+type MemoryPacket struct {
+	// Packet is the IPv6 packet including the IPv6 header
+	Packet []byte
+	Dst    net.IP
+	// DstMAC is the Ethernet destination of packets that were sent as complete frames, nil otherwise
+	DstMAC net.HardwareAddr
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		links: make(map[string]*MemoryLink),
+	}
+}
+
+// AddLink creates a new link with the given hardware address and IP addresses assigned to it.
+// Instances that wait for the link are notified.
+func (n *MemoryNetwork) AddLink(name string, mac net.HardwareAddr, addrs ...*net.IPNet) *MemoryLink {
+	n.mu.Lock()
+	defer notifyInterfaceMon(name)
+	defer n.mu.Unlock()
+	n.created++
+	link := &MemoryLink{
+		iface: &net.Interface{
+			Index:        -n.created, // Never collides with the index of a real interface
+			MTU:          1500,
+			Name:         name,
+			HardwareAddr: mac,
+			Flags:        net.FlagUp | net.FlagRunning | net.FlagBroadcast | net.FlagMulticast,
+		},
+		sent: make(chan MemoryPacket, 100),
+	}
+	link.setAddrs(addrs)
+	n.links[name] = link
+	return link
+}
+
+// RemoveLink deletes a link. The PacketConns open on it are closed and instances are notified.
+func (n *MemoryNetwork) RemoveLink(name string) {
+	n.mu.Lock()
+	link, ok := n.links[name]
+	delete(n.links, name)
+	n.mu.Unlock()
+	if !ok {
+		return
+	}
+	link.mu.Lock()
+	conns := append([]*memoryConn(nil), link.conns...)
+	link.mu.Unlock()
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	notifyInterfaceMon(name)
+}
+
+func (n *MemoryNetwork) getLink(name string) (*MemoryLink, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	link, ok := n.links[name]
+	if !ok {
+		return nil, fmt.Errorf("no such memory link \"%s\"", name)
+	}
+	return link, nil
+}
+
+func (n *MemoryNetwork) Open(iface string) (PacketConn, error) {
+	return n.open(iface, false)
+}
+
+// OpenComplete opens a PacketConn whose frames are not truncated to maxFrameLen
+func (n *MemoryNetwork) OpenComplete(iface string) (PacketConn, error) {
+	return n.open(iface, true)
+}
+
+func (n *MemoryNetwork) open(iface string, complete bool) (PacketConn, error) {
+	link, err := n.getLink(iface)
+	if err != nil {
+		return nil, err
+	}
+	conn := &memoryConn{
+		link:     link,
+		frames:   make(chan []byte, 100),
+		closed:   make(chan struct{}),
+		complete: complete,
+	}
+	link.mu.Lock()
+	link.conns = append(link.conns, conn)
+	link.mu.Unlock()
+	return conn, nil
+}
+
+func (n *MemoryNetwork) InterfaceByName(name string) (*net.Interface, error) {
+	link, err := n.getLink(name)
+	if err != nil {
+		return nil, err
+	}
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	return link.iface, nil
+}
+
+// Interfaces returns the links of the network ordered by name
+func (n *MemoryNetwork) Interfaces() ([]net.Interface, error) {
+	n.mu.Lock()
+	links := make([]*MemoryLink, 0, len(n.links))
+	for _, link := range n.links {
+		links = append(links, link)
+	}
+	n.mu.Unlock()
+	result := make([]net.Interface, 0, len(links))
+	for _, link := range links {
+		link.mu.Lock()
+		result = append(result, *link.iface)
+		link.mu.Unlock()
+	}
+	slices.SortFunc(result, func(a, b net.Interface) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result, nil
+}
+
+func (n *MemoryNetwork) Addrs(iface *net.Interface) ([]net.Addr, error) {
+	link, err := n.getLink(iface.Name)
+	if err != nil {
+		return nil, err
+	}
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	return link.addrs, nil
+}
+
+func (n *MemoryNetwork) Neighbors(iface *net.Interface) ([]net.IP, error) {
+	link, err := n.getLink(iface.Name)
+	if err != nil {
+		return nil, err
+	}
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	return link.neighbors, nil
+}
+
+// Forwarding reports whether forwarding was enabled on the link with SetForwarding
+func (n *MemoryNetwork) Forwarding(iface *net.Interface) (bool, error) {
+	link, err := n.getLink(iface.Name)
+	if err != nil {
+		return false, err
+	}
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	return link.forwarding, nil
+}
+
+// SetForwarding sets whether IPv6 forwarding is enabled on the link
+func (l *MemoryLink) SetForwarding(forwarding bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.forwarding = forwarding
+}
+
+// SetHardwareAddr changes the hardware address of the link and notifies instances about the change
+func (l *MemoryLink) SetHardwareAddr(mac net.HardwareAddr) {
+	l.mu.Lock()
+	iface := *l.iface
+	iface.HardwareAddr = mac
+	l.iface = &iface
+	l.mu.Unlock()
+	notifyInterfaceMon(iface.Name)
+}
+
+// SetRunning sets the operational state of the link and notifies instances about the change
+func (l *MemoryLink) SetRunning(running bool) {
+	l.mu.Lock()
+	iface := *l.iface
+	iface.Flags &^= net.FlagRunning
+	if running {
+		iface.Flags |= net.FlagRunning
+	}
+	l.iface = &iface
+	l.mu.Unlock()
+	notifyInterfaceMon(iface.Name)
+}
+
+// SetNeighbors replaces the addresses of the neighbors that are reachable through the link
+func (l *MemoryLink) SetNeighbors(neighbors ...net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.neighbors = neighbors
+}
+
+// Inject delivers an Ethernet frame to every PacketConn open on the link, as if it was received on the interface
+func (l *MemoryLink) Inject(frame []byte) {
+	if !isNDPFrame(frame) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		select {
+		case <-conn.closed:
+		case conn.frames <- append([]byte(nil), frame...):
+		}
+	}
+}
+
+// Sent returns the channel on which all packets sent on the link are delivered
+func (l *MemoryLink) Sent() <-chan MemoryPacket {
+	return l.sent
+}
+
+// SetAddrs replaces the IP addresses assigned to the link and notifies instances about the change
+func (l *MemoryLink) SetAddrs(addrs ...*net.IPNet) {
+	l.mu.Lock()
+	l.setAddrs(addrs)
+	name := l.iface.Name
+	l.mu.Unlock()
+	notifyInterfaceMon(name)
+}
+
+func (l *MemoryLink) setAddrs(addrs []*net.IPNet) {
+	l.addrs = make([]net.Addr, len(addrs))
+	for i := range addrs {
+		l.addrs[i] = addrs[i]
+	}
+}
+
+type memoryConn struct {
+	link      *MemoryLink
+	frames    chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+	// complete is set for conns whose frames are not truncated (see CompleteFrameOpener)
+	complete bool
+}
+
+func (c *memoryConn) ReadFrame(b []byte) (int, error) {
+	n, _, err := c.ReadFrameLength(b)
+	return n, err
+}
+
+// ReadFrameLength truncates frames to maxFrameLen like the BPF filter of the listening sockets
+func (c *memoryConn) ReadFrameLength(b []byte) (int, int, error) {
+	select {
+	case <-c.closed:
+		return 0, 0, os.ErrClosed
+	case frame := <-c.frames:
+		if c.complete {
+			return copy(b, frame), len(frame), nil
+		}
+		return copy(b, frame[:min(len(frame), maxFrameLen)]), len(frame), nil
+	}
+}
+
+func (c *memoryConn) WritePacket(b []byte, dst []byte) error {
+	if len(dst) != 16 {
+		return errors.New("malformed IP")
+	}
+	select {
+	case <-c.closed:
+		return os.ErrClosed
+	case c.link.sent <- MemoryPacket{Packet: append([]byte(nil), b...), Dst: append(net.IP(nil), dst...)}:
+		return nil
+	}
+}
+
+func (c *memoryConn) WriteFrame(frame []byte) error {
+	if len(frame) < 54 {
+		return errors.New("frame too short")
+	}
+	packet := MemoryPacket{
+		Packet: append([]byte(nil), frame[14:]...),
+		Dst:    append(net.IP(nil), frame[38:54]...),
+		DstMAC: append(net.HardwareAddr(nil), frame[:6]...),
+	}
+	select {
+	case <-c.closed:
+		return os.ErrClosed
+	case c.link.sent <- packet:
+		return nil
+	}
+}
+
+func (c *memoryConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.link.mu.Lock()
+		defer c.link.mu.Unlock()
+		for i := range c.link.conns {
+			if c.link.conns[i] == c {
+				c.link.conns = append(c.link.conns[:i], c.link.conns[i+1:]...)
+				break
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,262 @@
+// Package ndp encodes and decodes IPv6 Neighbor Discovery (RFC 4861) messages.
+//
+// Messages are the ICMPv6 part of a packet. Packet additionally covers the IPv6 header and the checksum.
+package ndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ICMPv6 types of the Neighbor Discovery messages
+const (
+	TypeRouterSolicitation    uint8 = 133
+	TypeRouterAdvertisement   uint8 = 134
+	TypeNeighborSolicitation  uint8 = 135
+	TypeNeighborAdvertisement uint8 = 136
+	TypeRedirect              uint8 = 137
+)
+
+// icmpHeaderLen is the length of the type, code and checksum fields
+const icmpHeaderLen = 4
+
+// ErrTruncated is returned when a message or option is shorter than its fixed fields
+var ErrTruncated = errors.New("ndp: truncated message")
+
+// Message is a Neighbor Discovery message. It is one of *RouterSolicitation, *RouterAdvertisement,
+// *NeighborSolicitation, *NeighborAdvertisement or *Redirect.
+type Message interface {
+	// Type returns the ICMPv6 type of the message
+	Type() uint8
+	// marshalBody appends the fields between the ICMPv6 header and the options
+	marshalBody(b []byte) ([]byte, error)
+	// unmarshalBody decodes the fields between the ICMPv6 header and the options and returns their length
+	unmarshalBody(b []byte) (int, error)
+	options() *[]Option
+}
+
+// RouterSolicitation is sent by hosts to request Router Advertisements
+type RouterSolicitation struct {
+	Options []Option
+}
+
+// RouterAdvertisement is sent by routers periodically or in response to a Router Solicitation
+type RouterAdvertisement struct {
+	CurrentHopLimit      uint8
+	ManagedConfiguration bool
+	OtherConfiguration   bool
+	// RouterLifetime has a resolution of one second
+	RouterLifetime time.Duration
+	// ReachableTime and RetransmitTimer have a resolution of one millisecond
+	ReachableTime   time.Duration
+	RetransmitTimer time.Duration
+	Options         []Option
+}
+
+// NeighborSolicitation requests the link-layer address of TargetAddress
+// or verifies its reachability. It is also used for Duplicate Address Detection.
+type NeighborSolicitation struct {
+	TargetAddress net.IP
+	Options       []Option
+}
+
+// NeighborAdvertisement is the answer to a Neighbor Solicitation, or announces a link-layer address change
+type NeighborAdvertisement struct {
+	Router        bool
+	Solicited     bool
+	Override      bool
+	TargetAddress net.IP
+	Options       []Option
+}
+
+// Redirect informs a host of a better first hop (TargetAddress) for DestinationAddress
+type Redirect struct {
+	TargetAddress      net.IP
+	DestinationAddress net.IP
+	Options            []Option
+}
+
+func (*RouterSolicitation) Type() uint8    { return TypeRouterSolicitation }
+func (*RouterAdvertisement) Type() uint8   { return TypeRouterAdvertisement }
+func (*NeighborSolicitation) Type() uint8  { return TypeNeighborSolicitation }
+func (*NeighborAdvertisement) Type() uint8 { return TypeNeighborAdvertisement }
+func (*Redirect) Type() uint8              { return TypeRedirect }
+
+func (m *RouterSolicitation) options() *[]Option    { return &m.Options }
+func (m *RouterAdvertisement) options() *[]Option   { return &m.Options }
+func (m *NeighborSolicitation) options() *[]Option  { return &m.Options }
+func (m *NeighborAdvertisement) options() *[]Option { return &m.Options }
+func (m *Redirect) options() *[]Option              { return &m.Options }
+
+// MarshalMessage encodes a message including the ICMPv6 header. The checksum field is left zero.
+func MarshalMessage(m Message) ([]byte, error) {
+	b := []byte{m.Type(), 0, 0, 0}
+	b, err := m.marshalBody(b)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range *m.options() {
+		if b, err = marshalOption(b, o); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// ParseMessage decodes a message including the ICMPv6 header. The checksum is not verified.
+func ParseMessage(b []byte) (Message, error) {
+	if len(b) < icmpHeaderLen {
+		return nil, ErrTruncated
+	}
+	var m Message
+	switch b[0] {
+	case TypeRouterSolicitation:
+		m = &RouterSolicitation{}
+	case TypeRouterAdvertisement:
+		m = &RouterAdvertisement{}
+	case TypeNeighborSolicitation:
+		m = &NeighborSolicitation{}
+	case TypeNeighborAdvertisement:
+		m = &NeighborAdvertisement{}
+	case TypeRedirect:
+		m = &Redirect{}
+	default:
+		return nil, fmt.Errorf("ndp: ICMPv6 type %d is not a Neighbor Discovery message", b[0])
+	}
+	if b[1] != 0 {
+		return nil, fmt.Errorf("ndp: invalid ICMPv6 code %d", b[1])
+	}
+
+	n, err := m.unmarshalBody(b[icmpHeaderLen:])
+	if err != nil {
+		return nil, err
+	}
+	options, err := parseOptions(b[icmpHeaderLen+n:])
+	if err != nil {
+		return nil, err
+	}
+	*m.options() = options
+	return m, nil
+}
+
+func (m *RouterSolicitation) marshalBody(b []byte) ([]byte, error) {
+	return append(b, 0, 0, 0, 0), nil
+}
+
+func (m *RouterSolicitation) unmarshalBody(b []byte) (int, error) {
+	if len(b) < 4 {
+		return 0, ErrTruncated
+	}
+	return 4, nil
+}
+
+func (m *RouterAdvertisement) marshalBody(b []byte) ([]byte, error) {
+	var flags byte
+	if m.ManagedConfiguration {
+		flags |= 0x80
+	}
+	if m.OtherConfiguration {
+		flags |= 0x40
+	}
+	lifetime := m.RouterLifetime / time.Second
+	if lifetime < 0 || lifetime > 0xffff {
+		return nil, fmt.Errorf("ndp: router lifetime %s out of range", m.RouterLifetime)
+	}
+	reachable := m.ReachableTime / time.Millisecond
+	if reachable < 0 || reachable > 0xffffffff {
+		return nil, fmt.Errorf("ndp: reachable time %s out of range", m.ReachableTime)
+	}
+	retransmit := m.RetransmitTimer / time.Millisecond
+	if retransmit < 0 || retransmit > 0xffffffff {
+		return nil, fmt.Errorf("ndp: retransmit timer %s out of range", m.RetransmitTimer)
+	}
+	b = append(b, m.CurrentHopLimit, flags)
+	b = binary.BigEndian.AppendUint16(b, uint16(lifetime))
+	b = binary.BigEndian.AppendUint32(b, uint32(reachable))
+	return binary.BigEndian.AppendUint32(b, uint32(retransmit)), nil
+}
+
+func (m *RouterAdvertisement) unmarshalBody(b []byte) (int, error) {
+	if len(b) < 12 {
+		return 0, ErrTruncated
+	}
+	m.CurrentHopLimit = b[0]
+	m.ManagedConfiguration = b[1]&0x80 != 0
+	m.OtherConfiguration = b[1]&0x40 != 0
+	m.RouterLifetime = time.Duration(binary.BigEndian.Uint16(b[2:])) * time.Second
+	m.ReachableTime = time.Duration(binary.BigEndian.Uint32(b[4:])) * time.Millisecond
+	m.RetransmitTimer = time.Duration(binary.BigEndian.Uint32(b[8:])) * time.Millisecond
+	return 12, nil
+}
+
+func (m *NeighborSolicitation) marshalBody(b []byte) ([]byte, error) {
+	b = append(b, 0, 0, 0, 0)
+	return appendIP(b, m.TargetAddress)
+}
+
+func (m *NeighborSolicitation) unmarshalBody(b []byte) (int, error) {
+	if len(b) < 20 {
+		return 0, ErrTruncated
+	}
+	m.TargetAddress = copyIP(b[4:20])
+	return 20, nil
+}
+
+func (m *NeighborAdvertisement) marshalBody(b []byte) ([]byte, error) {
+	var flags byte
+	if m.Router {
+		flags |= 0x80
+	}
+	if m.Solicited {
+		flags |= 0x40
+	}
+	if m.Override {
+		flags |= 0x20
+	}
+	b = append(b, flags, 0, 0, 0)
+	return appendIP(b, m.TargetAddress)
+}
+
+func (m *NeighborAdvertisement) unmarshalBody(b []byte) (int, error) {
+	if len(b) < 20 {
+		return 0, ErrTruncated
+	}
+	m.Router = b[0]&0x80 != 0
+	m.Solicited = b[0]&0x40 != 0
+	m.Override = b[0]&0x20 != 0
+	m.TargetAddress = copyIP(b[4:20])
+	return 20, nil
+}
+
+func (m *Redirect) marshalBody(b []byte) ([]byte, error) {
+	b = append(b, 0, 0, 0, 0)
+	b, err := appendIP(b, m.TargetAddress)
+	if err != nil {
+		return nil, err
+	}
+	return appendIP(b, m.DestinationAddress)
+}
+
+func (m *Redirect) unmarshalBody(b []byte) (int, error) {
+	if len(b) < 36 {
+		return 0, ErrTruncated
+	}
+	m.TargetAddress = copyIP(b[4:20])
+	m.DestinationAddress = copyIP(b[20:36])
+	return 36, nil
+}
+
+func appendIP(b []byte, ip net.IP) ([]byte, error) {
+	ip16 := ip.To16()
+	if ip16 == nil || ip.To4() != nil {
+		return nil, fmt.Errorf("ndp: %q is not an IPv6 address", ip)
+	}
+	return append(b, ip16...), nil
+}
+
+func copyIP(b []byte) net.IP {
+	return append(net.IP(nil), b...)
+}
@@ -0,0 +1,214 @@
+package ndp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+
+func TestPacketRoundTrip(t *testing.T) {
+	mtu := MTU(1500)
+	cases := []struct {
+		name    string
+		message Message
+	}{
+		{"router solicitation", &RouterSolicitation{
+			Options: []Option{&LinkLayerAddress{Addr: testMAC}},
+		}},
+		{"router advertisement", &RouterAdvertisement{
+			CurrentHopLimit:      64,
+			ManagedConfiguration: true,
+			OtherConfiguration:   true,
+			RouterLifetime:       30 * time.Minute,
+			ReachableTime:        1500 * time.Millisecond,
+			RetransmitTimer:      time.Second,
+			Options: []Option{
+				&LinkLayerAddress{Addr: testMAC},
+				&mtu,
+				&PrefixInformation{PrefixLength: 64, OnLink: true, ValidLifetime: InfiniteLifetime, PreferredLifetime: time.Hour, Prefix: net.ParseIP("2001:db8::")},
+				&RDNSS{Lifetime: 10 * time.Minute, Servers: []net.IP{net.ParseIP("2001:db8::53"), net.ParseIP("2001:db8::54")}},
+			},
+		}},
+		{"neighbor solicitation", &NeighborSolicitation{
+			TargetAddress: net.ParseIP("fd01::99"),
+			Options:       []Option{&LinkLayerAddress{Addr: testMAC}, &RawOption{OptionType: OptionNonce, Value: []byte{1, 2, 3, 4, 5, 6}}},
+		}},
+		{"neighbor advertisement", &NeighborAdvertisement{
+			Solicited:     true,
+			Override:      true,
+			TargetAddress: net.ParseIP("fd01::99"),
+			Options:       []Option{&LinkLayerAddress{Target: true, Addr: testMAC}},
+		}},
+		{"redirect", &Redirect{
+			TargetAddress:      net.ParseIP("fe80::2"),
+			DestinationAddress: net.ParseIP("2001:db8::1"),
+			Options:            []Option{&LinkLayerAddress{Target: true, Addr: testMAC}},
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Packet{Source: net.ParseIP("fe80::1"), Destination: net.ParseIP("ff02::1"), HopLimit: 255, Message: tc.message}
+			b, err := p.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParsePacket(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, p) {
+				t.Errorf("Expected %+v, but got %+v", p.Message, got.Message)
+			}
+		})
+	}
+}
+
+func TestMarshalRouterAdvertisement(t *testing.T) {
+	mtu := MTU(1500)
+	p := &Packet{
+		Source:      net.ParseIP("fe80::1"),
+		Destination: net.ParseIP("ff02::1"),
+		HopLimit:    255,
+		Message: &RouterAdvertisement{
+			CurrentHopLimit:      64,
+			ManagedConfiguration: true,
+			RouterLifetime:       1800 * time.Second,
+			Options: []Option{
+				&LinkLayerAddress{Addr: testMAC},
+				&mtu,
+				&PrefixInformation{PrefixLength: 64, OnLink: true, Autonomous: true, ValidLifetime: 24 * time.Hour, PreferredLifetime: 4 * time.Hour, Prefix: net.ParseIP("2001:db8::")},
+				&RDNSS{Lifetime: 600 * time.Second, Servers: []net.IP{net.ParseIP("2001:db8::53")}},
+			},
+		},
+	}
+	want, _ := hex.DecodeString("6000000000583afffe800000000000000000000000000001ff020000000000000000000000000001" +
+		"8600e1d940800708000000000000000001010200000000010501000000" +
+		"0005dc030440c000015180000038400000000020010db8000000000000000000000000" +
+		"190300000000025820010db8000000000000000000000053")
+
+	got, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected %x, but got %x", want, got)
+	}
+}
+
+func TestMarshalRouterAdvertisementRange(t *testing.T) {
+	cases := []struct {
+		name    string
+		message *RouterAdvertisement
+	}{
+		{"router lifetime", &RouterAdvertisement{RouterLifetime: 0x10000 * time.Second}},
+		{"negative router lifetime", &RouterAdvertisement{RouterLifetime: -time.Second}},
+		{"reachable time", &RouterAdvertisement{ReachableTime: 0x100000000 * time.Millisecond}},
+		{"negative reachable time", &RouterAdvertisement{ReachableTime: -time.Millisecond}},
+		{"retransmit timer", &RouterAdvertisement{RetransmitTimer: 0x100000000 * time.Millisecond}},
+		{"negative retransmit timer", &RouterAdvertisement{RetransmitTimer: -time.Millisecond}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Packet{Source: net.ParseIP("fe80::1"), Destination: net.ParseIP("ff02::1"), HopLimit: 255, Message: tc.message}
+			if _, err := p.Marshal(); err == nil {
+				t.Errorf("Out of range value was accepted")
+			}
+		})
+	}
+
+	p := &Packet{
+		Source:      net.ParseIP("fe80::1"),
+		Destination: net.ParseIP("ff02::1"),
+		HopLimit:    255,
+		Message:     &RouterAdvertisement{ReachableTime: 0xffffffff * time.Millisecond, RetransmitTimer: 0xffffffff * time.Millisecond},
+	}
+	if _, err := p.Marshal(); err != nil {
+		t.Errorf("Largest values were rejected: %s", err)
+	}
+}
+
+func TestParsePacketErrors(t *testing.T) {
+	p := &Packet{
+		Source:      net.ParseIP("fd00::1"),
+		Destination: net.ParseIP("fd00::5"),
+		HopLimit:    255,
+		Message:     &NeighborAdvertisement{Solicited: true, TargetAddress: net.ParseIP("fd01::99")},
+	}
+	valid, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modify := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), valid...))
+	}
+	cases := []struct {
+		name   string
+		packet []byte
+		want   error
+	}{
+		{"truncated header", valid[:30], ErrTruncated},
+		{"truncated payload", valid[:len(valid)-1], ErrTruncated},
+		{"checksum", modify(func(b []byte) []byte { b[42] ^= 0xff; return b }), ErrInvalidChecksum},
+		{"truncated option", modify(func(b []byte) []byte {
+			b = append(b, OptionMTU, 1, 0, 0)
+			b[5] += 4
+			return fixChecksum(b)
+		}), ErrTruncated},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParsePacket(tc.packet); !errors.Is(err, tc.want) {
+				t.Errorf("Expected %v, but got %v", tc.want, err)
+			}
+		})
+	}
+
+	zeroLength := modify(func(b []byte) []byte {
+		b = append(b, OptionMTU, 0, 0, 0, 0, 0, 0, 0)
+		b[5] += 8
+		return fixChecksum(b)
+	})
+	if _, err := ParsePacket(zeroLength); err == nil {
+		t.Errorf("Option with length zero was accepted")
+	}
+}
+
+func fixChecksum(b []byte) []byte {
+	b[42], b[43] = 0, 0
+	sum := Checksum(b[8:24], b[24:40], b[40:])
+	b[42], b[43] = byte(sum>>8), byte(sum)
+	return b
+}
+
+func FuzzParsePacket(f *testing.F) {
+	p := &Packet{
+		Source:      net.ParseIP("fd00::5"),
+		Destination: net.ParseIP("ff02::1:ff00:99"),
+		HopLimit:    255,
+		Message:     &NeighborSolicitation{TargetAddress: net.ParseIP("fd01::99"), Options: []Option{&LinkLayerAddress{Addr: testMAC}}},
+	}
+	seed, _ := p.Marshal()
+	f.Add(seed)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		p, err := ParsePacket(b)
+		if err != nil {
+			return
+		}
+		// Everything that is accepted has to survive a round trip
+		again, err := p.Marshal()
+		if err != nil {
+			return
+		}
+		if _, err := ParsePacket(again); err != nil {
+			t.Fatalf("Unable to parse re-encoded packet %x: %s", again, err)
+		}
+	})
+}
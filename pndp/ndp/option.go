@@ -0,0 +1,235 @@
+package ndp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Option types
+const (
+	OptionSourceLinkLayerAddress uint8 = 1
+	OptionTargetLinkLayerAddress uint8 = 2
+	OptionPrefixInformation      uint8 = 3
+	OptionRedirectedHeader       uint8 = 4
+	OptionMTU                    uint8 = 5
+	OptionNonce                  uint8 = 14
+	OptionRDNSS                  uint8 = 25
+)
+
+// InfiniteLifetime is the lifetime encoded as all ones, which never expires
+const InfiniteLifetime = time.Duration(0xffffffff) * time.Second
+
+// Option is a Neighbor Discovery option. It is one of *LinkLayerAddress, *PrefixInformation,
+// *MTU, *RDNSS or *RawOption (for all other types).
+type Option interface {
+	// Type returns the option type
+	Type() uint8
+	// marshalValue appends the option without the type and length fields
+	marshalValue(b []byte) ([]byte, error)
+	// unmarshalValue decodes the option without the type and length fields
+	unmarshalValue(b []byte) error
+}
+
+// LinkLayerAddress is the Source or Target Link-Layer Address option
+type LinkLayerAddress struct {
+	// Target selects the Target Link-Layer Address option instead of the Source Link-Layer Address option
+	Target bool
+	Addr   net.HardwareAddr
+}
+
+// PrefixInformation announces an on-link prefix or a prefix for address autoconfiguration
+type PrefixInformation struct {
+	PrefixLength      uint8
+	OnLink            bool
+	Autonomous        bool
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+	Prefix            net.IP
+}
+
+// MTU announces the link MTU
+type MTU uint32
+
+// RDNSS announces recursive DNS servers (RFC 8106)
+type RDNSS struct {
+	Lifetime time.Duration
+	Servers  []net.IP
+}
+
+// RawOption is an option without a typed representation (for example Nonce or Redirected Header).
+// Value does not include the type and length fields and is padded to a multiple of 8 bytes when marshalled.
+type RawOption struct {
+	OptionType uint8
+	Value      []byte
+}
+
+func (o *LinkLayerAddress) Type() uint8 {
+	if o.Target {
+		return OptionTargetLinkLayerAddress
+	}
+	return OptionSourceLinkLayerAddress
+}
+func (*PrefixInformation) Type() uint8 { return OptionPrefixInformation }
+func (*MTU) Type() uint8               { return OptionMTU }
+func (*RDNSS) Type() uint8             { return OptionRDNSS }
+func (o *RawOption) Type() uint8       { return o.OptionType }
+
+func marshalOption(b []byte, o Option) ([]byte, error) {
+	start := len(b)
+	b = append(b, o.Type(), 0)
+	b, err := o.marshalValue(b)
+	if err != nil {
+		return nil, err
+	}
+	for (len(b)-start)%8 != 0 {
+		b = append(b, 0)
+	}
+	length := (len(b) - start) / 8
+	if length > 0xff {
+		return nil, fmt.Errorf("ndp: option of type %d is too long", o.Type())
+	}
+	b[start+1] = byte(length)
+	return b, nil
+}
+
+func parseOptions(b []byte) ([]Option, error) {
+	var options []Option
+	for len(b) > 0 {
+		if len(b) < 2 {
+			return nil, ErrTruncated
+		}
+		length := int(b[1]) * 8
+		if length == 0 {
+			return nil, fmt.Errorf("ndp: option of type %d has length zero", b[0])
+		}
+		if len(b) < length {
+			return nil, ErrTruncated
+		}
+
+		var o Option
+		switch b[0] {
+		case OptionSourceLinkLayerAddress, OptionTargetLinkLayerAddress:
+			o = &LinkLayerAddress{Target: b[0] == OptionTargetLinkLayerAddress}
+		case OptionPrefixInformation:
+			o = &PrefixInformation{}
+		case OptionMTU:
+			o = new(MTU)
+		case OptionRDNSS:
+			o = &RDNSS{}
+		default:
+			o = &RawOption{OptionType: b[0]}
+		}
+		if err := o.unmarshalValue(b[2:length]); err != nil {
+			return nil, err
+		}
+		options = append(options, o)
+		b = b[length:]
+	}
+	return options, nil
+}
+
+func (o *LinkLayerAddress) marshalValue(b []byte) ([]byte, error) {
+	if len(o.Addr) == 0 {
+		return nil, fmt.Errorf("ndp: empty link-layer address")
+	}
+	return append(b, o.Addr...), nil
+}
+
+// unmarshalValue assumes an Ethernet address. Padding is not part of Addr.
+func (o *LinkLayerAddress) unmarshalValue(b []byte) error {
+	if len(b) < 6 {
+		return ErrTruncated
+	}
+	o.Addr = append(net.HardwareAddr(nil), b[:6]...)
+	return nil
+}
+
+func (o *PrefixInformation) marshalValue(b []byte) ([]byte, error) {
+	var flags byte
+	if o.OnLink {
+		flags |= 0x80
+	}
+	if o.Autonomous {
+		flags |= 0x40
+	}
+	b = append(b, o.PrefixLength, flags)
+	b = binary.BigEndian.AppendUint32(b, lifetimeSeconds(o.ValidLifetime))
+	b = binary.BigEndian.AppendUint32(b, lifetimeSeconds(o.PreferredLifetime))
+	b = append(b, 0, 0, 0, 0)
+	return appendIP(b, o.Prefix)
+}
+
+func (o *PrefixInformation) unmarshalValue(b []byte) error {
+	if len(b) < 30 {
+		return ErrTruncated
+	}
+	o.PrefixLength = b[0]
+	o.OnLink = b[1]&0x80 != 0
+	o.Autonomous = b[1]&0x40 != 0
+	o.ValidLifetime = time.Duration(binary.BigEndian.Uint32(b[2:])) * time.Second
+	o.PreferredLifetime = time.Duration(binary.BigEndian.Uint32(b[6:])) * time.Second
+	o.Prefix = copyIP(b[14:30])
+	return nil
+}
+
+func (o *MTU) marshalValue(b []byte) ([]byte, error) {
+	b = append(b, 0, 0)
+	return binary.BigEndian.AppendUint32(b, uint32(*o)), nil
+}
+
+func (o *MTU) unmarshalValue(b []byte) error {
+	if len(b) < 6 {
+		return ErrTruncated
+	}
+	*o = MTU(binary.BigEndian.Uint32(b[2:]))
+	return nil
+}
+
+func (o *RDNSS) marshalValue(b []byte) ([]byte, error) {
+	if len(o.Servers) == 0 {
+		return nil, fmt.Errorf("ndp: RDNSS option without servers")
+	}
+	b = append(b, 0, 0)
+	b = binary.BigEndian.AppendUint32(b, lifetimeSeconds(o.Lifetime))
+	var err error
+	for _, server := range o.Servers {
+		if b, err = appendIP(b, server); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (o *RDNSS) unmarshalValue(b []byte) error {
+	if len(b) < 22 || (len(b)-6)%16 != 0 {
+		return ErrTruncated
+	}
+	o.Lifetime = time.Duration(binary.BigEndian.Uint32(b[2:])) * time.Second
+	o.Servers = nil
+	for i := 6; i < len(b); i += 16 {
+		o.Servers = append(o.Servers, copyIP(b[i:i+16]))
+	}
+	return nil
+}
+
+func (o *RawOption) marshalValue(b []byte) ([]byte, error) {
+	return append(b, o.Value...), nil
+}
+
+func (o *RawOption) unmarshalValue(b []byte) error {
+	o.Value = append([]byte(nil), b...)
+	return nil
+}
+
+// lifetimeSeconds converts a lifetime to seconds, saturating at InfiniteLifetime
+func lifetimeSeconds(d time.Duration) uint32 {
+	if d >= InfiniteLifetime {
+		return 0xffffffff
+	}
+	if d < 0 {
+		return 0
+	}
+	return uint32(d / time.Second)
+}
@@ -0,0 +1,129 @@
+package ndp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ipv6HeaderLen is the length of the fixed IPv6 header
+const ipv6HeaderLen = 40
+
+// protocolICMPv6 is the IPv6 Next Header value of ICMPv6
+const protocolICMPv6 = 58
+
+// ErrInvalidChecksum is returned by ParsePacket if the ICMPv6 checksum does not match
+var ErrInvalidChecksum = errors.New("ndp: invalid checksum")
+
+// Packet is an IPv6 packet that carries a Neighbor Discovery message without extension headers
+type Packet struct {
+	Source      net.IP
+	Destination net.IP
+	// HopLimit must be 255 for all Neighbor Discovery messages
+	HopLimit uint8
+	Message  Message
+}
+
+// Marshal encodes the packet including the IPv6 header and fills in the ICMPv6 checksum
+func (p *Packet) Marshal() ([]byte, error) {
+	icmp, err := MarshalMessage(p.Message)
+	if err != nil {
+		return nil, err
+	}
+	if len(icmp) > 0xffff {
+		return nil, fmt.Errorf("ndp: message of %d bytes is too long", len(icmp))
+	}
+
+	b := []byte{
+		0x60, 0, 0, 0, // Version, traffic class and flow label
+		0, 0, // Payload length
+		protocolICMPv6,
+		p.HopLimit,
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(icmp)))
+	if b, err = appendIP(b, p.Source); err != nil {
+		return nil, err
+	}
+	if b, err = appendIP(b, p.Destination); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(icmp[2:], Checksum(p.Source, p.Destination, icmp))
+	return append(b, icmp...), nil
+}
+
+// ParsePacket decodes an IPv6 packet carrying a Neighbor Discovery message and verifies the ICMPv6 checksum.
+// Bytes after the payload length announced in the IPv6 header are ignored.
+func ParsePacket(b []byte) (*Packet, error) {
+	if len(b) < ipv6HeaderLen {
+		return nil, ErrTruncated
+	}
+	if b[0]>>4 != 6 {
+		return nil, fmt.Errorf("ndp: IP version %d is not 6", b[0]>>4)
+	}
+	if b[6] != protocolICMPv6 {
+		return nil, fmt.Errorf("ndp: next header %d is not ICMPv6", b[6])
+	}
+	payloadLen := int(binary.BigEndian.Uint16(b[4:]))
+	if len(b) < ipv6HeaderLen+payloadLen {
+		return nil, ErrTruncated
+	}
+
+	p := &Packet{
+		Source:      copyIP(b[8:24]),
+		Destination: copyIP(b[24:40]),
+		HopLimit:    b[7],
+	}
+	icmp := b[ipv6HeaderLen : ipv6HeaderLen+payloadLen]
+	if !VerifyChecksum(p.Source, p.Destination, icmp) {
+		return nil, ErrInvalidChecksum
+	}
+	m, err := ParseMessage(icmp)
+	if err != nil {
+		return nil, err
+	}
+	p.Message = m
+	return p, nil
+}
+
+// Checksum calculates the ICMPv6 checksum of a message with the checksum field set to zero
+func Checksum(src net.IP, dst net.IP, icmp []byte) uint16 {
+	if len(icmp) == 0 {
+		return 0
+	}
+	var pseudoHeader [8]byte
+	binary.BigEndian.PutUint32(pseudoHeader[:], uint32(len(icmp)))
+	pseudoHeader[7] = protocolICMPv6
+
+	sum := checksumAddition(src.To16(), 0)
+	sum = checksumAddition(dst.To16(), sum)
+	sum = checksumAddition(pseudoHeader[:], sum)
+	sum = checksumAddition(icmp, sum)
+	return sum ^ 0xffff
+}
+
+// VerifyChecksum reports whether the checksum field of a message is correct
+func VerifyChecksum(src net.IP, dst net.IP, icmp []byte) bool {
+	if len(icmp) < icmpHeaderLen {
+		return false
+	}
+	// The checksum field is zeroed for the calculation without modifying the message
+	zeroed := append([]byte(nil), icmp...)
+	zeroed[2], zeroed[3] = 0, 0
+	return Checksum(src, dst, zeroed) == binary.BigEndian.Uint16(icmp[2:])
+}
+
+// checksumAddition adds b to the one's complement sum. An odd length is padded with a zero byte.
+func checksumAddition(b []byte, sum uint16) uint16 {
+	s := uint32(sum)
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s>>16 > 0 {
+		s = (s & 0xffff) + (s >> 16)
+	}
+	return uint16(s)
+}
@@ -1,7 +1,10 @@
 package pndp
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"os"
 	"syscall"
 	"unsafe"
 
@@ -9,16 +12,19 @@ import (
 )
 
 type netlinkSocket struct {
-	fd  int
-	lsa unix.SockaddrNetlink
+	// file wraps the non-blocking socket, so that closing it interrupts a pending receive
+	file *os.File
+	lsa  unix.SockaddrNetlink
 }
 
 type interfaceAddressUpdate struct {
 	InterfaceIndex int
-	Event          addressUpdateInfo
-	NetworkFamily  networkFamily
-	Flags          byte
-	Scope          byte
+	// InterfaceName is only set for LinkChange updates, as the interface may no longer exist
+	InterfaceName string
+	Event         addressUpdateInfo
+	NetworkFamily networkFamily
+	Flags         byte
+	Scope         byte
 }
 
 type networkFamily int
@@ -29,16 +35,17 @@ const (
 	IPv6          networkFamily     = 6
 	AddressDelete addressUpdateInfo = 0
 	AddressAdd    addressUpdateInfo = 1
+	// LinkChange is reported when an interface is created or deleted, or its attributes (such as its hardware address) change
+	LinkChange addressUpdateInfo = 2
 )
 
 func newNetlinkSocket(protocol int, multicastGroups ...uint) (*netlinkSocket, error) {
-	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, protocol)
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, protocol)
 	if err != nil {
 		return nil, err
 	}
 
 	socket := &netlinkSocket{}
-	socket.fd = fd
 	socket.lsa.Family = unix.AF_NETLINK
 
 	for _, g := range multicastGroups {
@@ -50,17 +57,23 @@ func newNetlinkSocket(protocol int, multicastGroups ...uint) (*netlinkSocket, er
 		_ = unix.Close(fd)
 		return nil, err
 	}
+	socket.file = os.NewFile(uintptr(fd), "netlink")
 	return socket, nil
 }
 
 func (socket *netlinkSocket) receiveMessage() ([]syscall.NetlinkMessage, *unix.SockaddrNetlink, error) {
-	fd := socket.fd
-	if fd < 0 {
-		return nil, nil, fmt.Errorf("socket is closed")
+	rawConn, err := socket.file.SyscallConn()
+	if err != nil {
+		return nil, nil, err
 	}
 
 	var buf [7000]byte
-	n, from, err := unix.Recvfrom(fd, buf[:], 0)
+	var n int
+	var from unix.Sockaddr
+	err = rawConn.Read(func(fd uintptr) bool {
+		n, from, err = unix.Recvfrom(int(fd), buf[:], 0)
+		return err != unix.EAGAIN
+	})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -83,14 +96,13 @@ func (socket *netlinkSocket) receiveMessage() ([]syscall.NetlinkMessage, *unix.S
 }
 
 func (socket *netlinkSocket) Close() {
-	_ = unix.Close(socket.fd)
-	socket.fd = -1
+	_ = socket.file.Close()
 }
 
 func getInterfaceUpdates(updateChannel chan *interfaceAddressUpdate, stopChannel chan interface{}) error {
 	// Note: UpdateChannel should be buffered
 
-	socket, err := newNetlinkSocket(unix.NETLINK_ROUTE, unix.RTNLGRP_IPV4_IFADDR, unix.RTNLGRP_IPV6_IFADDR)
+	socket, err := newNetlinkSocket(unix.NETLINK_ROUTE, unix.RTNLGRP_LINK, unix.RTNLGRP_IPV4_IFADDR, unix.RTNLGRP_IPV6_IFADDR)
 	if err != nil {
 		return err
 	}
@@ -99,10 +111,11 @@ func getInterfaceUpdates(updateChannel chan *interfaceAddressUpdate, stopChannel
 		go func() {
 			<-stopChannel
 			socket.Close()
-			close(updateChannel)
 		}()
 	}
 	go func() {
+		defer close(updateChannel)
+		defer socket.Close()
 		for {
 			messages, from, err := socket.receiveMessage()
 			if err != nil {
@@ -124,6 +137,17 @@ func getInterfaceUpdates(updateChannel chan *interfaceAddressUpdate, stopChannel
 					event = AddressAdd
 				case unix.RTM_DELADDR:
 					event = AddressDelete
+				case unix.RTM_NEWLINK, unix.RTM_DELLINK:
+					if len(messages[i].Data) < unix.SizeofIfInfomsg {
+						continue
+					}
+					ifInfoMsg := (*unix.IfInfomsg)(unsafe.Pointer(&messages[i].Data[0]))
+					updateChannel <- &interfaceAddressUpdate{
+						Event:          LinkChange,
+						InterfaceIndex: int(ifInfoMsg.Index),
+						InterfaceName:  linkName(&messages[i]),
+					}
+					continue
 				default:
 					continue
 				}
@@ -154,3 +178,60 @@ func getInterfaceUpdates(updateChannel chan *interfaceAddressUpdate, stopChannel
 	}()
 	return nil
 }
+
+// linkName returns the IFLA_IFNAME attribute of an RTM_NEWLINK or RTM_DELLINK message
+func linkName(m *syscall.NetlinkMessage) string {
+	attrs, err := syscall.ParseNetlinkRouteAttr(m)
+	if err != nil {
+		return ""
+	}
+	for _, attr := range attrs {
+		if attr.Attr.Type == unix.IFLA_IFNAME {
+			return string(bytes.TrimRight(attr.Value, "\x00"))
+		}
+	}
+	return ""
+}
+
+// usableNeighborStates are the states of neighbor cache entries whose address is known to be reachable
+const usableNeighborStates = unix.NUD_REACHABLE | unix.NUD_STALE | unix.NUD_DELAY | unix.NUD_PROBE | unix.NUD_PERMANENT
+
+// getNeighbors returns the IPv6 addresses in the neighbor cache of the interface with the given index
+func getNeighbors(ifindex int) ([]net.IP, error) {
+	rib, err := syscall.NetlinkRIB(unix.RTM_GETNEIGH, unix.AF_INET6)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+	var result []net.IP
+	for _, m := range messages {
+		if m.Header.Type != unix.RTM_NEWNEIGH || len(m.Data) < unix.SizeofNdMsg {
+			continue
+		}
+		ndMsg := (*unix.NdMsg)(unsafe.Pointer(&m.Data[0]))
+		if int(ndMsg.Ifindex) != ifindex || ndMsg.State&usableNeighborStates == 0 {
+			continue
+		}
+		// Attributes follow the (aligned) header
+		b := m.Data[nlmAlign(unix.SizeofNdMsg):]
+		for len(b) >= unix.SizeofRtAttr {
+			attrLen := int(*(*uint16)(unsafe.Pointer(&b[0])))
+			attrType := *(*uint16)(unsafe.Pointer(&b[2]))
+			if attrLen < unix.SizeofRtAttr || attrLen > len(b) {
+				break
+			}
+			if attrType == unix.NDA_DST && attrLen == unix.SizeofRtAttr+16 {
+				result = append(result, net.IP(bytes.Clone(b[unix.SizeofRtAttr:attrLen])))
+			}
+			b = b[min(nlmAlign(attrLen), len(b)):]
+		}
+	}
+	return result, nil
+}
+
+func nlmAlign(length int) int {
+	return (length + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+}
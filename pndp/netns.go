@@ -0,0 +1,303 @@
+package pndp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// netnsRunDir is where "ip netns add" creates named network namespaces
+const netnsRunDir = "/run/netns"
+
+// NetnsPath returns the path of a network namespace that is given by name (as created by "ip netns add") or by path
+func NetnsPath(netns string) string {
+	if strings.ContainsRune(netns, '/') {
+		return netns
+	}
+	return filepath.Join(netnsRunDir, netns)
+}
+
+// netnsWorker runs the calls in a network namespace on a goroutine that is locked to its OS thread, which joins the
+// namespace once. The thread is never unlocked, as it cannot be reused for other goroutines after changing its namespace.
+type netnsWorker struct {
+	path  string
+	calls chan func()
+	// joined is set once the thread has joined the namespace file identified by dev and ino
+	joined   bool
+	dev, ino uint64
+}
+
+var (
+	netnsWorkersMutex sync.Mutex
+	// netnsWorkers holds the workers by the path of their namespace. They run until pndpd exits.
+	netnsWorkers = make(map[string]*netnsWorker)
+)
+
+// inNetns calls f in the network namespace at netnsPath (see netnsWorker). Sockets that f opens stay in the namespace.
+// f must not call inNetns.
+func inNetns(netnsPath string, f func() error) error {
+	netnsWorkersMutex.Lock()
+	worker, ok := netnsWorkers[netnsPath]
+	if !ok {
+		worker = &netnsWorker{path: netnsPath, calls: make(chan func())}
+		netnsWorkers[netnsPath] = worker
+		go worker.run()
+	}
+	netnsWorkersMutex.Unlock()
+
+	result := make(chan error, 1)
+	worker.calls <- func() {
+		if err := worker.join(); err != nil {
+			result <- err
+			return
+		}
+		result <- f()
+	}
+	return <-result
+}
+
+func (w *netnsWorker) run() {
+	runtime.LockOSThread()
+	for call := range w.calls {
+		call()
+	}
+}
+
+// join joins the namespace at the path of the worker unless the thread is in it already.
+// A namespace that has been deleted and created again under the same path is joined again.
+func (w *netnsWorker) join() error {
+	var stat unix.Stat_t
+	if err := unix.Stat(w.path, &stat); err != nil {
+		return fmt.Errorf("network namespace %s: %w", w.path, err)
+	}
+	if w.joined && uint64(stat.Dev) == w.dev && stat.Ino == w.ino {
+		return nil
+	}
+	fd, err := unix.Open(w.path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("network namespace %s: %w", w.path, err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return fmt.Errorf("network namespace %s: %w", w.path, err)
+	}
+	if err := unix.Setns(fd, unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("joining network namespace %s: %w", w.path, err)
+	}
+	w.joined, w.dev, w.ino = true, uint64(stat.Dev), stat.Ino
+	return nil
+}
+
+// netnsSeparator separates the name of an interface in another network namespace from the namespace (see namespacedName).
+// The config file rejects names of interfaces and namespaces that contain it.
+const netnsSeparator = "@"
+
+// namespacedName returns the name by which instances refer to iface in the network namespace netns (a name or path,
+// see NetnsPath), for example "eth0@tenant". Interfaces in the own namespace (netns "") keep their name, so that
+// interfaces of the same name in different namespaces can be told apart.
+func namespacedName(iface string, netns string) string {
+	if iface == "" || netns == "" {
+		return iface
+	}
+	return iface + netnsSeparator + netns
+}
+
+// cutNamespace splits a namespaced name (see namespacedName) into the name of the interface and its namespace.
+// found is false for names without namespace.
+func cutNamespace(name string) (iface string, netns string, found bool) {
+	i := strings.LastIndex(name, netnsSeparator)
+	if i < 0 {
+		return name, "", false
+	}
+	return name[:i], name[i+len(netnsSeparator):], true
+}
+
+// matchInterface reports whether the interface name (see namespacedName) matches pattern (see path.Match).
+// The wildcards only match the name of the interface, so the pattern and the interface have to be in the same namespace.
+func matchInterface(pattern string, name string) bool {
+	patternIface, patternNetns, _ := cutNamespace(pattern)
+	iface, netns, _ := cutNamespace(name)
+	matched, _ := path.Match(patternIface, iface)
+	return matched && patternNetns == netns
+}
+
+// namespacedNetwork is SystemNetwork for instances whose interfaces are (partly) in other network namespaces.
+// Interfaces are referred to by their namespaced name (see namespacedName). Interfaces in the own namespace
+// are handled by the Network of the instance.
+type namespacedNetwork struct {
+	Network
+	// namespaces holds the names or paths of the namespaces of the interfaces as they were configured
+	namespaces []string
+}
+
+// split returns the name of the interface within its namespace and the path of the namespace,
+// which is "" if the interface is in the own namespace
+func (n *namespacedNetwork) split(name string) (iface string, netnsPath string) {
+	iface, netns, found := cutNamespace(name)
+	if !found || !slices.Contains(n.namespaces, netns) {
+		return name, ""
+	}
+	return iface, NetnsPath(netns)
+}
+
+// namespace returns the path of the namespace of iface or "" if it is in the own namespace
+func (n *namespacedNetwork) namespace(iface string) string {
+	_, netnsPath := n.split(iface)
+	return netnsPath
+}
+
+func (n *namespacedNetwork) Open(name string) (PacketConn, error) {
+	iface, netns := n.split(name)
+	if netns == "" {
+		return n.Network.Open(iface)
+	}
+	var conn PacketConn
+	err := inNetns(netns, func() (err error) {
+		conn, err = systemNetwork{}.Open(iface)
+		return err
+	})
+	return conn, err
+}
+
+func (n *namespacedNetwork) OpenComplete(name string) (PacketConn, error) {
+	iface, netns := n.split(name)
+	if netns == "" {
+		return openComplete(n.Network, iface)
+	}
+	var conn PacketConn
+	err := inNetns(netns, func() (err error) {
+		conn, err = systemNetwork{}.OpenComplete(iface)
+		return err
+	})
+	return conn, err
+}
+
+// InterfaceByName returns the interface with its namespaced name
+func (n *namespacedNetwork) InterfaceByName(name string) (*net.Interface, error) {
+	ifaceName, netns := n.split(name)
+	if netns == "" {
+		return n.Network.InterfaceByName(ifaceName)
+	}
+	var iface *net.Interface
+	err := inNetns(netns, func() (err error) {
+		iface, err = net.InterfaceByName(ifaceName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	iface.Name = name
+	return iface, nil
+}
+
+func (n *namespacedNetwork) Addrs(iface *net.Interface) ([]net.Addr, error) {
+	netns := n.namespace(iface.Name)
+	if netns == "" {
+		return n.Network.Addrs(iface)
+	}
+	var addrs []net.Addr
+	err := inNetns(netns, func() (err error) {
+		addrs, err = iface.Addrs()
+		return err
+	})
+	return addrs, err
+}
+
+// Interfaces returns the interfaces of the own namespace (if the Network of the instance can list them)
+// followed by the interfaces of the other namespaces with their namespaced names
+func (n *namespacedNetwork) Interfaces() ([]net.Interface, error) {
+	var result []net.Interface
+	if lister, ok := n.Network.(InterfaceLister); ok {
+		ifaces, err := lister.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		result = ifaces
+	}
+	for _, netns := range n.namespaces {
+		err := inNetns(NetnsPath(netns), func() error {
+			ifaces, err := net.Interfaces()
+			for _, iface := range ifaces {
+				iface.Name = namespacedName(iface.Name, netns)
+				result = append(result, iface)
+			}
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (n *namespacedNetwork) Neighbors(iface *net.Interface) ([]net.IP, error) {
+	netns := n.namespace(iface.Name)
+	if netns == "" {
+		lister, ok := n.Network.(NeighborLister)
+		if !ok {
+			return nil, nil
+		}
+		return lister.Neighbors(iface)
+	}
+	var neighbors []net.IP
+	err := inNetns(netns, func() (err error) {
+		neighbors, err = getNeighbors(iface.Index)
+		return err
+	})
+	return neighbors, err
+}
+
+func (n *namespacedNetwork) Forwarding(iface *net.Interface) (bool, error) {
+	netns := n.namespace(iface.Name)
+	if netns == "" {
+		reader, ok := n.Network.(ForwardingReader)
+		if !ok {
+			return false, nil
+		}
+		return reader.Forwarding(iface)
+	}
+	ifaceName, _ := n.split(iface.Name)
+	var forwarding bool
+	err := inNetns(netns, func() (err error) {
+		forwarding, err = systemNetwork{}.Forwarding(&net.Interface{Index: iface.Index, Name: ifaceName})
+		return err
+	})
+	return forwarding, err
+}
+
+// readFile reads a file (such as a sysctl below /proc/sys/net) in the namespace of iface
+func (n *namespacedNetwork) readFile(iface string, name string) ([]byte, error) {
+	netns := n.namespace(iface)
+	if netns == "" {
+		return os.ReadFile(name)
+	}
+	var value []byte
+	err := inNetns(netns, func() (err error) {
+		value, err = os.ReadFile(name)
+		return err
+	})
+	return value, err
+}
+
+// withNamespaces returns network for interfaces in the given namespaces (see namespacedNetwork), or network itself if
+// they are all in the own namespace (""). Namespaces are given by name or path (see NetnsPath).
+func withNamespaces(network Network, namespaces ...string) Network {
+	var result []string
+	for _, netns := range namespaces {
+		if netns != "" && !slices.Contains(result, netns) {
+			result = append(result, netns)
+		}
+	}
+	if len(result) == 0 {
+		return network
+	}
+	return &namespacedNetwork{Network: network, namespaces: result}
+}
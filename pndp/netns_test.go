@@ -0,0 +1,42 @@
+package pndp
+
+import "testing"
+
+func TestNamespacedNames(t *testing.T) {
+	network := withNamespaces(NewMemoryNetwork(), "", "tenant", "tenant").(*namespacedNetwork)
+	if len(network.namespaces) != 1 {
+		t.Fatalf("Expected a single namespace, but got %v", network.namespaces)
+	}
+	for _, tc := range []struct {
+		name      string
+		iface     string
+		netnsPath string
+	}{
+		{"eth0", "eth0", ""},
+		{namespacedName("eth0", "tenant"), "eth0", "/run/netns/tenant"},
+		// Only the configured namespaces are split off
+		{"eth0@other", "eth0@other", ""},
+		{namespacedName("eth0@1", "tenant"), "eth0@1", "/run/netns/tenant"},
+	} {
+		iface, netnsPath := network.split(tc.name)
+		if iface != tc.iface || netnsPath != tc.netnsPath {
+			t.Errorf("Expected %s to be %s in %q, but got %s in %q", tc.name, tc.iface, tc.netnsPath, iface, netnsPath)
+		}
+	}
+
+	for _, tc := range []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"ppp*", "ppp0", true},
+		{"ppp*", "ppp0@tenant", false},
+		{"ppp*@tenant", "ppp0@tenant", true},
+		{"ppp*@tenant", "ppp0", false},
+		{"ppp*@tenant", "ppp0@other", false},
+	} {
+		if got := matchInterface(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("Expected matchInterface(%q, %q) to be %t", tc.pattern, tc.name, tc.want)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"net"
+	"os"
 	"strings"
 	"testing"
 )
@@ -34,10 +36,6 @@ func TestCalculateChecksum(t *testing.T) {
 			t.Errorf("%s", err)
 		}
 
-		bPayloadLen := make([]byte, 2)
-		binary.BigEndian.PutUint16(bPayloadLen, uint16(len(payloadBytes)))
-		testHeader.payloadLen = bPayloadLen
-
 		// Clear existing checksum as it should be zero for calculation
 		payloadBytes[2] = 0x0
 		payloadBytes[3] = 0x0
@@ -103,6 +101,167 @@ func TestIsIpv6(t *testing.T) {
 
 }
 
+// goldenPackets describes the reference frames in testdata/ndp.pcap, in order. The frames were sent by the Linux kernel
+// (see testdata/ndp-pcap.sh), so that pndpd has to produce the same bytes for the same inputs.
+var goldenPackets = []struct {
+	name     string
+	srcIP    string
+	dstIP    string
+	target   string
+	mac      net.HardwareAddr
+	ndpType  ndpType
+	linkDest net.HardwareAddr
+	// flags of advertisements
+	flags naFlags
+}{
+	{"solicitation", "fd01::1", "ff02::1:ff00:99", "fd01::99", testIntMAC, ndpSol, net.HardwareAddr{0x33, 0x33, 0xff, 0, 0, 0x99}, naFlags{}},
+	{"duplicate address detection", "::", "ff02::1:ff00:99", "fd01::99", goldenHostMAC, ndpSol, net.HardwareAddr{0x33, 0x33, 0xff, 0, 0, 0x99}, naFlags{}},
+	{"unicast solicitation", "fe80::1", "fd01::99", "fd01::99", testIntMAC, ndpSol, goldenHostMAC, naFlags{}},
+	{"solicitation of the asker", "fd00::5", "ff02::1:ff00:99", "fd01::99", testHostMAC, ndpSol, net.HardwareAddr{0x33, 0x33, 0xff, 0, 0, 0x99}, naFlags{}},
+	{"proxied advertisement", "fd00::1", "fd00::5", "fd01::99", testExtMAC, ndpAdv, testHostMAC, naFlags{router: true, solicited: true}},
+	{"advertisement", "fd01::99", "fd01::1", "fd01::99", goldenHostMAC, ndpAdv, testIntMAC, naFlags{solicited: true, override: true}},
+	{"global proxied advertisement", "2001:db8::1", "2001:db8::5", "2001:db8:1::99", testExtMAC, ndpAdv, testHostMAC, naFlags{router: true, solicited: true}},
+	{"unsolicited advertisement", "fd01::99", "ff02::1", "fd01::99", goldenHostMAC, ndpAdv, net.HardwareAddr{0x33, 0x33, 0, 0, 0, 0x01}, naFlags{override: true}},
+}
+
+// goldenHostMAC is the hardware address of the host that owns fd01::99 in the reference frames
+var goldenHostMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xbb}
+
+func TestGoldenPackets(t *testing.T) {
+	frames := readPcap(t, "testdata/ndp.pcap")
+	if len(frames) != len(goldenPackets) {
+		t.Fatalf("Expected %d reference frames, but got %d", len(goldenPackets), len(frames))
+	}
+
+	for i, tc := range goldenPackets {
+		t.Run(tc.name, func(t *testing.T) {
+			frame := frames[i]
+			got, err := buildNDPPacket(net.ParseIP(tc.srcIP), net.ParseIP(tc.dstIP), net.ParseIP(tc.target), tc.mac, tc.ndpType)
+			if tc.ndpType == ndpAdv {
+				got, err = buildNDPAdvertisement(net.ParseIP(tc.srcIP), net.ParseIP(tc.dstIP), net.ParseIP(tc.target), tc.mac, tc.flags)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, frame[14:]) {
+				t.Errorf("Packet differs from the reference\nwant %x\ngot  %x", frame[14:], got)
+			}
+
+			// The reference frames also have to pass the decoder
+			req, reason, _ := parseFrame("test", frame, nil)
+			if req == nil {
+				t.Fatalf("Reference frame was dropped: %s", reason)
+			}
+			if req.requestType != tc.ndpType || !net.IP(req.answeringForIP).Equal(net.ParseIP(tc.target)) {
+				t.Errorf("Reference frame decoded as %v for %s", req.requestType, net.IP(req.answeringForIP))
+			}
+			v6, _ := newIpv6Header(req.srcIP, req.dstIP)
+			if !checkPacketChecksum(v6, req.payload) {
+				t.Errorf("Reference frame has an invalid checksum")
+			}
+			if !bytes.Equal(frame[:6], tc.linkDest) {
+				t.Errorf("Reference frame is addressed to %s", net.HardwareAddr(frame[:6]))
+			}
+		})
+	}
+}
+
+// readPcap returns the frames of a pcap file (microsecond timestamps, little endian, Ethernet)
+func readPcap(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 24 || binary.LittleEndian.Uint32(data) != 0xa1b2c3d4 || binary.LittleEndian.Uint32(data[20:]) != 1 {
+		t.Fatalf("%s is not a little endian Ethernet pcap file", path)
+	}
+	var frames [][]byte
+	data = data[24:]
+	for len(data) > 0 {
+		if len(data) < 16 {
+			t.Fatal(errors.New("truncated pcap record header"))
+		}
+		capLen := int(binary.LittleEndian.Uint32(data[8:]))
+		if len(data) < 16+capLen {
+			t.Fatal(errors.New("truncated pcap record"))
+		}
+		frames = append(frames, data[16:16+capLen])
+		data = data[16+capLen:]
+	}
+	return frames
+}
+
+func FuzzCheckPacketChecksum(f *testing.F) {
+	f.Add([]byte(net.ParseIP("fd00::251d:bbbb:bbbb:bbbb")), []byte(net.ParseIP("ff02::1:ff00:99")),
+		[]byte{0x87, 0, 0x1d, 0x12, 0, 0, 0, 0, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x99, 0x01, 0x01, 0xad, 0xad, 0xad, 0xad, 0xad, 0xad})
+	f.Add(make([]byte, 16), make([]byte, 16), []byte{})
+	f.Fuzz(func(t *testing.T, srcIP []byte, dstIP []byte, payload []byte) {
+		v6, err := newIpv6Header(bytes.Clone(srcIP), bytes.Clone(dstIP))
+		if err != nil {
+			return
+		}
+		original := bytes.Clone(payload)
+		valid := checkPacketChecksum(v6, payload)
+		if !bytes.Equal(original, payload) {
+			t.Fatalf("Payload was modified")
+		}
+		if len(payload) < 4 {
+			if valid {
+				t.Fatalf("Payload without a checksum field was accepted")
+			}
+			return
+		}
+
+		// Filling in the calculated checksum always results in a valid packet
+		payload = original
+		payload[2], payload[3] = 0, 0
+		binary.BigEndian.PutUint16(payload[2:], calculateChecksum(v6, payload))
+		if !checkPacketChecksum(v6, payload) {
+			t.Fatalf("Calculated checksum %x is rejected for payload %x", payload[2:4], payload)
+		}
+	})
+}
+
+func FuzzBuildNDPPacket(f *testing.F) {
+	for _, tc := range goldenPackets {
+		f.Add([]byte(net.ParseIP(tc.srcIP)), []byte(net.ParseIP(tc.dstIP)), []byte(net.ParseIP(tc.target)), []byte(tc.mac), tc.ndpType == ndpSol)
+	}
+	f.Fuzz(func(t *testing.T, srcIP []byte, dstIP []byte, target []byte, mac []byte, solicitation bool) {
+		packetType := ndpAdv
+		if solicitation {
+			packetType = ndpSol
+		}
+		packet, err := buildNDPPacket(srcIP, dstIP, target, mac, packetType)
+		if err != nil {
+			return
+		}
+		wantLen := 72
+		if solicitation && bytes.Equal(srcIP, emptyIpv6) {
+			// Without the source link-layer address option
+			wantLen = 64
+		}
+		if len(packet) != wantLen {
+			t.Fatalf("Unexpected packet length %d", len(packet))
+		}
+
+		// Everything that is built has to be accepted by the decoder
+		frame := append([]byte{0x33, 0x33, 0, 0, 0, 0x01, 0x02, 0, 0, 0, 0, 0xff, 0x86, 0xdd}, packet...)
+		req, reason, _ := parseFrame("test", frame, nil)
+		if req == nil {
+			t.Fatalf("Built packet was dropped: %s", reason)
+		}
+		if req.requestType != packetType || !bytes.Equal(req.answeringForIP, target) ||
+			!bytes.Equal(req.srcIP, srcIP) || !bytes.Equal(req.dstIP, dstIP) {
+			t.Fatalf("Packet %x was not decoded correctly", packet)
+		}
+		v6, _ := newIpv6Header(req.srcIP, req.dstIP)
+		if !checkPacketChecksum(v6, req.payload) {
+			t.Fatalf("Built packet %x has an invalid checksum", packet)
+		}
+	})
+}
+
 func mustParseNetIP(cidr string) *net.IPNet {
 	_, result, _ := net.ParseCIDR(cidr)
 	return result
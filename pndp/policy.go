@@ -0,0 +1,86 @@
+package pndp
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// Decision is the verdict of a TargetPolicy
+type Decision int
+
+const (
+	// PolicyAbstain leaves the decision to the next policy in the chain
+	PolicyAbstain Decision = 0
+	// PolicyAllow answers for the target without consulting the remaining policies
+	PolicyAllow Decision = 1
+	// PolicyDeny drops the request without consulting the remaining policies
+	PolicyDeny Decision = 2
+)
+
+func (v Decision) LogValue() slog.Value {
+	switch v {
+	case PolicyAbstain:
+		return slog.StringValue("abstain")
+	case PolicyAllow:
+		return slog.StringValue("allow")
+	case PolicyDeny:
+		return slog.StringValue("deny")
+	default:
+		return slog.StringValue("unknown")
+	}
+}
+
+// TargetPolicy decides whether an instance may answer for (or proxy) a target address.
+// Policies are consulted after the built-in link-local check and the filter (whitelist) have passed.
+//
+// iface - The interface the solicitation was received on
+//
+// asker - The source address of the solicitation (the unspecified address during duplicate address detection)
+//
+// target - The address that is being solicited
+//
+// Allow may be called concurrently from multiple instances and should return quickly
+// as it is called for every solicitation.
+type TargetPolicy interface {
+	Allow(ctx context.Context, iface string, asker net.IP, target net.IP) Decision
+}
+
+var (
+	policyMutex sync.RWMutex
+	policyList  = make(map[string]TargetPolicy)
+)
+
+// RegisterPolicy makes a TargetPolicy available under the given name, so that it can be attached to
+// instances from the config file. Modules call this from their init() function (next to modules.RegisterModule),
+// so that the policy is known before the config file is parsed.
+func RegisterPolicy(name string, policy TargetPolicy) {
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+	if _, exists := policyList[name]; exists {
+		showFatalError("A policy with the name", name, "is already registered")
+	}
+	policyList[name] = policy
+}
+
+// GetPolicy returns the TargetPolicy registered under the given name or nil if there is none
+func GetPolicy(name string) TargetPolicy {
+	policyMutex.RLock()
+	defer policyMutex.RUnlock()
+	return policyList[name]
+}
+
+// evaluatePolicies runs the policies in order. The first policy that does not abstain decides.
+// The target is allowed if all policies abstain.
+func evaluatePolicies(ctx context.Context, policies []TargetPolicy, iface string, asker []byte, target []byte) bool {
+	for i := range policies {
+		decision := policies[i].Allow(ctx, iface, asker, target)
+		if decision == PolicyAbstain {
+			continue
+		}
+		slog.Debug("Policy decision", "policy", i, "decision", decision, "ip", ipValue{target})
+		return decision == PolicyAllow
+	}
+	return true
+}
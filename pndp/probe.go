@@ -0,0 +1,175 @@
+package pndp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"pndpd/pndp/ndp"
+)
+
+// ethernetHeaderLen is the length of the Ethernet header of the frames that PacketConns read
+const ethernetHeaderLen = 14
+
+// minIPv6MTU is the smallest MTU of links that carry IPv6 (RFC 8200). It is assumed for interfaces that report no MTU.
+const minIPv6MTU = 1280
+
+// ProbeResult is a Neighbor Advertisement received in response to a probe
+type ProbeResult struct {
+	Source    net.IP
+	SourceMAC net.HardwareAddr
+	// TargetMAC is the target link-layer address option of the advertisement (nil if absent)
+	TargetMAC net.HardwareAddr
+	Router    bool
+	Solicited bool
+	Override  bool
+	// RTT is the time between sending the solicitation and receiving the advertisement
+	RTT time.Duration
+}
+
+// Probe sends a Neighbor Solicitation for target on iface and returns all advertisements for target
+// that are received within timeout. The solicitation is sent to the solicited-node multicast address of
+// target unless unicast is set. If source is nil, an address of iface is selected as for answers of instances.
+func Probe(network Network, iface string, source net.IP, target net.IP, unicast bool, timeout time.Duration) ([]ProbeResult, error) {
+	niface, source, err := probeInterface(network, iface, source, target)
+	if err != nil {
+		return nil, err
+	}
+	dst := solicitedNodeAddress(target)
+	if unicast {
+		dst = target.To16()
+	}
+	packet, err := buildNDPPacket(source, dst, target.To16(), niface.HardwareAddr, ndpSol)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := openComplete(network, iface)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	sent := time.Now()
+	if err := conn.WritePacket(packet, dst); err != nil {
+		return nil, err
+	}
+	timer := time.AfterFunc(timeout, func() { _ = conn.Close() })
+	defer timer.Stop()
+
+	var results []ProbeResult
+	for {
+		// Answers may carry options beyond maxFrameLen (such as the nonce of enhanced DAD)
+		buf := make([]byte, ethernetHeaderLen+max(niface.MTU, minIPv6MTU))
+		n, err := conn.ReadFrame(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return results, nil
+		}
+		if err != nil {
+			return results, err
+		}
+		if result, ok := parseProbeAnswer(buf[:n], target); ok {
+			result.RTT = time.Since(sent)
+			results = append(results, result)
+		}
+	}
+}
+
+// parseProbeAnswer decodes a frame and checks that it is a Neighbor Advertisement for target
+func parseProbeAnswer(frame []byte, target net.IP) (ProbeResult, bool) {
+	if len(frame) < 14 {
+		return ProbeResult{}, false
+	}
+	p, err := ndp.ParsePacket(frame[14:])
+	if err != nil {
+		return ProbeResult{}, false
+	}
+	na, ok := p.Message.(*ndp.NeighborAdvertisement)
+	if !ok || !na.TargetAddress.Equal(target) {
+		return ProbeResult{}, false
+	}
+	result := ProbeResult{
+		Source:    p.Source,
+		SourceMAC: bytes.Clone(frame[6:12]),
+		Router:    na.Router,
+		Solicited: na.Solicited,
+		Override:  na.Override,
+	}
+	for _, option := range na.Options {
+		if lla, ok := option.(*ndp.LinkLayerAddress); ok && lla.Target {
+			result.TargetMAC = lla.Addr
+		}
+	}
+	return result, true
+}
+
+// Announce sends an unsolicited Neighbor Advertisement with the override flag for addr to all nodes on iface,
+// so that neighbors update their cache entry for addr to the hardware address of iface (or mac if it is not nil).
+// If source is nil, an address of iface is selected as for answers of instances.
+func Announce(network Network, iface string, source net.IP, addr net.IP, mac net.HardwareAddr, router bool) error {
+	niface, source, err := probeInterface(network, iface, source, addr)
+	if err != nil {
+		return err
+	}
+	if mac == nil {
+		mac = niface.HardwareAddr
+	}
+	packet, err := buildNDPAdvertisement(source, allNodesLinkLocal, addr.To16(), mac, naFlags{router: router, override: true})
+	if err != nil {
+		return err
+	}
+
+	conn, err := network.Open(iface)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	return conn.WritePacket(packet, allNodesLinkLocal)
+}
+
+// openComplete opens a PacketConn on iface whose frames are not truncated if network supports it (see CompleteFrameOpener)
+func openComplete(network Network, iface string) (PacketConn, error) {
+	if opener, ok := network.(CompleteFrameOpener); ok {
+		return opener.OpenComplete(iface)
+	}
+	return network.Open(iface)
+}
+
+// probeInterface looks up iface and selects the source address for packets about target
+func probeInterface(network Network, iface string, source net.IP, target net.IP) (*net.Interface, net.IP, error) {
+	if target.To4() != nil || target.To16() == nil {
+		return nil, nil, fmt.Errorf("%s is not an IPv6 address", target)
+	}
+	niface, err := network.InterfaceByName(iface)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(niface.HardwareAddr) != 6 {
+		return nil, nil, fmt.Errorf("interface %s has no Ethernet address", iface)
+	}
+	if source != nil {
+		return niface, source.To16(), nil
+	}
+	addrs, err := network.Addrs(niface)
+	if err != nil {
+		return nil, nil, err
+	}
+	gua, ula := selectSourceIP(addrs)
+	source = gua
+	if ulaSpace.Contains(target) {
+		source = ula
+	}
+	if bytes.Equal(source, emptyIpv6) {
+		return nil, nil, fmt.Errorf("interface %s has no IPv6 address", iface)
+	}
+	return niface, source, nil
+}
+
+// solicitedNodeAddress returns the solicited-node multicast address ff02::1:ffXX:XXXX of ip
+func solicitedNodeAddress(ip net.IP) net.IP {
+	ip = ip.To16()
+	return net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0xff, ip[13], ip[14], ip[15]}
+}
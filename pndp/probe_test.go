@@ -0,0 +1,78 @@
+package pndp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"pndpd/pndp/ndp"
+)
+
+func TestProbe(t *testing.T) {
+	network := NewMemoryNetwork()
+	link := network.AddLink("mem-probe", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+
+	type probeOutcome struct {
+		results []ProbeResult
+		err     error
+	}
+	done := make(chan probeOutcome)
+	go func() {
+		results, err := Probe(network, "mem-probe", nil, testTarget, false, 500*time.Millisecond)
+		done <- probeOutcome{results, err}
+	}()
+
+	sent := expectPacket(t, link)
+	checkTestPacket(t, sent, ndpSol, net.ParseIP("fd00::1"), testSolNode, testTarget, testExtMAC)
+
+	link.Inject(buildTestFrame(t, testHostMAC, net.ParseIP("fd00::7"), net.ParseIP("fd00::1"), net.ParseIP("fd01::7"), ndpAdv))
+	// The answer carries a nonce option, which makes it longer than the frames passed to instances
+	answer, err := (&ndp.Packet{Source: testTarget, Destination: net.ParseIP("fd00::1"), HopLimit: 255, Message: &ndp.NeighborAdvertisement{
+		Solicited:     true,
+		Override:      true,
+		TargetAddress: testTarget,
+		Options: []ndp.Option{
+			&ndp.LinkLayerAddress{Target: true, Addr: testHostMAC},
+			&ndp.RawOption{OptionType: ndp.OptionNonce, Value: []byte{1, 2, 3, 4, 5, 6}},
+		},
+	}}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	link.Inject(append(append(append(append([]byte(nil), testExtMAC...), testHostMAC...), 0x86, 0xdd), answer...))
+
+	outcome := <-done
+	if outcome.err != nil {
+		t.Fatal(outcome.err)
+	}
+	if len(outcome.results) != 1 {
+		t.Fatalf("Expected a single advertisement for the target, but got %d", len(outcome.results))
+	}
+	result := outcome.results[0]
+	if !result.Source.Equal(testTarget) || result.SourceMAC.String() != testHostMAC.String() || result.TargetMAC.String() != testHostMAC.String() {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if result.Router || !result.Solicited || !result.Override || result.RTT <= 0 {
+		t.Errorf("Unexpected flags or RTT %+v", result)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	network := NewMemoryNetwork()
+	link := network.AddLink("mem-announce", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+
+	if err := Announce(network, "mem-announce", nil, testTarget, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	sent := expectPacket(t, link)
+	packet := sent.Packet
+	if !sent.Dst.Equal(net.ParseIP("ff02::1")) || !net.IP(packet[8:24]).Equal(net.ParseIP("fd00::1")) {
+		t.Errorf("Expected an advertisement from fd00::1 to ff02::1, but got %s -> %s", net.IP(packet[8:24]), sent.Dst)
+	}
+	if packet[40] != 0x88 || packet[44] != 0x20 {
+		t.Errorf("Expected an unsolicited advertisement with only the override flag, but got type %d flags %x", packet[40], packet[44])
+	}
+	if !net.IP(packet[48:64]).Equal(testTarget) || net.HardwareAddr(packet[66:72]).String() != testExtMAC.String() {
+		t.Errorf("Unexpected target %s or link-layer address %s", net.IP(packet[48:64]), net.HardwareAddr(packet[66:72]))
+	}
+}
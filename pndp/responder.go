@@ -2,178 +2,78 @@ package pndp
 
 import (
 	"bytes"
+	"errors"
 	"log/slog"
 	"net"
-	"sync"
-	"syscall"
+
+	"pndpd/pndp/ndp"
 )
 
-func respond(iface string, requests chan *ndpRequest, respondType ndpType, ndpQuestionChan chan ndpQuestion, filter []*net.IPNet, autoSense string, stopWG *sync.WaitGroup, stopChan chan struct{}) {
-	stopWG.Add(1)
-	defer stopWG.Done()
+// naFlags are the flags of a Neighbor Advertisement
+type naFlags struct {
+	router    bool
+	solicited bool
+	override  bool
+}
 
-	var autoiface *net.Interface
-	if autoSense != "" {
-		var err error
-		autoiface, err = net.InterfaceByName(autoSense)
-		if err != nil {
-			showFatalError(err.Error())
-		}
+// buildNDPPacket constructs an IPv6 packet carrying a Neighbor Solicitation or Advertisement for ndpTargetIP.
+// Advertisements are built as solicited answers with the override flag (see buildNDPAdvertisement for other flags).
+func buildNDPPacket(ownIP []byte, dstIP []byte, ndpTargetIP []byte, ndpTargetMac []byte, ndpType ndpType) ([]byte, error) {
+	if ndpType == ndpAdv {
+		return buildNDPAdvertisement(ownIP, dstIP, ndpTargetIP, ndpTargetMac, naFlags{solicited: true, override: true})
 	}
-
-	var ndpQuestionsList = make([]ndpQuestion, 0, 40)
-	var _, linkLocalSpace, _ = net.ParseCIDR("fe80::/10")
-
-	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.IPPROTO_RAW)
-	if err != nil {
-		showFatalError(err.Error())
+	if len(ownIP) != 16 || len(dstIP) != 16 || len(ndpTargetIP) != 16 {
+		return nil, errors.New("malformed IP")
 	}
-	defer func(fd int) {
-		_ = syscall.Close(fd)
-	}(fd)
-	slog.Debug("Obtained fd", "fd", fd)
-
-	err = syscall.BindToDevice(fd, iface)
-	if err != nil {
-		showFatalError(err.Error())
+	if len(ndpTargetMac) != 6 {
+		return nil, errors.New("malformed MAC")
 	}
-	slog.Debug("Bound to interface", "fd", fd, "interface", iface)
-
-	respondIface, err := net.InterfaceByName(iface)
-	if err != nil {
-		showFatalError(err.Error())
+	solicitation := &ndp.NeighborSolicitation{TargetAddress: ndpTargetIP}
+	if !bytes.Equal(ownIP, emptyIpv6) {
+		// Solicitations for Duplicate Address Detection must not include the option (RFC 4861 7.2.2)
+		solicitation.Options = []ndp.Option{&ndp.LinkLayerAddress{Addr: ndpTargetMac}}
 	}
-
-	for {
-		var req *ndpRequest
-		if (ndpQuestionChan == nil && respondType == ndpAdv) || (ndpQuestionChan != nil && respondType == ndpSol) {
-			select {
-			case <-stopChan:
-				return
-			case req = <-requests:
-			}
-		} else {
-			// This is if ndpQuestionChan != nil && respondType == ndp_ADV
-			select {
-			case <-stopChan:
-				return
-			case q := <-ndpQuestionChan:
-				ndpQuestionsList = append(ndpQuestionsList, q)
-				ndpQuestionsList = cleanupQuestionList(ndpQuestionsList)
-				continue
-			case req = <-requests:
-			}
-		}
-
-		v6Header, err := newIpv6Header(req.srcIP, req.dstIP)
-		if err != nil {
-			continue
-		}
-		if !checkPacketChecksum(v6Header, req.payload) {
-			continue
-		}
-
-		if linkLocalSpace.Contains(req.answeringForIP) {
-			slog.Debug("Dropping packet asking for a link-local IP")
-			continue
-		}
-
-		// Auto-sense
-		if autoSense != "" {
-			filter = getInterfaceInfo(autoiface).networks
-		}
-
-		if filter != nil {
-			ok := false
-			for _, i := range filter {
-				if i.Contains(req.answeringForIP) {
-					slog.Debug("Responding for whitelisted IP", "ip", ipValue{req.answeringForIP})
-					ok = true
-					break
-				}
-			}
-			if !ok {
-				continue
-			}
-		}
-
-		if req.sourceIface == iface {
-			slog.Debug("Sending packet", "type", respondType, "dest", ipValue{req.dstIP}, "interface", respondIface.Name)
-			sendNDPPacket(fd, req.answeringForIP, req.srcIP, req.answeringForIP, respondIface.HardwareAddr, respondType)
-		} else {
-			// An address from the interface needs to be used instead of the one from the packet
-			intInfo := getInterfaceInfo(respondIface)
-			var selectedSelfSourceIPGua = intInfo.sourceIP
-			var selectedSelfSourceIPUla = intInfo.sourceIPULA
-			var selectedSelfSourceIP = selectedSelfSourceIPGua
-			if ulaSpace.Contains(req.answeringForIP) {
-				selectedSelfSourceIP = selectedSelfSourceIPUla
-			}
-
-			if respondType == ndpAdv {
-				if !bytes.Equal(req.dstIP, allNodesMulticastIPv6) { // Skip in case of unsolicited advertisement
-					success := false
-					req.dstIP, success = getAddressFromQuestionList(req.answeringForIP, &ndpQuestionsList)
-					if !success {
-						slog.Debug("Nobody has asked for this IP", "ip", ipValue{req.answeringForIP})
-						continue
-					}
-				}
-			} else {
-				if bytes.Equal(req.srcIP, emptyIpv6) {
-					// Duplicate Address detection is in progress
-					selectedSelfSourceIP = emptyIpv6
-				} else {
-					ndpQuestionChan <- ndpQuestion{
-						targetIP: req.answeringForIP,
-						askedBy:  req.srcIP,
-					}
-				}
-			}
-			slog.Debug("Sending packet", "type", respondType, "dest", ipValue{req.dstIP}, "interface", respondIface.Name, "targetIP", ipValue{req.answeringForIP}, "srcIP", ipValue{selectedSelfSourceIP}, "ndpTargetMac", macValue{respondIface.HardwareAddr})
-			sendNDPPacket(fd, selectedSelfSourceIP, req.dstIP, req.answeringForIP, respondIface.HardwareAddr, respondType)
-		}
+	packet := ndp.Packet{
+		Source:      ownIP,
+		Destination: dstIP,
+		HopLimit:    255,
+		Message:     solicitation,
 	}
+	return packet.Marshal()
 }
 
-func sendNDPPacket(fd int, ownIP []byte, dstIP []byte, ndpTargetIP []byte, ndpTargetMac []byte, ndpType ndpType) {
-	v6, err := newIpv6Header(ownIP, dstIP)
-	if err != nil {
-		return
+// buildNDPAdvertisement constructs an IPv6 packet carrying a Neighbor Advertisement for ndpTargetIP with the given flags
+func buildNDPAdvertisement(ownIP []byte, dstIP []byte, ndpTargetIP []byte, ndpTargetMac []byte, flags naFlags) ([]byte, error) {
+	if len(ownIP) != 16 || len(dstIP) != 16 || len(ndpTargetIP) != 16 {
+		return nil, errors.New("malformed IP")
 	}
-	NDPa, err := newNdpPacket(ndpTargetIP, ndpTargetMac, ndpType)
-	if err != nil {
-		return
+	if len(ndpTargetMac) != 6 {
+		return nil, errors.New("malformed MAC")
 	}
-	v6.addPayload(NDPa)
-	packet := v6.constructPacket()
-
-	if err := syscall.Sendto(fd, packet, 0, &syscall.SockaddrInet6{
-		Addr: [16]byte(dstIP),
-	}); err != nil {
-		slog.Error("Error sending packet", "error", err)
+	packet := ndp.Packet{
+		Source:      ownIP,
+		Destination: dstIP,
+		HopLimit:    255,
+		Message: &ndp.NeighborAdvertisement{
+			Router:        flags.router,
+			Solicited:     flags.solicited,
+			Override:      flags.override,
+			TargetAddress: ndpTargetIP,
+			Options:       []ndp.Option{&ndp.LinkLayerAddress{Target: true, Addr: ndpTargetMac}},
+		},
 	}
+	return packet.Marshal()
 }
 
-func getAddressFromQuestionList(targetIP []byte, ndpQuestionsList *[]ndpQuestion) ([]byte, bool) {
-	for i := range *ndpQuestionsList {
-		if bytes.Equal((*ndpQuestionsList)[i].targetIP, targetIP) {
-			result := (*ndpQuestionsList)[i].askedBy
-			*ndpQuestionsList = removeFromQuestionList(*ndpQuestionsList, i)
-			return result, true
-		}
+func sendNDPPacket(conn PacketConn, packet []byte, dstIP []byte) {
+	if err := conn.WritePacket(packet, dstIP); err != nil {
+		slog.Error("Error sending packet", "error", err)
 	}
-	return nil, false
-}
-func removeFromQuestionList(s []ndpQuestion, i int) []ndpQuestion {
-	// Remove while keeping the order
-	return append(s[:i], s[i+1:]...)
 }
 
-func cleanupQuestionList(s []ndpQuestion) []ndpQuestion {
-	toRemove := len(s) - 40
-	if toRemove <= 0 {
-		return s
+// sendNDPFrame sends packet in an Ethernet frame from srcMAC to dstMAC
+func sendNDPFrame(w FrameWriter, srcMAC net.HardwareAddr, dstMAC net.HardwareAddr, packet []byte) {
+	if err := w.WriteFrame(ethernetFrame(dstMAC, srcMAC, packet)); err != nil {
+		slog.Error("Error sending frame", "error", err)
 	}
-	return s[toRemove:]
 }
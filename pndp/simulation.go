@@ -0,0 +1,195 @@
+package pndp
+
+import (
+	"context"
+	"net"
+	"slices"
+	"time"
+)
+
+// Simulation runs the decision logic of proxy and responder instances on recorded frames.
+// No sockets are opened. The state of the interfaces is provided with SetInterface.
+type Simulation struct {
+	interfaces map[string]InterfaceEvent
+	instances  []*simulatedInstance
+	groups     []*ProxyGroup
+	capture    *Capture
+}
+
+type simulatedInstance struct {
+	name   string
+	config EngineConfig
+	engine *Engine
+}
+
+// SimulationResult is the outcome of a single frame for a single instance
+type SimulationResult struct {
+	// Index is the position of the frame in the frames passed to Run
+	Index int
+	Frame CapturedFrame
+	// Instance describes the instance that handled the frame. It is empty if no instance uses the interface of the frame.
+	Instance string
+	Actions  []Action
+}
+
+type simulationClock struct {
+	now time.Time
+}
+
+func (c *simulationClock) Now() time.Time { return c.now }
+
+func NewSimulation() *Simulation {
+	return &Simulation{interfaces: make(map[string]InterfaceEvent)}
+}
+
+// SetInterface sets the hardware and IP addresses of an interface. If mac is nil, a hardware address is generated.
+// Interfaces without state get a generated hardware address and no IP addresses.
+func (s *Simulation) SetInterface(name string, mac net.HardwareAddr, addrs ...net.Addr) {
+	if mac == nil {
+		mac = s.generateMAC()
+	}
+	s.interfaces[name] = InterfaceEvent{Iface: name, HardwareAddr: mac, Addrs: addrs}
+}
+
+// generateMAC returns a locally administered address that differs for every interface
+func (s *Simulation) generateMAC() net.HardwareAddr {
+	return net.HardwareAddr{0x02, 0xff, 0, 0, 0, byte(len(s.interfaces) + 1)}
+}
+
+// SetCapture writes the received frames (with the decisions as comments) and the packets that would have been sent to c
+func (s *Simulation) SetCapture(c *Capture) {
+	s.capture = c
+}
+
+// AddProxy adds a proxy instance. The instance must not be started.
+func (s *Simulation) AddProxy(obj *ProxyObj) {
+	config := obj.engineConfig()
+	s.instances = append(s.instances, &simulatedInstance{name: config.name(), config: config})
+}
+
+// AddProxyGroup adds the instances of a proxy group: one for every interface matching the pattern of the group that
+// has state (see SetInterface) or that frames were received on. The group must not be started.
+func (s *Simulation) AddProxyGroup(group *ProxyGroup) {
+	s.groups = append(s.groups, group)
+}
+
+// AddResponder adds a responder instance. The instance must not be started.
+func (s *Simulation) AddResponder(obj *ResponderObj) {
+	config := obj.engineConfig()
+	s.instances = append(s.instances, &simulatedInstance{name: config.name(), config: config})
+}
+
+// Run passes the frames in order to all instances that use the interface of the frame.
+// Frames without an interface name (such as those from pcap files) are treated as received on defaultIface.
+// Frames recorded as sent are skipped. report is called for every frame and instance.
+func (s *Simulation) Run(ctx context.Context, frames []CapturedFrame, defaultIface string, report func(SimulationResult)) {
+	clock := &simulationClock{}
+	if len(frames) != 0 {
+		clock.now = frames[0].Time
+	}
+	lastTick := clock.now
+
+	instances := append(s.instances[:len(s.instances):len(s.instances)], s.groupInstances(frames, defaultIface)...)
+	for _, instance := range instances {
+		instance.engine = NewEngine(instance.config, clock)
+		for _, iface := range instance.config.Interfaces() {
+			instance.engine.Handle(ctx, s.interfaceEvent(iface))
+		}
+	}
+
+	for i, frame := range frames {
+		if frame.Outbound {
+			continue
+		}
+		if frame.Iface == "" {
+			frame.Iface = defaultIface
+		}
+		// The same frames are passed to the instances as by the BPF filter of the listening sockets
+		if !isNDPFrame(frame.Frame) {
+			continue
+		}
+		length := max(frame.Length, len(frame.Frame))
+		if len(frame.Frame) > maxFrameLen {
+			frame.Frame = frame.Frame[:maxFrameLen]
+		}
+
+		if frame.Time.After(clock.now) {
+			clock.now = frame.Time
+		}
+		if clock.now.Sub(lastTick) >= tickInterval {
+			lastTick = clock.now
+			for _, instance := range instances {
+				instance.engine.Handle(ctx, TickEvent{})
+			}
+		}
+
+		handled := false
+		for _, instance := range instances {
+			if frame.Iface != instance.config.Iface1 && frame.Iface != instance.config.Iface2 {
+				continue
+			}
+			handled = true
+			actions := instance.engine.Handle(ctx, PacketEvent{Iface: frame.Iface, Frame: frame.Frame, Length: length})
+			s.captureResult(instance.name, frame, length, actions)
+			report(SimulationResult{Index: i, Frame: frame, Instance: instance.name, Actions: actions})
+		}
+		if !handled {
+			report(SimulationResult{Index: i, Frame: frame})
+		}
+	}
+}
+
+// groupInstances returns the instances of the proxy groups for the interfaces that are known to the simulation
+func (s *Simulation) groupInstances(frames []CapturedFrame, defaultIface string) []*simulatedInstance {
+	var names []string
+	for name := range s.interfaces {
+		names = append(names, name)
+	}
+	for _, frame := range frames {
+		if frame.Iface != "" {
+			names = append(names, frame.Iface)
+		}
+	}
+	names = append(names, defaultIface)
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	var result []*simulatedInstance
+	for _, group := range s.groups {
+		pattern := group.template.engineConfig().Iface2
+		for _, name := range names {
+			if matchInterface(pattern, name) {
+				config := group.template.forInterface(name).engineConfig()
+				result = append(result, &simulatedInstance{name: config.name(), config: config})
+			}
+		}
+	}
+	return result
+}
+
+func (s *Simulation) interfaceEvent(iface string) InterfaceEvent {
+	if event, ok := s.interfaces[iface]; ok {
+		return event
+	}
+	event := InterfaceEvent{
+		Iface:        iface,
+		HardwareAddr: s.generateMAC(),
+	}
+	s.interfaces[iface] = event
+	return event
+}
+
+func (s *Simulation) captureResult(instance string, frame CapturedFrame, length int, actions []Action) {
+	if s.capture == nil {
+		return
+	}
+	iface := captureInterface{name: frame.Iface, snapLen: maxFrameLen}
+	if err := s.capture.writeFrameAt(frame.Time, iface, frame.Frame, length, true, instance+": "+describeDecisions(actions)); err != nil {
+		showCaptureError(err)
+	}
+	for _, a := range actions {
+		if send, ok := a.(SendAction); ok {
+			captureSent(s.capture, frame.Time, s.interfaces[send.Iface].HardwareAddr, send, true)
+		}
+	}
+}
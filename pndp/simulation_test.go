@@ -0,0 +1,94 @@
+package pndp
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSimulation(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	solicitation := buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol)
+	frames := []CapturedFrame{
+		// Without an interface name the frame is treated as received on the default interface
+		{Time: start, Frame: solicitation},
+		{Iface: "int", Time: start.Add(100 * time.Millisecond), Outbound: true, Frame: solicitation},
+		{Iface: "int", Time: start.Add(200 * time.Millisecond), Frame: buildTestFrame(t, testHostMAC, testTarget, testAsker, testTarget, ndpAdv)},
+		{Iface: "other", Time: start.Add(300 * time.Millisecond), Frame: solicitation},
+		// The question has expired, so the advertisement is not proxied
+		{Iface: "ext", Time: start.Add(testInterval), Frame: solicitation},
+		{Iface: "int", Time: start.Add(2 * testInterval), Frame: buildTestFrame(t, testHostMAC, testTarget, testAsker, testTarget, ndpAdv)},
+	}
+
+	simulation := NewSimulation()
+	simulation.SetInterface("ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+	simulation.SetInterface("int", nil, mustParseIfaceIP("fd01::1/64"))
+	simulation.AddProxy(NewProxy("ext", "int", nil, "int", true))
+
+	path := filepath.Join(t.TempDir(), "simulation.pcapng")
+	capture, err := OpenCapture(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	simulation.SetCapture(capture)
+
+	got := make(map[int][]string)
+	simulation.Run(context.Background(), frames, "ext", func(result SimulationResult) {
+		got[result.Index] = append(got[result.Index], result.Instance)
+		for _, action := range result.Actions {
+			got[result.Index] = append(got[result.Index], describeAction(action))
+		}
+	})
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int][]string{
+		0: {"proxy ext/int", "install ext fd01::99 asked by fd00::5", "send int ns fd01::1 -> ff02::1:ff00:99 for fd01::99"},
+		2: {"proxy ext/int", "send ext na fd00::1 -> fd00::5 for fd01::99"},
+		3: {""},
+		4: {"proxy ext/int", "install ext fd01::99 asked by fd00::5", "send int ns fd01::1 -> ff02::1:ff00:99 for fd01::99"},
+		5: {"proxy ext/int", "emit int not-asked"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, but got %v", want, got)
+	}
+
+	// Every handled frame is followed by the packets that would have been sent
+	packets := readCapture(t, path)
+	if len(packets) != 7 {
+		t.Fatalf("Expected 7 packets in the capture, but got %d", len(packets))
+	}
+	if !packets[1].Outbound || packets[1].Iface != "int" || packets[1].Frame[6] != 0x02 || packets[1].Frame[7] != 0xff {
+		t.Errorf("Expected the solicitation to be sent from the generated address of int, but got %+v", packets[1])
+	}
+}
+
+func TestSimulationProxyGroup(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	solicitation := buildTestFrame(t, testHostMAC, testAsker, testSolNode, testTarget, ndpSol)
+	frames := []CapturedFrame{
+		{Iface: "ext", Time: start, Frame: solicitation},
+		{Iface: "ppp1", Time: start.Add(100 * time.Millisecond), Frame: buildTestFrame(t, testHostMAC, testTarget, testAsker, testTarget, ndpAdv)},
+	}
+
+	simulation := NewSimulation()
+	simulation.SetInterface("ext", testExtMAC, mustParseIfaceIP("fd00::1/64"))
+	// ppp0 only has state, ppp1 is only known from its frames
+	simulation.SetInterface("ppp0", nil, mustParseIfaceIP("fd01::1/64"))
+	simulation.AddProxyGroup(NewProxyGroup(NewProxy("ext", "ppp*", ParseFilter("fd01::/64"), "", true)))
+
+	got := make(map[int][]string)
+	simulation.Run(context.Background(), frames, "ext", func(result SimulationResult) {
+		got[result.Index] = append(got[result.Index], result.Instance)
+	})
+	want := map[int][]string{
+		0: {"proxy ext/ppp0", "proxy ext/ppp1"},
+		1: {"proxy ext/ppp1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, but got %v", want, got)
+	}
+}
@@ -0,0 +1,168 @@
+package pndp
+
+import (
+	"bytes"
+	"net"
+	"slices"
+)
+
+// SourceAddress selects the source address of the packets an instance sends
+type SourceAddress int
+
+const (
+	// SourceAuto uses the first ULA of the interface for ULA targets and the first GUA otherwise,
+	// or the link-local address if there is none. Responders answer from the target address.
+	SourceAuto SourceAddress = 0
+	// SourceLinkLocal uses the link-local address of the interface, as expected by RFC 4861
+	SourceLinkLocal SourceAddress = 1
+	// SourceMatchingPrefix uses the address of the interface in the prefix of the destination, or else in the prefix
+	// of the target. Packets for which there is no such address are sent as with SourceAuto.
+	SourceMatchingPrefix SourceAddress = 2
+	// SourceFixed uses EngineConfig.SourceIP
+	SourceFixed SourceAddress = 3
+	// SourceRFC6724 selects among the addresses of the interface with the default address selection of RFC 6724
+	SourceRFC6724 SourceAddress = 4
+)
+
+// policyLabel is an entry of the default policy table of RFC 6724 (section 2.1). Precedences are only used to order
+// destinations and are left out.
+type policyLabel struct {
+	prefix *net.IPNet
+	label  int
+}
+
+// defaultPolicyTable is ordered from the longest to the shortest prefix, so that the first match is the longest one
+var defaultPolicyTable = []policyLabel{
+	{mustParseCIDR("::1/128"), 0},
+	{mustParseCIDR("::ffff:0:0/96"), 4},
+	{mustParseCIDR("::/96"), 3},
+	{mustParseCIDR("2001::/32"), 5},
+	{mustParseCIDR("2002::/16"), 2},
+	{mustParseCIDR("3ffe::/16"), 12},
+	{mustParseCIDR("fec0::/10"), 11},
+	{mustParseCIDR("fc00::/7"), 13},
+	{mustParseCIDR("::/0"), 1},
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, result, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func label(ip net.IP) int {
+	for _, entry := range defaultPolicyTable {
+		if entry.prefix.Contains(ip) {
+			return entry.label
+		}
+	}
+	return 1
+}
+
+// scope returns the scope of an address as defined for multicast addresses (RFC 4291 2.7).
+// Unique local addresses have global scope (RFC 6724 3.1).
+func scope(ip net.IP) int {
+	switch {
+	case ip.IsMulticast():
+		return int(ip[1] & 0x0f)
+	case ip.IsLoopback(), ip.IsLinkLocalUnicast():
+		return 0x2
+	default:
+		return 0xe
+	}
+}
+
+// commonPrefixLen returns the number of leading bits that source and dst have in common, up to the prefix length of source
+func commonPrefixLen(source *net.IPNet, dst net.IP) int {
+	ones, _ := source.Mask.Size()
+	result := 0
+	for i := 0; i < 16 && result < ones; i++ {
+		diff := source.IP[i] ^ dst[i]
+		if diff == 0 {
+			result += 8
+			continue
+		}
+		for diff&0x80 == 0 {
+			result++
+			diff <<= 1
+		}
+		break
+	}
+	return min(result, ones)
+}
+
+// selectRFC6724 returns the address of addrs that RFC 6724 (section 5) prefers as the source for packets to dst.
+// The rules about deprecated, home and temporary addresses do not apply, as these properties are not known.
+// It returns nil if there is no unicast address.
+func selectRFC6724(addrs []*net.IPNet, dst net.IP) []byte {
+	candidates := make([]*net.IPNet, 0, len(addrs))
+	for _, a := range addrs {
+		if ip := a.IP.To16(); ip != nil && !ip.IsMulticast() && !ip.IsUnspecified() {
+			candidates = append(candidates, &net.IPNet{IP: ip, Mask: a.Mask})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	dst = dst.To16()
+	// A negative result prefers a over b
+	slices.SortStableFunc(candidates, func(a, b *net.IPNet) int {
+		// Rule 1: prefer the destination itself
+		if aSame, bSame := a.IP.Equal(dst), b.IP.Equal(dst); aSame != bSame {
+			if aSame {
+				return -1
+			}
+			return 1
+		}
+		// Rule 2: prefer the appropriate scope
+		if aScope, bScope, dstScope := scope(a.IP), scope(b.IP), scope(dst); aScope != bScope {
+			if aScope < bScope {
+				if aScope < dstScope {
+					return 1
+				}
+				return -1
+			}
+			if bScope < dstScope {
+				return -1
+			}
+			return 1
+		}
+		// Rule 6: prefer a matching label
+		if aMatch, bMatch := label(a.IP) == label(dst), label(b.IP) == label(dst); aMatch != bMatch {
+			if aMatch {
+				return -1
+			}
+			return 1
+		}
+		// Rule 8: prefer the longest matching prefix
+		return commonPrefixLen(b, dst) - commonPrefixLen(a, dst)
+	})
+	return candidates[0].IP
+}
+
+// selectLinkLocal returns the first link-local address of addrs or nil if there is none
+func selectLinkLocal(addrs []*net.IPNet) []byte {
+	for _, a := range addrs {
+		if a.IP.IsLinkLocalUnicast() {
+			return a.IP.To16()
+		}
+	}
+	return nil
+}
+
+// selectMatchingPrefix returns the first address of addrs whose prefix contains one of ips (in order) or nil if there is none
+func selectMatchingPrefix(addrs []*net.IPNet, ips ...net.IP) []byte {
+	for _, ip := range ips {
+		if ip.IsMulticast() || bytes.Equal(ip, emptyIpv6) {
+			continue
+		}
+		for _, a := range addrs {
+			if a.Contains(ip) {
+				return a.IP.To16()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package pndp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSelectRFC6724(t *testing.T) {
+	addrs := []*net.IPNet{
+		mustParseIfaceIP("fd00::1/64"),
+		mustParseIfaceIP("2001:db8:1::1/64"),
+		mustParseIfaceIP("2001:db8:2::1/64"),
+		mustParseIfaceIP("fe80::1/64"),
+	}
+	for _, tc := range []struct {
+		dst  string
+		want string
+	}{
+		{"ff02::1", "fe80::1"},
+		{"ff02::1:ff00:99", "fe80::1"},
+		{"fe80::5", "fe80::1"},
+		{"2001:db8:2::5", "2001:db8:2::1"},
+		{"2001:db8:1::1", "2001:db8:1::1"},
+		{"fd00:1::5", "fd00::1"},
+		{"2a00::5", "2001:db8:1::1"},
+		{"ff0e::1", "2001:db8:1::1"},
+	} {
+		if got := net.IP(selectRFC6724(addrs, net.ParseIP(tc.dst))); !got.Equal(net.ParseIP(tc.want)) {
+			t.Errorf("Expected %s as the source for %s, but got %s", tc.want, tc.dst, got)
+		}
+	}
+	if got := selectRFC6724(nil, net.ParseIP("ff02::1")); got != nil {
+		t.Errorf("Expected no address, but got %s", net.IP(got))
+	}
+}
@@ -0,0 +1,129 @@
+#!/bin/sh
+# Regenerates ndp.pcap from the Neighbor Discovery of the Linux kernel, so that the reference frames of
+# TestGoldenPackets are produced independently of pndpd. Requires root, iproute2 and python3.
+#
+#   pndp-up (u0) ---- (e0) pndp-ext      proxy_ndp for fd01::99 and 2001:db8:1::99, forwarding enabled
+#   pndp-int (i0) ---- (h0) pndp-host    fd01::99 is added with Duplicate Address Detection
+#
+# The frames are written in the order of goldenPackets with zero timestamps.
+set -eu
+
+out=$(realpath "${1:-ndp.pcap}")
+tmp=$(mktemp -d)
+namespaces="pndp-up pndp-ext pndp-int pndp-host"
+cleanup() {
+	for n in $namespaces; do ip netns del "$n" 2>/dev/null || true; done
+	rm -rf "$tmp"
+}
+trap cleanup EXIT
+
+for n in $namespaces; do ip netns add "$n"; done
+ip link add u0 netns pndp-up address 02:00:00:00:00:aa type veth peer e0 netns pndp-ext address 02:00:00:00:00:01
+ip link add i0 netns pndp-int address 02:00:00:00:00:02 type veth peer h0 netns pndp-host address 02:00:00:00:00:bb
+
+setup() {
+	ns=$1 iface=$2
+	shift 2
+	# No generated link-local addresses, so that the capture only holds the frames of the test addresses
+	ip netns exec "$ns" sysctl -qw net.ipv6.conf."$iface".addr_gen_mode=1
+	# Without the nonce option of RFC 7527, which pndpd does not send
+	ip netns exec "$ns" sysctl -qw net.ipv6.conf.all.enhanced_dad=0 net.ipv6.conf."$iface".enhanced_dad=0
+	for addr in "$@"; do ip -n "$ns" addr add "$addr" dev "$iface" nodad; done
+	ip -n "$ns" link set "$iface" up
+}
+setup pndp-up u0 fd00::5/64 2001:db8::5/64
+setup pndp-ext e0 fd00::1/64 2001:db8::1/64
+# Unicast probes of Neighbor Unreachability Detection are sent from the link-local address
+setup pndp-int i0 fd01::1/64 fe80::1/64
+setup pndp-host h0
+ip -n pndp-up route add fd01::/64 dev u0
+ip -n pndp-up route add 2001:db8:1::/64 dev u0
+ip netns exec pndp-ext sysctl -qw net.ipv6.conf.all.forwarding=1
+ip netns exec pndp-ext sysctl -qw net.ipv6.conf.e0.proxy_ndp=1
+ip -n pndp-ext neigh add proxy fd01::99 dev e0 router
+ip -n pndp-ext neigh add proxy 2001:db8:1::99 dev e0 router
+ip netns exec pndp-int sysctl -qw net.ipv6.neigh.i0.delay_first_probe_time=1
+
+# capture writes the ICMPv6 Neighbor Solicitations and Advertisements seen on an interface to a file (length-prefixed)
+capture() {
+	ip netns exec "$1" python3 -c '
+import socket, struct, sys
+s = socket.socket(socket.AF_PACKET, socket.SOCK_RAW, socket.htons(0x86dd))
+s.bind((sys.argv[1], 0))
+with open(sys.argv[2], "wb", buffering=0) as f:
+    while True:
+        try:
+            frame = s.recv(65535)
+        except OSError:
+            continue
+        if len(frame) > 54 and frame[20] == 58 and frame[54] in (135, 136):
+            f.write(struct.pack("<I", len(frame)) + frame)
+' "$2" "$tmp/$2" &
+	pids="$pids $!"
+}
+pids=""
+capture pndp-up u0
+capture pndp-ext e0
+capture pndp-int i0
+capture pndp-host h0
+sleep 1
+
+send() {
+	ip netns exec "$1" python3 -c '
+import socket, sys
+s = socket.socket(socket.AF_INET6, socket.SOCK_DGRAM)
+s.bind((sys.argv[1], 0))
+s.sendto(b"x", (sys.argv[2], 9))
+' "$2" "$3"
+}
+
+# Duplicate Address Detection of fd01::99, followed by an unsolicited advertisement
+ip netns exec pndp-host sysctl -qw net.ipv6.conf.h0.ndisc_notify=1
+ip -n pndp-host addr add fd01::99/64 dev h0
+sleep 3
+# Multicast solicitation and its answer
+send pndp-int fd01::1 fd01::99
+sleep 1
+# Unicast solicitation of Neighbor Unreachability Detection
+ip -n pndp-int neigh change fd01::99 dev i0 lladdr 02:00:00:00:00:bb nud stale
+send pndp-int fd01::1 fd01::99
+sleep 3
+# Proxied advertisements
+send pndp-up fd00::5 fd01::99
+send pndp-up 2001:db8::5 2001:db8:1::99
+sleep 1
+kill $pids
+
+python3 - "$out" "$tmp"/* <<'EOF'
+import ipaddress, struct, sys
+
+# (ICMPv6 type, source, destination, target) of each frame in the order of goldenPackets
+wanted = [
+    (135, "fd01::1", "ff02::1:ff00:99", "fd01::99"),
+    (135, "::", "ff02::1:ff00:99", "fd01::99"),
+    (135, "fe80::1", "fd01::99", "fd01::99"),
+    (135, "fd00::5", "ff02::1:ff00:99", "fd01::99"),
+    (136, "fd00::1", "fd00::5", "fd01::99"),
+    (136, "fd01::99", "fd01::1", "fd01::99"),
+    (136, "2001:db8::1", "2001:db8::5", "2001:db8:1::99"),
+    (136, "fd01::99", "ff02::1", "fd01::99"),
+]
+
+frames = {}
+for path in sys.argv[2:]:
+    data = open(path, "rb").read()
+    while data:
+        n = struct.unpack("<I", data[:4])[0]
+        frame, data = data[4:4 + n], data[4 + n:]
+        key = (frame[54], str(ipaddress.IPv6Address(frame[22:38])), str(ipaddress.IPv6Address(frame[38:54])),
+               str(ipaddress.IPv6Address(frame[62:78])))
+        frames.setdefault(key, frame)
+
+with open(sys.argv[1], "wb") as f:
+    f.write(struct.pack("<IHHiIII", 0xa1b2c3d4, 2, 4, 0, 0, 65535, 1))
+    for key in wanted:
+        if key not in frames:
+            sys.exit("missing frame %s" % (key,))
+        frame = frames[key]
+        f.write(struct.pack("<IIII", 0, 0, len(frame), len(frame)) + frame)
+EOF
@@ -0,0 +1,76 @@
+package pndp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+
+	"pndpd/pndp/ndp"
+)
+
+// solicitedNodePrefix is the prefix ff02::1:ff00:0/104 of solicited-node multicast addresses
+var solicitedNodePrefix = net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0xff}
+
+// validateMessage applies the validity checks of received Neighbor Solicitations and Advertisements (RFC 4861 7.1.1
+// and 7.1.2) that go beyond the checksum, plus the additional checks of strict mode (see EngineConfig.Strict).
+// It returns an empty reason if the message is valid. The source link-layer address option is recorded in req.srcMAC.
+func validateMessage(frame []byte, req *ndpRequest, strict bool) (reason string, message string) {
+	if frame[21] != 255 {
+		// Routers decrement the hop limit, so the packet was not sent by a node on the link
+		return ReasonHopLimit, "Dropping packet with a hop limit other than 255"
+	}
+	if frame[55] != 0 {
+		return ReasonICMPCode, "Dropping packet with a non-zero ICMP code"
+	}
+	// The payload length of the IPv6 header is the ICMP length, as no extension headers are accepted
+	if binary.BigEndian.Uint16(frame[18:20]) < 24 {
+		return ReasonICMPLength, "Dropping packet with an ICMP length of less than 24 bytes"
+	}
+	if net.IP(req.answeringForIP).IsMulticast() {
+		return ReasonMulticastTarget, "Dropping packet for a multicast target"
+	}
+
+	// Options follow the 24 bytes of the message. Options that were cut off by maxFrameLen are not checked.
+	options := req.payload[24:]
+	if length := int(binary.BigEndian.Uint16(frame[18:20])) - 24; length < len(options) {
+		options = options[:max(length, 0)]
+	}
+	var sourceLLA []byte
+	for len(options) >= 2 {
+		length := int(options[1]) * 8
+		if length == 0 {
+			return ReasonOptionLength, "Dropping packet with an option of length zero"
+		}
+		if options[0] == ndp.OptionSourceLinkLayerAddress && len(options) >= 8 {
+			sourceLLA = options[2:8]
+		}
+		options = options[min(length, len(options)):]
+	}
+
+	if sourceLLA != nil {
+		req.srcMAC = sourceLLA
+	}
+
+	unspecifiedSource := bytes.Equal(req.srcIP, emptyIpv6)
+	if req.requestType == ndpAdv {
+		if net.IP(req.dstIP).IsMulticast() && req.payload[4]&0x40 != 0 {
+			return ReasonSolicitedMulticast, "Dropping solicited advertisement sent to a multicast address"
+		}
+		return "", ""
+	}
+	if unspecifiedSource && !bytes.HasPrefix(req.dstIP, solicitedNodePrefix) {
+		return ReasonDADDestination, "Dropping solicitation from the unspecified address that is not sent to a solicited-node multicast address"
+	}
+	if unspecifiedSource && sourceLLA != nil {
+		return ReasonDADSourceLLA, "Dropping solicitation from the unspecified address with a source link-layer address"
+	}
+	if strict {
+		if sourceLLA == nil && !unspecifiedSource && net.IP(req.dstIP).IsMulticast() {
+			return ReasonMissingSourceLLA, "Dropping multicast solicitation without a source link-layer address (strict mode)"
+		}
+		if sourceLLA != nil && !bytes.Equal(sourceLLA, frame[6:12]) {
+			return ReasonSourceLLAMismatch, "Dropping solicitation with a source link-layer address that differs from the Ethernet source (strict mode)"
+		}
+	}
+	return "", ""
+}